@@ -1,227 +1,930 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
-	"github.com/barasher/go-exiftool"
 	"github.com/charmbracelet/log"
-	"github.com/pkg/errors"
+
+	"photo-video-sort/m/v2/sorter"
 )
 
 func main() {
 	// Define command-line flags
 	srcDirPtr := flag.String("src", "", "source directory")
-	destDirPtr := flag.String("dest", "", "destination directory")
+	destDirPtr := flag.String("dest", "", "destination directory, or an s3://bucket/prefix URL to upload into S3 with credentials from the AWS_ environment variables; several comma separated directories are filled in turn, see -min-free")
+	minResolution := flag.String("min-resolution", "", "skip images smaller than WxH in either orientation, e.g. 1024x768, as web thumbnails are")
+	skipUnknownResolution := flag.Bool("skip-unknown-resolution", false, "with -min-resolution, also skip images whose dimensions are unknown")
+	transcodeHEIC := flag.Bool("transcode-heic-to-jpeg", false, "with -copy, write HEIC images to the destination as JPEGs with their tags, converted by heif-convert or ImageMagick")
+	keepHEIC := flag.Bool("keep-heic", false, "with -transcode-heic-to-jpeg, also copy the HEIC original next to the JPEG")
+	minFreeSpace := flag.String("min-free-space", "0", "stop before a copy or move would leave less than this free on the destination, e.g. 5GB")
+	minFree := flag.String("min-free", "0", "with several -dest directories, spill to the next one before a file would leave less than this free, e.g. 10GB")
+	preset := flag.String("preset", "", "lay the destination out the way a photo server imports its library: "+presetNames()+"; flags given on the command line override it")
 	copyFlag := flag.Bool("copy", false, "copy files instead of moving them")
-	folderFormat := flag.String("datefmt", "2006/01/02", "date format to use for organizing files (default is YYYY/MM/DD)")
-	updateExifFlag := flag.Bool("update-exif", false, "update EXIF data based on file name if no EXIF data is available")
+	folderFormat := flag.String("datefmt", "2006/01/02", "date format to use for organizing files (default is YYYY/MM/DD); may contain {srcfolder}, {dayofyear}, {epoch}, {decade}, {period:5}, {seq}, {index}, {serial}, {orientation}, {keyword}, {country}, {model} and {model-slug}")
+	nameFormat := flag.String("name", "", "template for the new file name without extension, e.g. {seq:4} or {index:6} (default keeps the original name)")
+	groupBySerial := flag.Bool("group-by-camera-serial", false, "sort files into a folder per camera body below the date folders, like appending /{serial} to -datefmt")
+	serialNames := flag.String("serial-names", "", "comma separated friendly names for camera serial numbers, e.g. 12345=CameraA,67890=CameraB")
+	appendOriginal := flag.Bool("append-original-name", false, "with -name, append the original file name, e.g. 20230501_120000_beach.jpg")
+	keywordFallback := flag.String("keyword-fallback", "Untagged", "what {keyword} expands to for files without keywords")
+	iphoneEdits := flag.String("iphone-edits", "", "pair iPhone IMG_E edited copies with their originals: together, prefer-edited or suffix (default treats them separately)")
+	updateExifFlag := flag.Bool("update-exif", false, "update EXIF data based on file name if no EXIF data is available; only the sorted file at the destination is rewritten, never the source, whether copying or moving")
+	exifBackup := flag.Bool("exif-write-backup", false, "keep exiftool's file_original backups next to files whose EXIF data is rewritten, which are otherwise overwritten in place")
+	exifBackupDir := flag.String("exif-backup-dir", "", "keep exiftool's backups of rewritten files in this directory, at their path below -dest, instead of next to them")
 	logFlag := flag.Bool("log", false, "enable logging")
+	flatMonth := flag.Int("flat-month", 0, "place files of days with fewer than this many files at month level (0 disables)")
+	monthFormat := flag.String("monthfmt", "2006/01", "date format to use for month level folders with -flat-month")
+	imageTags := flag.String("image-date-tags", strings.Join(sorter.DefaultDateTags.Image, ","), "comma separated EXIF tags to read image dates from, in order of preference; for scanned film, DateTimeOriginal is the shot date and DateTimeDigitized the scan date")
+	documentTags := flag.String("document-date-tags", strings.Join(sorter.DefaultDateTags.Document, ","), "comma separated tags to read the dates of PDFs sorted with -include-documents from, in order of preference; undated documents fall back to the file name, then the modification time")
+	fileTags := flag.String("file-date-tags", strings.Join(sorter.DefaultDateTags.File, ","), "comma separated filesystem date tags reported by exiftool, such as FileModifyDate or FileCreateDate, tried in order before the walked modification time when falling back to it")
+	autoTags := flag.Bool("auto-date-tags", false, "read dates from the most authoritative date tag present in each file, from a broad ranked list, instead of -image-date-tags and -video-date-tags")
+	videoTags := flag.String("video-date-tags", strings.Join(sorter.DefaultDateTags.Video, ","), "comma separated EXIF tags to read video dates from, in order of preference")
+	conflictSuffix := flag.String("conflict-suffix", sorter.DefaultConflictSuffix, "format of the number inserted before the extension of files renamed by -on-conflict rename, such as \" (%d)\" or \".%03d\"")
+	onConflict := flag.String("on-conflict", sorter.ConflictRename, "what to do when a different file already exists at the destination: rename, skip or overwrite")
+	noClobber := flag.Bool("no-clobber", false, "skip any file whose destination already exists, without comparing content")
+	planFile := flag.String("plan", "", "write the resolved plan to this file instead of sorting")
+	applyFile := flag.String("apply", "", "execute a plan previously written with -plan or -report")
+	allowChanged := flag.Bool("allow-changed", false, "with -apply, sort files that changed since planning instead of refusing them")
+	dryRun := flag.Bool("dry-run", false, "show what would be done without touching any file")
+	reportDuplicates := flag.Bool("report-duplicates", false, "print the groups of source files with the same content and the space they waste, then exit")
+	diffFlag := flag.Bool("diff", false, "like -dry-run, but only print the files whose destination differs from where they are, for reviewing a new template over an already sorted -src")
+	serveFlag := flag.Bool("serve", false, "serve an HTTP API instead of sorting: POST {\"path\"} to /resolve for a file's destination and metadata, and {\"src\", \"dest\", \"copy\", \"dry_run\"} to /sort to run a sort with the other flags as defaults; requests must be application/json, without an Origin, to the -listen address")
+	listenAddr := flag.String("listen", "127.0.0.1:8765", "address -serve listens on; keep it on localhost unless the network is trusted, as anyone reaching it can move files")
+	probeFlag := flag.Bool("probe", false, "print the dates found for every file and the one it would be sorted by, then exit")
+	stdoutPlan := flag.Bool("stdout-plan", false, "print the resolved plan to stdout as tab separated src, dest, action and date source lines; combine with -dry-run to only review it")
+	explainSkip := flag.Bool("explain-skip", false, "list every skipped file with the reason why at the end of the run")
+	extFolders := flag.String("ext-folders", "", "comma separated extension=subfolder pairs routing files into subfolders of their date folder, as in cr2=RAW,nef=RAW,jpg=JPG")
+	checkFlag := flag.Bool("check", false, "only print how many files would fail to sort, and exit with status 1 if any would")
+	reportFile := flag.String("report", "", "write the resolved plan of the run to this file")
+	minYear := flag.Int("min-year", 1900, "earliest year considered a plausible capture date")
+	quarantineDir := flag.String("quarantine-dir", "", "directory to move files with an implausible date into, instead of sorting them")
+	mtimeFallback := flag.Bool("mtime-fallback", false, "date photos and videos without an EXIF or file name date by their modification time")
+	includeNonMedia := flag.Bool("include-nonmedia", false, "also sort files that are not photos or videos, by their modification time")
+	includeDocuments := flag.Bool("include-documents", false, "also sort PDF documents, by their metadata dates")
+	includeHidden := flag.Bool("include-hidden", false, "also process hidden files and directories and system junk files")
+	minSize := flag.String("min-size", "", "skip files smaller than this size, e.g. 500KB")
+	maxSize := flag.String("max-size", "", "skip files larger than this size, e.g. 2GB")
+	print0 := flag.Bool("print0", false, "end every field of -stdout-plan, -explain-skip and -undated-list output with a NUL byte instead of tabs and newlines, for xargs -0")
+	undatedFile := flag.String("undated-list", "", "write the shell quoted paths of files without a date to this file, one per line")
+	folderMetadata := flag.String("folder-metadata", "", "write a metadata file into every folder files are sorted into: json or picasa")
+	resume := flag.Bool("resume", false, "checkpoint the source directories completed into the destination, and skip those completed by an interrupted run; the checkpoint is removed once a run finishes without failures")
+	fixExtension := flag.Bool("fix-extension", false, "give files whose content is of another type than their extension says the extension exiftool detects")
+	orderedLog := flag.Bool("parallel-safe-log", false, "write the log lines of files sorted by parallel workers in plan order instead of as they finish")
+	keepTags := flag.String("keep-tags", "", "comma separated tags written back from the original whenever EXIF data is rewritten, instead of every tag read from it; the dates are always written")
+	keepAllTags := flag.Bool("keep-all-tags", false, "write back every tag read from the original whenever EXIF data is rewritten, as is done unless -keep-tags narrows them")
+	updateOnly := flag.Bool("update-only", false, "like rsync -u, replace a file already at the destination only when the source is newer, and skip the source otherwise")
+	writeProvenance := flag.Bool("write-provenance", false, "write a JSON .origin sidecar next to every sorted file with its original path and the time of the run")
+	screenshotsDir := flag.String("screenshots-dir", "", "folder template below the destination for screenshots, detected by their name or the comment iPhones mark them with, e.g. Screenshots/2006")
+	screenshotNames := flag.String("screenshot-names", sorter.DefaultScreenshotPattern.String(), "regular expression matching the file names of screenshots")
+	folderRules := flag.String("rules", "", "semicolon separated predicate -> template rules picking the folder of matching files over -datefmt, as in \"hasGPS -> {country}/2006; default -> 2006/01/02\"; predicates are hasGPS, image, video, raw, document, screenshot, has:Tag and default, negated with !")
+	dedupeDB := flag.String("dedupe-db", "", "database of the content already in the destination, kept across runs to skip duplicates")
+	contactSheets := flag.Bool("contact-sheet", false, "write an index.html browsing the photos and videos into every year folder files are sorted into, showing the thumbnails embedded in them")
+	trashDir := flag.String("trash-dir", "", "move files that would be overwritten into this directory instead")
+	exiftoolPath := flag.String("exiftool-path", "", "exiftool binary to run (default is exiftool from the PATH)")
+	minExiftoolVersion := flag.String("min-exiftool-version", "", "fail when exiftool is older than this version, such as 12.40")
+	exiftoolBuffer := flag.Int("exiftool-buffer", 0, "largest exiftool output in bytes for a single file (default is the library's own limit)")
+	charsets := flag.String("charset", "", "comma separated exiftool -charset options decoding legacy metadata, e.g. exif=cp1252,filename=utf8")
+	extractWorkers := flag.Int("threads-exiftool", runtime.NumCPU(), "number of exiftool processes extracting dates in parallel")
+	copyWorkers := flag.Int("workers", 1, "number of files copied or moved in parallel")
+	copyBuffer := flag.Int("copy-buffer", sorter.DefaultCopyBuffer, "size in bytes of the buffer files are copied through")
+	resumable := flag.Bool("resumable", false, "copy through .part files that a later run resumes after a failure")
+	pluginCmd := flag.String("plugin", "", "command of a plugin routing every file: it is sent one {\"path\", \"date\", \"fields\", \"dest\", \"action\"} JSON line per file on stdin and answers each with one {\"action\", \"dest\", \"skip\", \"error\"} JSON line on stdout, empty fields keeping the proposed values")
+	setMtime := flag.Bool("set-mtime", false, "set the modification time of every sorted file to the date it was sorted by, for tools that ignore EXIF data")
+	mergeExisting := flag.Bool("dest-date-bucket-existing", false, "skip files whose content is already in their destination folder under another name, to merge into folders of an existing library")
+	summaryJSON := flag.Bool("summary-json", false, "print the summary of the run as a single JSON object on stdout once done, with counts, bytes sorted, duration and exit status")
+	limit := flag.Int("limit", 0, "stop after finding this many files to sort, to quickly try options on a large source")
+	dateSidecars := flag.String("date-sidecars", strings.Join(sorter.DefaultDateSidecars, ","), "comma separated extensions of the sidecars, such as camcorder .THM thumbnails, whose EXIF date is used for videos without a date of their own")
+	dateFromParent := flag.Bool("date-from-parent", false, "date files with neither an EXIF nor a file name date by the name of their directory, such as DCIM/2023-05-01/IMG_0001.jpg")
+	sessionFolder := flag.String("session-date-from-folder", "", "regular expression reading a date from the name of the folder of files without one, as for timelapse and burst sessions, whose files then all sort by it; groups are named as for -date-from-path, e.g. (?P<year>\\d{4})-(?P<month>\\d{2})-(?P<day>\\d{2})")
+	dateFromPath := flag.String("date-from-path", "", "regular expression reading dates from directory paths before EXIF data and file names, with groups named year, month and day, e.g. (?P<year>\\d{4})-[^/]*/(?P<month>[A-Za-z]+)")
+	dateOrder := flag.String("date-order", sorter.DateOrderYMD, "order of day and month in file name dates ending with the year, such as 01-05-2023: dmy, mdy, or ymd to ignore them")
+	datePrefer := flag.String("date-prefer", sorter.PreferExif, "date to trust when EXIF data and file name both have one: exif, filename or oldest")
+	photoDate := flag.String("photo-date", "", "like -date-prefer, for photos only")
+	videoDate := flag.String("video-date", "", "like -date-prefer, for videos only")
+	dateDisagreement := flag.Duration("date-disagreement", 24*time.Hour, "warn when EXIF and file name dates differ by more than this (0 disables)")
+	gpsDisagreement := flag.Duration("gps-disagreement", 24*time.Hour, "warn when EXIF and GPS dates differ by more than this, counting dates without an offset as UTC (0 disables)")
+	preferGPSTime := flag.Bool("prefer-gps-time", false, "sort files with a GPS time by it instead of their EXIF date")
+	dateGranularity := flag.String("date-granularity", "", "set to day to truncate every date to midnight once extracted, in -display-timezone when set, so only the day decides where a file goes")
+	displayTimezone := flag.String("display-timezone", "", "time zone to convert every date to before sorting, e.g. Europe/Paris (default keeps each file's own)")
+	statsFlag := flag.Bool("stats", false, "print extraction and I/O timings at the end of the run")
+	waitFlag := flag.Bool("wait", false, "wait for another sorter working on the destination to finish instead of exiting")
+	notifyFlag := flag.Bool("notify", false, "show a desktop notification when the run finishes")
+	logFormat := flag.String("log-format", "text", "log output format: text, json or logfmt")
+	logFile := flag.String("log-file", "", "append the log to this file instead of stderr, rotated past -log-max-size")
+	logMaxSize := flag.String("log-max-size", "10MB", "size past which -log-file is rotated; 0 never rotates it")
+	logKeep := flag.Int("log-keep", 5, "number of gzipped archives kept of a rotated -log-file, and of earlier -report files, as file.1.gz being the newest")
 	flag.Parse()
-
-	// Check if required flags are provided
-	if *srcDirPtr == "" || *destDirPtr == "" {
-		log.Error("Please provide source and destination directories")
-		os.Exit(1)
+	if *preset != "" {
+		if err := applyPreset(flag.CommandLine, *preset); err != nil {
+			log.Error("Invalid preset", "err", err)
+			exit(1)
+		}
 	}
 
-	// Compile regex to extract date from filename
-	dateRegex := regexp.MustCompile(`(\d{8}(?:-\d{6})?)`)
+	// Send the log to a rotated file if requested
+	var logOutput io.Writer = os.Stderr
+	if *logFile != "" {
+		maxSize, err := parseSize(*logMaxSize)
+		if err != nil {
+			log.Error("Invalid log size", "err", err)
+			exit(1)
+		}
+		w, err := openRotating(*logFile, maxSize, *logKeep)
+		if err != nil {
+			log.Error("Error while opening log file", "log", *logFile, "err", err)
+			exit(1)
+		}
+		runLog = w
+		defer closeLog()
+		log.SetOutput(w)
+		logOutput = w
+	}
 
-	log.Infof("Carrying out the copy: %v", *copyFlag)
+	// Configure the log formatter
+	formatter, err := parseLogFormat(*logFormat)
+	if err != nil {
+		log.Error("Invalid log format", "err", err)
+		exit(1)
+	}
+	log.SetFormatter(formatter)
 
-	// Traverse source directory and process each file
-	filepath.Walk(*srcDirPtr, func(path string, info os.FileInfo, err error) error {
+	if *groupBySerial {
+		*folderFormat = filepath.Join(*folderFormat, "{serial}")
+		*monthFormat = filepath.Join(*monthFormat, "{serial}")
+	}
+	var displayZone *time.Location
+	if *displayTimezone != "" {
+		zone, err := time.LoadLocation(*displayTimezone)
 		if err != nil {
-			log.Errorf("Error while accessing %q: %v", path, err)
-			return nil
+			log.Error("Invalid display time zone", "zone", *displayTimezone, "err", err)
+			exit(1)
+		}
+		displayZone = zone
+	}
+	if *dateGranularity != "" && *dateGranularity != sorter.GranularityDay {
+		log.Error("Unknown date granularity", "granularity", *dateGranularity)
+		exit(1)
+	}
+
+	names, err := splitMap(*serialNames)
+	if err != nil {
+		log.Error("Invalid serial names", "err", err)
+		exit(1)
+	}
+
+	extensionFolders, err := splitMap(*extFolders)
+	if err != nil {
+		log.Error("Invalid extension folders", "err", err)
+		exit(1)
+	}
+	for ext, folder := range extensionFolders {
+		delete(extensionFolders, ext)
+		extensionFolders[strings.ToLower(strings.TrimPrefix(ext, "."))] = folder
+	}
+
+	minBytes, err := parseSize(*minSize)
+	if err != nil {
+		log.Error("Invalid minimum size", "err", err)
+		exit(1)
+	}
+	maxBytes, err := parseSize(*maxSize)
+	if err != nil {
+		log.Error("Invalid maximum size", "err", err)
+		exit(1)
+	}
+
+	filenamePatterns, err := sorter.FilenamePatternsFor(*dateOrder)
+	if err != nil {
+		log.Error("Invalid date order", "err", err)
+		exit(1)
+	}
+
+	var pathPattern *regexp.Regexp
+	if *dateFromPath != "" {
+		if pathPattern, err = regexp.Compile(*dateFromPath); err != nil {
+			log.Error("Invalid date path pattern", "pattern", *dateFromPath, "err", err)
+			exit(1)
+		}
+	}
+
+	var sessionPattern *regexp.Regexp
+	if *sessionFolder != "" {
+		if sessionPattern, err = regexp.Compile(*sessionFolder); err != nil {
+			log.Error("Invalid session folder pattern", "pattern", *sessionFolder, "err", err)
+			exit(1)
+		}
+	}
+
+	// Spread files across several destination volumes, locking the first
+	var volumes []string
+	if list := splitList(*destDirPtr); len(list) > 1 {
+		volumes = list
+		*destDirPtr = list[0]
+	}
+
+	var dedupe *sorter.DedupeDB
+	if *dedupeDB != "" {
+		roots := volumes
+		if roots == nil {
+			roots = []string{*destDirPtr}
+		}
+		if dedupe, err = sorter.OpenDedupeDB(*dedupeDB, roots...); err != nil {
+			log.Error("Error while opening dedupe database", "db", *dedupeDB, "err", err)
+			exit(1)
+		}
+	}
+	minFreeBytes, err := parseSize(*minFree)
+	if err != nil {
+		log.Error("Invalid minimum free space", "err", err)
+		exit(1)
+	}
+	minWidth, minHeight, err := parseResolution(*minResolution)
+	if err != nil {
+		log.Error("Invalid minimum resolution", "err", err)
+		exit(1)
+	}
+	if *transcodeHEIC && !*copyFlag {
+		log.Error("HEIC images are only transcoded when copying, please add -copy")
+		exit(1)
+	}
+	minFreeSpaceBytes, err := parseSize(*minFreeSpace)
+	if err != nil {
+		log.Error("Invalid minimum free space", "err", err)
+		exit(1)
+	}
+
+	storage, destRoot, err := sorter.ParseDest(*destDirPtr)
+	if err != nil {
+		log.Error("Invalid destination", "dest", *destDirPtr, "err", err)
+		exit(1)
+	}
+
+	rules, err := sorter.ParseFolderRules(*folderRules)
+	if err != nil {
+		log.Error("Invalid folder rules", "err", err)
+		exit(1)
+	}
+	screenshotPattern, err := regexp.Compile(*screenshotNames)
+	if err != nil {
+		log.Error("Invalid screenshot pattern", "pattern", *screenshotNames, "err", err)
+		exit(1)
+	}
+	if *screenshotsDir != "" {
+		if err := sorter.ValidateTemplate(*screenshotsDir); err != nil {
+			log.Error("Invalid screenshots folder", "err", err)
+			exit(1)
+		}
+		// Route screenshots before any other rule
+		screenshots := sorter.FolderRule{Predicate: "screenshot", Format: *screenshotsDir}
+		rules = append([]sorter.FolderRule{screenshots}, rules...)
+	}
+
+	var checkpoint *sorter.Checkpoint
+	if *resume {
+		if storage != nil {
+			log.Error("-resume needs a local destination to keep its checkpoint in")
+			exit(1)
+		}
+		if *destDirPtr == "" {
+			log.Error("-resume needs -dest to keep its checkpoint in")
+			exit(1)
+		}
+		path := filepath.Join(*destDirPtr, sorter.CheckpointName)
+		if checkpoint, err = sorter.OpenCheckpoint(path); err != nil {
+			log.Error("Error while opening checkpoint", "checkpoint", path, "err", err)
+			exit(1)
 		}
+		if done := checkpoint.Completed(); done > 0 {
+			log.Info("Resuming from checkpoint", "checkpoint", path, "completed_dirs", done)
+		}
+	}
 
-		// Only process files with .jpg or .mp4 extensions
-		if info.IsDir() || !strings.HasSuffix(strings.ToLower(info.Name()), ".jpg") && !strings.HasSuffix(strings.ToLower(info.Name()), ".mp4") {
-			return nil
+	var metadata sorter.FolderMetadata
+	if *folderMetadata != "" {
+		var ok bool
+		if metadata, ok = sorter.FolderMetadataFormats[*folderMetadata]; !ok {
+			log.Error("Unknown folder metadata format", "format", *folderMetadata)
+			exit(1)
 		}
+	}
 
-		// Extract date from EXIF data or filename
-		date, ifExif, err := extractDate(path, dateRegex)
+	opts := sorter.Options{
+		Src:                *srcDirPtr,
+		Dest:               destRoot,
+		Storage:            storage,
+		Copy:               *copyFlag,
+		FolderFormat:       *folderFormat,
+		NameFormat:         *nameFormat,
+		AppendOriginalName: *appendOriginal,
+		IphoneEdits:        *iphoneEdits,
+		KeywordFallback:    *keywordFallback,
+		MonthFormat:        *monthFormat,
+		FlatMonth:          *flatMonth,
+		UpdateExif:         *updateExifFlag,
+		ExifBackups:        *exifBackup,
+		ExifBackupDir:      *exifBackupDir,
+		OnConflict:         *onConflict,
+		ConflictSuffix:     *conflictSuffix,
+		NoClobber:          *noClobber,
+		MinYear:            *minYear,
+		QuarantineDir:      *quarantineDir,
+		FilenamePatterns:   filenamePatterns,
+		Tags: sorter.DateTags{
+			Image:    splitList(*imageTags),
+			Video:    splitList(*videoTags),
+			Document: splitList(*documentTags),
+			File:     splitList(*fileTags),
+		},
+		Log:                   *logFlag,
+		MtimeFallback:         *mtimeFallback,
+		IncludeNonMedia:       *includeNonMedia,
+		IncludeDocuments:      *includeDocuments,
+		IncludeHidden:         *includeHidden,
+		MinSize:               minBytes,
+		MaxSize:               maxBytes,
+		MinWidth:              minWidth,
+		MinHeight:             minHeight,
+		SkipUnknownResolution: *skipUnknownResolution,
+		TranscodeHEIC:         *transcodeHEIC,
+		KeepHEIC:              *keepHEIC,
+		TrashDir:              *trashDir,
+		FolderMetadata:        metadata,
+		DedupeDB:              dedupe,
+		Checkpoint:            checkpoint,
+		Provenance:            *writeProvenance,
+		FolderRules:           rules,
+		Volumes:               volumes,
+		MinFree:               minFreeBytes,
+		MinFreeSpace:          minFreeSpaceBytes,
+		AutoDateTags:          *autoTags,
+		UpdateOnly:            *updateOnly,
+		FixExtension:          *fixExtension,
+		LogOutput:             logOutput,
+		OrderedLog:            *orderedLog,
+		KeepTags:              splitList(*keepTags),
+		KeepAllTags:           *keepAllTags,
+		ContactSheets:         *contactSheets,
+		AllowChanged:          *allowChanged,
+		ExiftoolPath:          *exiftoolPath,
+		MinExiftoolVersion:    *minExiftoolVersion,
+		ExiftoolBuffer:        *exiftoolBuffer,
+		Charsets:              splitList(*charsets),
+		ExtractWorkers:        *extractWorkers,
+		CopyWorkers:           *copyWorkers,
+		CopyBuffer:            *copyBuffer,
+		Resumable:             *resumable,
+		PathPattern:           pathPattern,
+		SessionPattern:        sessionPattern,
+		ParentDateFallback:    *dateFromParent,
+		DateSidecars:          splitList(*dateSidecars),
+		ScreenshotPattern:     screenshotPattern,
+		Limit:                 *limit,
+		MergeExisting:         *mergeExisting,
+		SetMtime:              *setMtime,
+		DatePrefer:            *datePrefer,
+		ImageDatePrefer:       *photoDate,
+		VideoDatePrefer:       *videoDate,
+		DateDisagreement:      *dateDisagreement,
+		GPSDisagreement:       *gpsDisagreement,
+		PreferGPSTime:         *preferGPSTime,
+		SerialNames:           names,
+		ExtensionFolders:      extensionFolders,
+		DisplayZone:           displayZone,
+		DateGranularity:       *dateGranularity,
+		Interrupt:             interrupt,
+	}
+	if err := opts.Validate(); err != nil {
+		log.Error("Invalid options", "err", err)
+		exit(1)
+	}
+	if *pluginCmd != "" {
+		plugin, err := sorter.StartPlugin(*pluginCmd)
 		if err != nil {
-			log.Errorf("Error while extracting date from %q: %+v", path, err)
-			return nil
+			log.Error("Error while starting plugin", "err", err)
+			exit(1)
 		}
+		runPlugin = plugin
+		defer closePlugin()
+		opts.OnFile = plugin.OnFile
+	}
 
-		// Generate new file name with date
-		newName := filepath.Join(*destDirPtr, date.Format(*folderFormat), filepath.Base(path))
+	if *serveFlag {
+		if err := serve(*listenAddr, opts); err != nil {
+			log.Error("Error while serving", "err", err)
+			exit(1)
+		}
+		return
+	}
+
+	stats := sorter.NewStats()
+	stats.ExplainSkips = *explainSkip
 
-		// Move or copy file
-		if *copyFlag {
-			err = copyFile(path, newName)
-		} else {
-			err = renameFile(path, newName)
+	// Execute a previously written plan without extracting any dates
+	if *applyFile != "" {
+		plan, err := sorter.ReadPlan(*applyFile)
+		if err != nil {
+			log.Error("Error while reading plan", "plan", *applyFile, "err", err)
+			exit(1)
+		}
+		if *destDirPtr != "" && storage == nil {
+			lockDest(*destDirPtr, *waitFlag)
+			defer runLock.Release()
+		}
+		execErr := sorter.Execute(plan, opts, stats)
+		removeCheckpoint(checkpoint, execErr, stats)
+		stats.Summarize()
+		if *statsFlag {
+			stats.Report()
 		}
+		if *explainSkip {
+			printSkips(os.Stdout, stats.Skips(), *print0)
+		}
+		if *notifyFlag {
+			notify(stats)
+		}
+		if *summaryJSON {
+			printSummaryJSON(os.Stdout, stats, execErr)
+		}
+		if execErr != nil {
+			exit(1)
+		}
+		return
+	}
+
+	// Audit the date sources without planning anything
+	if *probeFlag {
+		if *srcDirPtr == "" {
+			log.Error("Please provide a source directory")
+			exit(1)
+		}
+		results, err := sorter.Probe(opts, stats)
 		if err != nil {
-			log.Errorf("Error while processing %q: %+v", path, err)
-			return nil
+			log.Error("Error while probing", "err", err)
+			exit(1)
 		}
+		printProbe(os.Stdout, results)
+		return
+	}
 
-		// Update EXIF data if requested
-		if *updateExifFlag && !ifExif {
-			log.Warnf("Need to update EXIF data of %q", newName)
-			err = updateExif(newName, date)
-			if err != nil {
-				log.Errorf("Error while updating EXIF data of %q: %v", newName, err)
-				return nil
-			}
+	// Analyze duplication without planning anything
+	if *reportDuplicates {
+		if *srcDirPtr == "" {
+			log.Error("Please provide a source directory")
+			exit(1)
 		}
+		groups, err := sorter.FindDuplicates(opts)
+		if err != nil {
+			log.Error("Error while looking for duplicates", "err", err)
+			exit(1)
+		}
+		printDuplicates(os.Stdout, groups)
+		return
+	}
 
-		// Log file move or copy
-		if *logFlag {
-			log.Infof("%s %q -> %q", getActionString(*copyFlag), path, newName)
+	// Report whether everything is sortable without acting on anything
+	if *checkFlag {
+		if *srcDirPtr == "" {
+			log.Error("Please provide a source directory")
+			exit(1)
 		}
+		if _, err := sorter.Plan(opts, stats); err != nil {
+			log.Error("Error while planning", "err", err)
+			exit(1)
+		}
+		fmt.Println(stats.Failed)
+		if stats.Failed > 0 {
+			exit(1)
+		}
+		return
+	}
 
-		return nil
-	})
-}
+	// Check if required flags are provided
+	if *srcDirPtr == "" || *destDirPtr == "" {
+		log.Error("Please provide source and destination directories")
+		exit(1)
+	}
 
-func ensureDir(path string) error {
-	exPath := filepath.Dir(path)
-	err := os.MkdirAll(exPath, os.ModePerm)
-	if err != nil {
-		log.Error(err)
+	// Keep other sorters off the destination while this one acts on it
+	if !*dryRun && !*diffFlag && *planFile == "" && storage == nil {
+		lockDest(*destDirPtr, *waitFlag)
+		defer runLock.Release()
 	}
-	return err
-}
 
-func extractDate(path string, dateRegex *regexp.Regexp) (time.Time, bool, error) {
-	// Extract date from EXIF data
-	et, err := exiftool.NewExiftool()
+	log.Infof("Carrying out the copy: %v", *copyFlag)
+
+	plan, err := sorter.Plan(opts, stats)
 	if err != nil {
-		return time.Time{}, false, errors.Errorf("Error when intializing: %v", err)
+		log.Error("Error while planning", "err", err)
+		exit(1)
+	}
+	if stats.Limited {
+		log.Warn("Stopped early at the file limit, the rest of the source was not walked", "limit", *limit)
+	}
+	if inPlace, total, organized := sorter.Organized(plan, opts); organized {
+		log.Warn("Source appears already organized, sorting it again would change little", "in_place", inPlace, "of", total)
+	} else if inPlace > 0 {
+		log.Info("Files already laid out as sorted", "in_place", inPlace, "of", total)
 	}
-	defer et.Close()
 
-	fileInfos := et.ExtractMetadata(path)
+	if *undatedFile != "" {
+		if err := writeUndated(*undatedFile, stats.Undated(), *print0); err != nil {
+			log.Error("Error while writing undated files", "list", *undatedFile, "err", err)
+			exit(1)
+		}
+	}
 
-	for _, fileInfo := range fileInfos {
-		if fileInfo.Err != nil {
-			log.Errorf("Error concerning %v: %v", fileInfo.File, fileInfo.Err)
-			continue
+	if *reportFile != "" {
+		if err := rotateFile(*reportFile, *logKeep); err != nil {
+			log.Error("Error while rotating report", "report", *reportFile, "err", err)
 		}
+		if err := sorter.WritePlan(*reportFile, plan); err != nil {
+			log.Error("Error while writing report", "report", *reportFile, "err", err)
+			exit(1)
+		}
+	}
+
+	if *stdoutPlan {
+		printPlan(os.Stdout, plan, *print0)
+	}
+
+	if *diffFlag {
+		printDiff(os.Stdout, plan, *destDirPtr)
+		return
+	}
 
-		for k, v := range fileInfo.Fields {
-			log.Debugf("[%v] %v", k, v)
+	if *dryRun {
+		for _, entry := range plan {
+			log.Info("Would sort file", "src", entry.Src, "dest", entry.Dest, "action", entry.Action, "reason", entry.Reason)
+			if entry.Action == sorter.ActionSkip {
+				stats.AddSkip(entry.Src, entry.Reason)
+			}
+		}
+		if *explainSkip {
+			printSkips(os.Stdout, stats.Skips(), *print0)
+		}
+		return
+	}
+
+	// Persist the plan for review instead of acting on it
+	if *planFile != "" {
+		if err := sorter.WritePlan(*planFile, plan); err != nil {
+			log.Error("Error while writing plan", "plan", *planFile, "err", err)
+			exit(1)
 		}
+		log.Info("Wrote plan", "plan", *planFile, "entries", len(plan))
+		return
 	}
 
-	dateStr := ""
-	if strings.ToLower(filepath.Ext(path)) == ".mp4" {
-		dateStr, _ = fileInfos[0].GetString("MediaCreateDate")
-	} else if strings.ToLower(filepath.Ext(path)) == ".jpg" || strings.ToLower(filepath.Ext(path)) == ".jpeg" {
-		dateStr, _ = fileInfos[0].GetString("DateTimeOriginal")
+	execErr := sorter.Execute(plan, opts, stats)
+	removeCheckpoint(checkpoint, execErr, stats)
+
+	stats.Summarize()
+	if *statsFlag {
+		stats.Report()
 	}
-	date, _ := time.Parse("2006:01:02 15:04:05", dateStr)
-	if !date.IsZero() {
-		return date, true, nil
+	if *explainSkip {
+		printSkips(os.Stdout, stats.Skips(), *print0)
 	}
+	if *notifyFlag {
+		notify(stats)
+	}
+	if *summaryJSON {
+		printSummaryJSON(os.Stdout, stats, execErr)
+	}
+	if execErr != nil {
+		exit(1)
+	}
+}
+
+// runSummary is the summary of a run printed by -summary-json.
+type runSummary struct {
+	sorter.Summary
+	ExitStatus int    `json:"exit_status"`
+	Error      string `json:"error,omitempty"`
+}
 
-	// Extract date from filename
-	matches := dateRegex.FindStringSubmatch(path)
-	if len(matches) == 0 {
-		return time.Time{}, false, errors.Errorf("unable to extract date from filename")
+// printSummaryJSON writes the summary of a run to w as a single JSON object,
+// along with the status the run exits with.
+func printSummaryJSON(w io.Writer, stats *sorter.Stats, execErr error) {
+	summary := runSummary{Summary: stats.Summary()}
+	if execErr != nil {
+		summary.ExitStatus, summary.Error = 1, execErr.Error()
 	}
-	dateStr = matches[0]
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Error("Error while writing summary", "err", err)
+	}
+}
+
+// runLock is the destination lock held by this run, if any.
+var runLock *sorter.Lock
 
-	// Parse date string and return as time.Time
-	if strings.Contains(dateStr, "-") {
-		date, err = time.Parse("20060102-150405", dateStr)
-	} else if strings.Contains(dateStr, "_") {
-		date, err = time.Parse("20060102_150405", dateStr)
-	} else {
-		date, err = time.Parse("20060102", dateStr)
+// runLog is the file the log of this run is written to, if any.
+var runLog *rotatingWriter
+
+// closeLog closes the log file of the run, if any, sending the rest of the
+// log to stderr.
+func closeLog() {
+	if err := runLog.Close(); err != nil {
+		log.SetOutput(os.Stderr)
+		log.Error("Error while closing log file", "err", err)
 	}
-	if err != nil {
-		return time.Time{}, false, errors.WithStack(err)
+}
+
+// runPlugin is the plugin routing the files of this run, if any.
+var runPlugin *sorter.Plugin
+
+// closePlugin ends the plugin of the run, if any, logging how it exited.
+func closePlugin() {
+	if err := runPlugin.Close(); err != nil {
+		log.Error("Plugin exited with an error", "err", err)
 	}
-	return date, false, nil
 }
 
-func copyFile(src, dest string) error {
-	// Open source file for reading
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
+// interrupt is closed when the run is interrupted by a signal, which stops
+// the sort once the files being copied or moved are done.
+var interrupt = make(chan struct{})
+
+// lockDest takes the lock on the destination directory, exiting when it is
+// held by another sorter. A first interrupting signal stops the run, which
+// then releases the lock as it exits, and a second one exits right away.
+func lockDest(dest string, wait bool) {
+	lock, err := sorter.AcquireLock(dest, wait)
+	if err == sorter.ErrLocked {
+		log.Error("Another sorter is working on the destination, remove the lock file if none is running", "lock", filepath.Join(dest, sorter.LockName))
+		exit(1)
+	} else if err != nil {
+		log.Error("Unable to lock destination", "dest", dest, "err", err)
+		exit(1)
 	}
-	defer srcFile.Close()
+	runLock = lock
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Warn("Interrupted, stopping once the files being sorted are done; interrupt again to exit now", "signal", sig)
+		close(interrupt)
+		sig = <-signals
+		log.Warn("Interrupted again, releasing destination lock", "signal", sig)
+		exit(1)
+	}()
+}
 
-	err = ensureDir(dest)
-	if err != nil {
-		return err
+// removeCheckpoint removes the checkpoint of a run that finished without
+// failures, since there is nothing left to resume.
+func removeCheckpoint(checkpoint *sorter.Checkpoint, execErr error, stats *sorter.Stats) {
+	if checkpoint == nil || execErr != nil || stats.Failed > 0 {
+		return
+	}
+	if err := checkpoint.Remove(); err != nil {
+		log.Error("Error while removing checkpoint", "err", err)
 	}
+}
 
-	// Create destination file for writing
-	destFile, err := os.Create(dest)
-	if err != nil {
-		return err
+// exit ends the plugin, releases the destination lock and closes the log
+// file, if any, and exits with code. Deferred calls do not run, so whatever
+// they would release must be released here too.
+func exit(code int) {
+	closePlugin()
+	if err := runLock.Release(); err != nil {
+		log.Error("Error while releasing destination lock", "err", err)
+	}
+	closeLog()
+	os.Exit(code)
+}
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
 	}
-	defer destFile.Close()
+	return list
+}
 
-	// Copy file contents
-	_, err = io.Copy(destFile, srcFile)
-	return err
+// splitMap parses a comma separated list of key=value pairs.
+func splitMap(value string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, item := range splitList(value) {
+		key, val, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing '=' in %q", item)
+		}
+		m[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return m, nil
 }
 
-func renameFile(src, dest string) error {
-	err := ensureDir(dest)
-	if err != nil {
-		return err
+// printProbe writes probe results as a table, followed by the number of
+// files dated from each source.
+func printProbe(w io.Writer, results []sorter.ProbeResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tEXIF DATE\tFILENAME DATE\tCHOSEN\tSOURCE")
+	counts := make(map[sorter.DateSource]int)
+	for _, result := range results {
+		source := string(result.Source)
+		if result.Err != nil {
+			source = "none"
+		}
+		counts[sorter.DateSource(source)]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", result.Path, probeDate(result.ExifDate), probeDate(result.NameDate), probeDate(result.Date), source)
 	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d files:", len(results))
+	for _, source := range sorter.DateSources {
+		fmt.Fprintf(w, " %d %s,", counts[source], source)
+	}
+	fmt.Fprintf(w, " %d without date\n", counts["none"])
+}
 
-	// Create destination file for writing
-	err = os.Rename(src, dest)
-	if err != nil {
-		return err
+// tsvEscaper escapes the characters that would break a tab separated line.
+var tsvEscaper = strings.NewReplacer("\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// printNull writes every field followed by a NUL byte, as find -print0 does
+// with paths, so any file name survives the trip through xargs -0.
+func printNull(w io.Writer, fields ...string) {
+	for _, field := range fields {
+		fmt.Fprint(w, field, "\x00")
 	}
+}
 
-	return nil
+// printPlan writes a plan as tab separated lines, for piping into review
+// tools, or as NUL terminated fields when null is set. Logs go to stderr and
+// never mix with it.
+func printPlan(w io.Writer, plan []sorter.PlanEntry, null bool) {
+	for _, entry := range plan {
+		if null {
+			printNull(w, entry.Src, entry.Dest, entry.Action, string(entry.Source))
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", tsvEscaper.Replace(entry.Src), tsvEscaper.Replace(entry.Dest), entry.Action, entry.Source)
+	}
 }
 
-func updateExif(path string, date time.Time) error {
-	e, err := exiftool.NewExiftool()
-	if err != nil {
-		log.Errorf("Error when intializing: %v", err)
-		return err
+// printSkips writes the skipped files as a table of reasons and paths, or as
+// NUL terminated fields when null is set.
+func printSkips(w io.Writer, skips []sorter.SkippedFile, null bool) {
+	if null {
+		for _, skip := range skips {
+			printNull(w, skip.Reason, skip.Path)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REASON\tPATH")
+	for _, skip := range skips {
+		fmt.Fprintf(tw, "%s\t%s\n", skip.Reason, skip.Path)
 	}
-	defer e.Close()
+	tw.Flush()
+}
 
-	fileInfos := e.ExtractMetadata(path)
+// printDuplicates writes every group of duplicates, the wasted space first,
+// followed by the totals.
+func printDuplicates(w io.Writer, groups []sorter.DuplicateGroup) {
+	var files int
+	var wasted int64
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s  %d copies of %s, %s wasted\n", group.Hash[:12], len(group.Paths), formatSize(group.Size), formatSize(group.Wasted()))
+		for _, path := range group.Paths {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+		files += len(group.Paths) - 1
+		wasted += group.Wasted()
+	}
+	fmt.Fprintf(w, "\n%d groups, %d redundant files, %s wasted\n", len(groups), files, formatSize(wasted))
+}
 
-	dateStr := ""
-	if strings.ToLower(filepath.Ext(path)) == ".mp4" {
-		dateStr, _ = fileInfos[0].GetString("MediaCreateDate")
-		log.Infof("Date Original %v changed to %v", dateStr, date.Format("2006-01-02 15:04:05"))
-		fileInfos[0].SetString("MediaCreateDate", date.Format("2006-01-02 15:04:05"))
-		fileInfos[0].SetString("CreateDate", date.Format("2006-01-02 15:04:05"))
+// printDiff writes the files of a plan that would change place, as their
+// current and new paths relative to dest, followed by how many stay put.
+func printDiff(w io.Writer, plan []sorter.PlanEntry, dest string) {
+	rel := func(path string) string {
+		if r, err := filepath.Rel(dest, path); err == nil && !strings.HasPrefix(r, "..") {
+			return r
+		}
+		return path
+	}
+	moved, unchanged := 0, 0
+	for _, entry := range plan {
+		if entry.Action == sorter.ActionSkip && entry.Reason == sorter.ReasonInPlace {
+			unchanged++
+			continue
+		}
+		if entry.Action == sorter.ActionSkip {
+			fmt.Fprintf(w, "= %s (%s)\n", rel(entry.Src), entry.Reason)
+			continue
+		}
+		fmt.Fprintf(w, "- %s\n+ %s\n", rel(entry.Src), rel(entry.Dest))
+		moved++
+	}
+	fmt.Fprintf(w, "\n%d files would change place, %d stay put\n", moved, unchanged)
+}
+
+// probeDate formats a date of a probe result, or a dash when none was found.
+func probeDate(date time.Time) string {
+	if date.IsZero() {
+		return "-"
+	}
+	return date.Format("2006-01-02 15:04:05")
+}
 
-	} else if strings.ToLower(filepath.Ext(path)) == ".jpg" || strings.ToLower(filepath.Ext(path)) == ".jepg" {
-		dateStr, _ = fileInfos[0].GetString("DateTaken")
-		log.Infof("Date Original %v changed to %v", dateStr, date.Format("2006-01-02 15:04:05"))
+// sizeUnits are the suffixes accepted by parseSize, longest first so that KB
+// is not read as B.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
 
-		fileInfos[0].SetString("DateTimeOriginal", date.Format("2006-01-02 15:04:05"))
-		fileInfos[0].SetString("CreateDate", date.Format("2006-01-02 15:04:05"))
+// formatSize formats a number of bytes in the largest unit of sizeUnits it
+// reaches, as in 1.5GB.
+func formatSize(bytes int64) string {
+	for _, unit := range sizeUnits[:4] {
+		if bytes >= unit.bytes {
+			return strconv.FormatFloat(float64(bytes)/float64(unit.bytes), 'f', 1, 64) + unit.suffix
+		}
 	}
+	return strconv.FormatInt(bytes, 10) + "B"
+}
 
-	e.WriteMetadata(fileInfos)
+// parseResolution parses a resolution such as 1024x768 into its width and
+// height. An empty value is zero by zero.
+func parseResolution(value string) (int, int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, 0, nil
+	}
+	w, h, ok := strings.Cut(value, "x")
+	width, widthErr := strconv.Atoi(strings.TrimSpace(w))
+	height, heightErr := strconv.Atoi(strings.TrimSpace(h))
+	if !ok || widthErr != nil || heightErr != nil || width < 0 || height < 0 {
+		return 0, 0, fmt.Errorf("invalid resolution %q, expected WxH", value)
+	}
+	return width, height, nil
+}
 
-	return nil
+// parseLogFormat returns the log formatter named text, json or logfmt.
+func parseLogFormat(name string) (log.Formatter, error) {
+	switch name {
+	case "text":
+		return log.TextFormatter, nil
+	case "json":
+		return log.JSONFormatter, nil
+	case "logfmt":
+		return log.LogfmtFormatter, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q", name)
 }
 
-func getActionString(copyFlag bool) string {
-	if copyFlag {
-		return "Copied"
+// parseSize parses a human readable size such as 500KB or 2GB into bytes,
+// with units of 1024. An empty value is zero.
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.bytes
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// writeUndated writes the paths of files without a date, one per line and
+// quoted for the shell, so they can be inspected or fed back with xargs. When
+// null is set the paths are left unquoted and NUL terminated instead, for
+// xargs -0.
+func writeUndated(path string, undated []string, null bool) error {
+	var sb strings.Builder
+	for _, file := range undated {
+		if null {
+			printNull(&sb, file)
+			continue
+		}
+		sb.WriteString("'" + strings.ReplaceAll(file, "'", `'\''`) + "'\n")
 	}
-	return "Moved"
+	return os.WriteFile(path, []byte(sb.String()), 0o644)
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+
+	"photo-video-sort/m/v2/sorter"
+)
+
+func TestParseLogFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want log.Formatter
+		ok   bool
+	}{
+		{"text", log.TextFormatter, true},
+		{"json", log.JSONFormatter, true},
+		{"logfmt", log.LogfmtFormatter, true},
+		{"JSON", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseLogFormat(tt.name)
+		if (err == nil) != tt.ok || tt.ok && got != tt.want {
+			t.Errorf("parseLogFormat(%q) = %v, %v, want %v, ok %v", tt.name, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSplitMap(t *testing.T) {
+	got, err := splitMap("12345=CameraA, 67890 = CameraB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"12345": "CameraA", "67890": "CameraB"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("splitMap() = %v, want %v", got, want)
+	}
+	if _, err := splitMap("12345"); err == nil {
+		t.Error("splitMap() without '=' succeeded")
+	}
+}
+
+func TestPrintProbe(t *testing.T) {
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	results := []sorter.ProbeResult{
+		{Path: "src/IMG_0001.jpg", ExifDate: date, Date: date, Source: sorter.SourceExif},
+		{Path: "src/IMG_20230501_120000.jpg", NameDate: date, Date: date, Source: sorter.SourceFilename},
+		{Path: "src/IMG_0002.jpg", Err: sorter.ErrNoDate},
+	}
+	var buf bytes.Buffer
+	printProbe(&buf, results)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("printProbe() wrote %d lines, want 6:\n%s", len(lines), buf.String())
+	}
+	if fields := strings.Fields(lines[0]); fields[0] != "PATH" || fields[len(fields)-1] != "SOURCE" {
+		t.Errorf("header = %q", lines[0])
+	}
+	if fields := strings.Fields(lines[3]); fields[0] != "src/IMG_0002.jpg" || fields[len(fields)-1] != "none" {
+		t.Errorf("undated line = %q", lines[3])
+	}
+	if want := "3 files: 1 exif, 1 filename, 0 mtime,"; !strings.HasPrefix(lines[5], want) || !strings.HasSuffix(lines[5], " 1 without date") {
+		t.Errorf("summary = %q, want it to start with %q and count 1 without date", lines[5], want)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"", 0, true},
+		{"1024", 1024, true},
+		{"500KB", 500 << 10, true},
+		{"500kb", 500 << 10, true},
+		{"1.5 MB", 3 << 19, true},
+		{"2GB", 2 << 30, true},
+		{"1TB", 1 << 40, true},
+		{"10B", 10, true},
+		{"-1KB", 0, false},
+		{"big", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWriteUndated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "undated.txt")
+	undated := []string{"/photos/IMG_0001.jpg", "/photos/Bob's trip/IMG 0002.jpg"}
+	if err := writeUndated(path, undated, false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "'/photos/IMG_0001.jpg'\n'/photos/Bob'\\''s trip/IMG 0002.jpg'\n"; string(got) != want {
+		t.Errorf("undated list = %q, want %q", got, want)
+	}
+}
+
+func TestPrintPlan(t *testing.T) {
+	plan := []sorter.PlanEntry{
+		{Src: "src/IMG_0001.jpg", Dest: "dest/2023/05/IMG_0001.jpg", Action: sorter.ActionMove, Source: sorter.SourceExif},
+		{Src: "src/odd\tname.jpg", Dest: "dest/2023/05/odd\tname.jpg", Action: sorter.ActionSkip, Source: sorter.SourceFilename},
+	}
+	var buf bytes.Buffer
+	printPlan(&buf, plan, false)
+	want := "src/IMG_0001.jpg\tdest/2023/05/IMG_0001.jpg\tmove\texif\n" +
+		"src/odd\\tname.jpg\tdest/2023/05/odd\\tname.jpg\tskip\tfilename\n"
+	if buf.String() != want {
+		t.Errorf("printPlan() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestPrintSkips(t *testing.T) {
+	skips := []sorter.SkippedFile{{Path: "src/.DS_Store", Reason: "hidden"}, {Path: "src/notes.txt", Reason: "extension"}}
+	var buf bytes.Buffer
+	printSkips(&buf, skips, false)
+	want := "REASON     PATH\nhidden     src/.DS_Store\nextension  src/notes.txt\n"
+	if buf.String() != want {
+		t.Errorf("printSkips() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestPrintNull(t *testing.T) {
+	odd := "src/two\nlines.jpg"
+	var buf bytes.Buffer
+	printPlan(&buf, []sorter.PlanEntry{{Src: odd, Dest: "dest/2023/05/two\nlines.jpg", Action: sorter.ActionCopy, Source: sorter.SourceExif}}, true)
+	if want := odd + "\x00dest/2023/05/two\nlines.jpg\x00copy\x00exif\x00"; buf.String() != want {
+		t.Errorf("printPlan() = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	printSkips(&buf, []sorter.SkippedFile{{Path: odd, Reason: "hidden"}}, true)
+	if want := "hidden\x00" + odd + "\x00"; buf.String() != want {
+		t.Errorf("printSkips() = %q, want %q", buf.String(), want)
+	}
+
+	path := filepath.Join(t.TempDir(), "undated")
+	if err := writeUndated(path, []string{odd, "src/Bob's.jpg"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != odd+"\x00src/Bob's.jpg\x00" {
+		t.Errorf("undated list = %q, want unquoted NUL terminated paths", got)
+	}
+}
+
+func TestPrintDuplicates(t *testing.T) {
+	groups := []sorter.DuplicateGroup{
+		{Hash: "0123456789abcdef", Size: 2048, Paths: []string{"src/a/VID_0001.mp4", "src/b/VID_0001.mp4", "src/c/VID_0001.mp4"}},
+		{Hash: "fedcba9876543210", Size: 100, Paths: []string{"src/a/IMG_0001.jpg", "src/b/IMG_0001.jpg"}},
+	}
+	var buf bytes.Buffer
+	printDuplicates(&buf, groups)
+	want := "0123456789ab  3 copies of 2.0KB, 4.0KB wasted\n  src/a/VID_0001.mp4\n  src/b/VID_0001.mp4\n  src/c/VID_0001.mp4\n" +
+		"fedcba987654  2 copies of 100B, 100B wasted\n  src/a/IMG_0001.jpg\n  src/b/IMG_0001.jpg\n" +
+		"\n2 groups, 3 redundant files, 4.1KB wasted\n"
+	if buf.String() != want {
+		t.Errorf("printDuplicates() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestPrintDiff(t *testing.T) {
+	dest := "library"
+	plan := []sorter.PlanEntry{
+		{Src: filepath.Join(dest, "2023", "IMG_0001.jpg"), Dest: filepath.Join(dest, "2023", "05", "IMG_0001.jpg"), Action: sorter.ActionMove},
+		{Src: filepath.Join(dest, "2023", "05", "IMG_0002.jpg"), Dest: filepath.Join(dest, "2023", "05", "IMG_0002.jpg"), Action: sorter.ActionSkip, Reason: sorter.ReasonInPlace},
+		{Src: filepath.Join(dest, "notes.jpg"), Action: sorter.ActionSkip, Reason: sorter.ReasonDuplicate},
+		{Src: filepath.Join("elsewhere", "IMG_0003.jpg"), Dest: filepath.Join(dest, "2023", "05", "IMG_0003.jpg"), Action: sorter.ActionMove},
+	}
+	var buf bytes.Buffer
+	printDiff(&buf, plan, dest)
+	want := "- " + filepath.Join("2023", "IMG_0001.jpg") + "\n+ " + filepath.Join("2023", "05", "IMG_0001.jpg") + "\n" +
+		"= notes.jpg (duplicate)\n" +
+		"- " + filepath.Join("elsewhere", "IMG_0003.jpg") + "\n+ " + filepath.Join("2023", "05", "IMG_0003.jpg") + "\n" +
+		"\n2 files would change place, 1 stay put\n"
+	if buf.String() != want {
+		t.Errorf("printDiff() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		value  string
+		width  int
+		height int
+		err    bool
+	}{
+		{"", 0, 0, false},
+		{"1920x1080", 1920, 1080, false},
+		{" 1080 X 1920 ", 1080, 1920, false},
+		{"1920", 0, 0, true},
+		{"1920x", 0, 0, true},
+		{"-1x10", 0, 0, true},
+	}
+	for _, tt := range tests {
+		width, height, err := parseResolution(tt.value)
+		if (err != nil) != tt.err || width != tt.width || height != tt.height {
+			t.Errorf("parseResolution(%q) = %d, %d, %v, want %d, %d, error %v", tt.value, width, height, err, tt.width, tt.height, tt.err)
+		}
+	}
+}
+
+func TestPrintSummaryJSON(t *testing.T) {
+	stats := sorter.NewStats()
+	stats.Sorted, stats.Failed, stats.SortedBytes = 3, 1, 2048
+	stats.AddSkip("src/notes.txt", "extension")
+
+	var buf bytes.Buffer
+	printSummaryJSON(&buf, stats, nil)
+	if strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("summary = %q, want a single line", buf.String())
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{"sorted": 3.0, "failed": 1.0, "bytes": 2048.0, "exit_status": 0.0} {
+		if got[key] != want {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	if reasons, _ := got["skipped_by_reason"].(map[string]interface{}); reasons["extension"] != 1.0 {
+		t.Errorf("skipped_by_reason = %v, want one extension", got["skipped_by_reason"])
+	}
+	if _, ok := got["error"]; ok {
+		t.Errorf("summary of a successful run has an error: %v", got["error"])
+	}
+
+	buf.Reset()
+	printSummaryJSON(&buf, stats, errors.New("destination is full"))
+	got = nil
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["exit_status"] != 1.0 || got["error"] != "destination is full" {
+		t.Errorf("summary of a failed run = %v, want exit status 1 and its error", got)
+	}
+}
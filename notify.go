@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/charmbracelet/log"
+
+	"photo-video-sort/m/v2/sorter"
+)
+
+// notify shows a desktop notification summarizing a finished run, using the
+// notification tool native to the platform. Failing to notify is not fatal.
+func notify(stats *sorter.Stats) {
+	title := "Sort finished"
+	message := fmt.Sprintf("%d files sorted, %d failed", stats.Sorted+stats.Quarantined, stats.Failed)
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf(`[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode('%s')) | Out-Null
+$text.Item(1).AppendChild($xml.CreateTextNode('%s')) | Out-Null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('exif-sorter').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`,
+			strings.ReplaceAll(title, "'", "''"), strings.ReplaceAll(message, "'", "''"))
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		cmd = exec.Command("notify-send", title, message)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Debug("Unable to show notification", "err", err, "output", strings.TrimSpace(string(out)))
+	}
+}
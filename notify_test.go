@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"photo-video-sort/m/v2/sorter"
+)
+
+func TestNotify(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the stub notify-send is a shell script")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "args")
+	stub := "#!/bin/sh\nprintf '%s|%s' \"$1\" \"$2\" > " + out + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "notify-send"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	stats := sorter.NewStats()
+	stats.Sorted, stats.Quarantined, stats.Failed = 3, 1, 2
+	notify(stats)
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Sort finished|4 files sorted, 2 failed"; string(got) != want {
+		t.Errorf("notification = %q, want %q", got, want)
+	}
+}
+
+func TestNotifyUnavailable(t *testing.T) {
+	// Without a notification tool the run still finishes
+	t.Setenv("PATH", t.TempDir())
+	notify(sorter.NewStats())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// presets are bundles of flag defaults laying the destination out the way a
+// photo server expects its library, keyed by the name -preset takes.
+var presets = map[string]map[string]string{
+	// Immich's default storage template, {{y}}/{{y}}-{{MM}}-{{dd}}/{{filename}},
+	// keeping the original file names. Like Immich, files without a date in
+	// their metadata or name are dated by their modification time.
+	"immich": {
+		"datefmt":        "2006/2006-01-02",
+		"mtime-fallback": "true",
+	},
+	// PhotoPrism's import layout, originals/YYYY/MM/YYYYMMDD_HHMMSS.ext, less
+	// the checksum PhotoPrism appends to names, which -on-conflict rename
+	// stands in for. A RAW file and the JPEG shot along with it share their
+	// date, and so their name, which PhotoPrism stacks them by.
+	"photoprism": {
+		"datefmt":        "2006/01",
+		"name":           "20060102_150405",
+		"mtime-fallback": "true",
+		"on-conflict":    "rename",
+	},
+}
+
+// presetNames returns the names of the presets, sorted.
+func presetNames() string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyPreset sets the flags of the named preset in flags, leaving those
+// given on the command line alone.
+func applyPreset(flags *flag.FlagSet, name string) error {
+	preset, ok := presets[name]
+	if !ok {
+		return errors.Errorf("unknown preset %q, expected one of %s", name, presetNames())
+	}
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for flagName, value := range preset {
+		if given[flagName] {
+			continue
+		}
+		if err := flags.Set(flagName, value); err != nil {
+			return errors.Wrapf(err, "preset %s setting -%s", name, flagName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"photo-video-sort/m/v2/sorter"
+)
+
+// presetFlags returns a flag set holding the flags presets set, with the
+// defaults of the command line, parsed from args.
+func presetFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	flags := flag.NewFlagSet("exif-sorter", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.String("datefmt", "2006/01/02", "")
+	flags.String("name", "", "")
+	flags.Bool("mtime-fallback", false, "")
+	flags.String("on-conflict", sorter.ConflictRename, "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestPresets(t *testing.T) {
+	date := time.Date(2023, 5, 1, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		preset string
+		args   []string
+		want   map[string]string
+		dest   string
+	}{
+		{
+			preset: "immich",
+			want:   map[string]string{"datefmt": "2006/2006-01-02", "name": "", "mtime-fallback": "true", "on-conflict": "rename"},
+			dest:   "2023/2023-05-01/IMG_0001.jpg",
+		},
+		{
+			preset: "photoprism",
+			want:   map[string]string{"datefmt": "2006/01", "name": "20060102_150405", "mtime-fallback": "true", "on-conflict": "rename"},
+			dest:   "2023/05/20230501_123456.jpg",
+		},
+		{
+			preset: "photoprism",
+			args:   []string{"-name", "", "-on-conflict", "skip"},
+			want:   map[string]string{"datefmt": "2006/01", "name": "", "mtime-fallback": "true", "on-conflict": "skip"},
+			dest:   "2023/05/IMG_0001.jpg",
+		},
+		{
+			preset: "immich",
+			args:   []string{"-datefmt", "2006", "-mtime-fallback=false"},
+			want:   map[string]string{"datefmt": "2006", "name": "", "mtime-fallback": "false", "on-conflict": "rename"},
+			dest:   "2023/IMG_0001.jpg",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			flags := presetFlags(t, tt.args...)
+			if err := applyPreset(flags, tt.preset); err != nil {
+				t.Fatalf("applyPreset() error = %v", err)
+			}
+			for name, want := range tt.want {
+				if got := flags.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s = %q, want %q", name, got, want)
+				}
+			}
+
+			opts := sorter.Options{
+				Dest:         "library",
+				FolderFormat: flags.Lookup("datefmt").Value.String(),
+				NameFormat:   flags.Lookup("name").Value.String(),
+			}
+			if err := sorter.ValidateFolderTemplate(opts.FolderFormat); err != nil {
+				t.Errorf("invalid folder template: %v", err)
+			}
+			if err := sorter.ValidateTemplate(opts.NameFormat); opts.NameFormat != "" && err != nil {
+				t.Errorf("invalid name template: %v", err)
+			}
+			result := sorter.Result{Path: filepath.Join("src", "IMG_0001.jpg"), Date: date, Source: sorter.SourceExif}
+			dest, err := sorter.ResolveDestination(result, opts)
+			if err != nil {
+				t.Fatalf("ResolveDestination() error = %v", err)
+			}
+			if want := filepath.Join("library", filepath.FromSlash(tt.dest)); dest != want {
+				t.Errorf("destination = %q, want %q", dest, want)
+			}
+		})
+	}
+}
+
+func TestPresetUnknown(t *testing.T) {
+	if err := applyPreset(presetFlags(t), "lightroom"); err == nil {
+		t.Error("applyPreset() error = nil, want an error for an unknown preset")
+	}
+}
+
+func TestPresetsSetKnownFlags(t *testing.T) {
+	flags := presetFlags(t)
+	for name, preset := range presets {
+		for flagName := range preset {
+			if flags.Lookup(flagName) == nil {
+				t.Errorf("preset %s sets -%s, which the test does not know", name, flagName)
+			}
+		}
+	}
+}
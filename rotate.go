@@ -0,0 +1,140 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// rotateFile archives the file at path as path.1.gz, shifting older archives
+// up to path.keep.gz and dropping the oldest, so that path can be written
+// afresh. Nothing happens when there is no file at path or keep is zero, in
+// which case it is simply overwritten.
+func rotateFile(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	os.Remove(archiveName(path, keep))
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(archiveName(path, n), archiveName(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	if err := compressFile(path, archiveName(path, 1)); err != nil {
+		return err
+	}
+	return errors.WithStack(os.Remove(path))
+}
+
+// archiveName returns the name of the nth archive of path.
+func archiveName(path string, n int) string {
+	return fmt.Sprintf("%s.%d.gz", path, n)
+}
+
+// compressFile writes the file at src gzipped to dest.
+func compressFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+	}
+	return errors.WithStack(err)
+}
+
+// rotatingWriter appends to a file, rotating it with rotateFile before a
+// write would grow it past maxSize.
+type rotatingWriter struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// openRotating opens the file at path for appending, rotated past maxSize
+// bytes, or never when maxSize is zero.
+func openRotating(path string, maxSize int64, keep int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, keep: keep}
+	return w, w.open()
+}
+
+// open opens the file for appending and measures it.
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+// Write implements io.Writer.
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return 0, errors.WithStack(os.ErrClosed)
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		w.file.Close()
+		if err := rotateFile(w.path, w.keep); err != nil {
+			return 0, err
+		}
+		if w.keep <= 0 {
+			os.Remove(w.path)
+		}
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, errors.WithStack(err)
+}
+
+// Close syncs and closes the file. Closing a nil or closed writer does
+// nothing.
+func (w *rotatingWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return errors.WithStack(err)
+}
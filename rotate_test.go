@@ -0,0 +1,129 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readArchive returns the uncompressed content of a gzipped archive.
+func readArchive(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestArchiveName(t *testing.T) {
+	if got := archiveName("/var/log/sort.log", 3); got != "/var/log/sort.log.3.gz" {
+		t.Errorf("archiveName() = %q, want %q", got, "/var/log/sort.log.3.gz")
+	}
+}
+
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sort.log")
+	w, err := openRotating(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every line but the first would grow the file past 10 bytes, so each
+	// rotates the previous one away.
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if content, _ := os.ReadFile(path); string(content) != "fourth\n" {
+		t.Errorf("log = %q, want %q", content, "fourth\n")
+	}
+	if got := readArchive(t, archiveName(path, 1)); got != "third\n" {
+		t.Errorf("archive 1 = %q, want %q", got, "third\n")
+	}
+	if got := readArchive(t, archiveName(path, 2)); got != "second\n" {
+		t.Errorf("archive 2 = %q, want %q", got, "second\n")
+	}
+	if _, err := os.Stat(archiveName(path, 3)); !os.IsNotExist(err) {
+		t.Errorf("archive 3 exists past the keep count, err = %v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	if _, err := io.WriteString(w, "late\n"); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write() after Close() error = %v, want os.ErrClosed", err)
+	}
+	var nilWriter *rotatingWriter
+	if err := nilWriter.Close(); err != nil {
+		t.Errorf("Close() on a nil writer error = %v", err)
+	}
+}
+
+func TestRotatingWriterAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sort.log")
+	if err := os.WriteFile(path, []byte("earlier\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := openRotating(path, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, strings.Repeat("x", 100)+"\n")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(content), "earlier\nxxx") {
+		t.Errorf("log = %q, want the earlier content followed by the new", content)
+	}
+	if _, err := os.Stat(archiveName(path, 1)); !os.IsNotExist(err) {
+		t.Errorf("rotated without a maximum size, err = %v", err)
+	}
+}
+
+func TestRotatingWriterNoKeep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sort.log")
+	w, err := openRotating(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "first\n")
+	io.WriteString(w, "second\n")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "second\n" {
+		t.Errorf("log = %q, want %q", content, "second\n")
+	}
+	if _, err := os.Stat(archiveName(path, 1)); !os.IsNotExist(err) {
+		t.Errorf("archived with a keep count of zero, err = %v", err)
+	}
+}
+
+func TestRotateFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := rotateFile(path, 3); err != nil {
+		t.Errorf("rotateFile() error = %v for a missing file", err)
+	}
+	if _, err := os.Stat(archiveName(path, 1)); !os.IsNotExist(err) {
+		t.Errorf("archived a missing file, err = %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+
+	"photo-video-sort/m/v2/sorter"
+)
+
+// server exposes the sorter over HTTP, with the options of the command line
+// as defaults for every request.
+type server struct {
+	opts sorter.Options
+
+	// addr is the address listened on, which requests must be sent to
+	addr string
+
+	// extractMu serializes extractions, which share one exiftool process
+	extractMu sync.Mutex
+	extractor *exiftool.Exiftool
+
+	// sortMu keeps sorts from running at once
+	sortMu sync.Mutex
+}
+
+// resolveRequest asks where the file at Path would be sorted to.
+type resolveRequest struct {
+	Path string `json:"path"`
+}
+
+// resolveResponse is the destination of a file and the date it was found.
+type resolveResponse struct {
+	Src    string                 `json:"src"`
+	Dest   string                 `json:"dest"`
+	Date   time.Time              `json:"date"`
+	Source sorter.DateSource      `json:"source"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// sortRequest runs a sort, overriding the source and destination, and only
+// planning it when DryRun is set.
+type sortRequest struct {
+	Src    string `json:"src"`
+	Dest   string `json:"dest"`
+	Copy   *bool  `json:"copy"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// sortResponse is the plan of a sort and how it went.
+type sortResponse struct {
+	Plan    []sorter.PlanEntry `json:"plan"`
+	Sorted  int                `json:"sorted"`
+	Skipped int                `json:"skipped"`
+	Failed  int                `json:"failed"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// serve listens on addr until the process is interrupted, answering
+// POST /resolve and POST /sort with JSON bodies.
+func serve(addr string, opts sorter.Options) error {
+	extractor, err := sorter.NewExtractor(opts)
+	if err != nil {
+		return err
+	}
+	defer extractor.Close()
+
+	s := &server{opts: opts, addr: addr, extractor: extractor}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/resolve", s.guard(s.handleResolve))
+	mux.HandleFunc("/sort", s.guard(s.handleSort))
+	log.Info("Serving", "addr", addr)
+	return errors.WithStack(http.ListenAndServe(addr, mux))
+}
+
+// handleResolve returns the destination and metadata of a source file.
+func (s *server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	var req resolveRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing path"))
+		return
+	}
+
+	s.extractMu.Lock()
+	result, err := sorter.ExtractDate(s.extractor, req.Path, s.opts)
+	s.extractMu.Unlock()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	dest, err := sorter.ResolveDestination(result, s.opts)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resolveResponse{Src: req.Path, Dest: dest, Date: result.Date, Source: result.Source, Fields: result.Fields})
+}
+
+// handleSort plans a sort and executes it unless it is a dry run. The
+// destination is locked for the time of the sort, unless it is remote.
+func (s *server) handleSort(w http.ResponseWriter, r *http.Request) {
+	var req sortRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	opts := s.opts
+	if req.Src != "" {
+		opts.Src = req.Src
+	}
+	if req.Dest != "" {
+		opts.Dest = req.Dest
+	}
+	if req.Copy != nil {
+		opts.Copy = *req.Copy
+	}
+	if opts.Src == "" || opts.Dest == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing source or destination"))
+		return
+	}
+
+	s.sortMu.Lock()
+	defer s.sortMu.Unlock()
+	stats := sorter.NewStats()
+	plan, err := sorter.Plan(opts, stats)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	resp := sortResponse{Plan: plan}
+	if !req.DryRun {
+		if opts.Storage == nil {
+			lock, err := sorter.AcquireLock(opts.Dest, false)
+			if err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			defer lock.Release()
+		}
+		if err := sorter.Execute(plan, opts, stats); err != nil {
+			resp.Error = err.Error()
+		}
+	}
+	resp.Sorted, resp.Skipped, resp.Failed = stats.Sorted+stats.Quarantined, stats.Skipped+stats.SkippedExists+stats.InPlace+stats.Duplicates, stats.Failed
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// guard only lets through requests that a web page cannot forge: JSON
+// bodies, which browsers never send across origins without asking first,
+// with no Origin, sent to the address listened on rather than to a name
+// rebound to it.
+func (s *server) guard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host, s.addr) {
+			writeError(w, http.StatusForbidden, errors.Errorf("unexpected host %q", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			writeError(w, http.StatusForbidden, errors.Errorf("cross-origin requests are not allowed, got origin %q", origin))
+			return
+		}
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, errors.New("only application/json is supported"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowedHost reports whether the Host of a request names addr, the address
+// listened on. When listening on every interface, any IP address of the
+// machine or localhost is accepted with the port of addr, and when
+// listening on a loopback address, any loopback address or localhost is.
+// Other names are refused, as a DNS name rebound to the machine is how a web
+// page would reach it.
+func allowedHost(host, addr string) bool {
+	if strings.EqualFold(host, addr) {
+		return true
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return false
+	}
+	boundName, boundPort, err := net.SplitHostPort(addr)
+	if err != nil || port != boundPort {
+		return false
+	}
+	bound := net.ParseIP(boundName)
+	switch {
+	case boundName == "" || bound != nil && bound.IsUnspecified():
+		return strings.EqualFold(name, "localhost") || net.ParseIP(name) != nil
+	case bound != nil && bound.IsLoopback():
+		return strings.EqualFold(name, "localhost") || net.ParseIP(name).IsLoopback()
+	}
+	return strings.EqualFold(name, boundName)
+}
+
+// decodeRequest decodes the JSON body of a POST request into v, answering
+// with an error and returning false when it cannot.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("only POST is supported"))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid request"))
+		return false
+	}
+	return true
+}
+
+// writeJSON answers with v encoded as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Error while writing response", "err", err)
+	}
+}
+
+// writeError answers with err as a JSON error.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAllowedHost(t *testing.T) {
+	tests := []struct {
+		host string
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:8080", "127.0.0.1:8080", true},
+		{"localhost:8080", "127.0.0.1:8080", true},
+		{"[::1]:8080", "127.0.0.1:8080", true},
+		{"LOCALHOST:8080", "localhost:8080", true},
+		{"192.168.1.10:8080", "127.0.0.1:8080", false},
+		{"127.0.0.1:9090", "127.0.0.1:8080", false},
+		{"evil.example.com:8080", "127.0.0.1:8080", false},
+		{"evil.example.com:8080", ":8080", false},
+		{"192.168.1.10:8080", ":8080", true},
+		{"localhost:8080", "0.0.0.0:8080", true},
+		{"192.168.1.10:8080", "192.168.1.10:8080", true},
+		{"nas.local:8080", "nas.local:8080", true},
+		{"other.local:8080", "nas.local:8080", false},
+		{"127.0.0.1", "127.0.0.1:8080", false},
+		{"", "127.0.0.1:8080", false},
+	}
+	for _, tt := range tests {
+		if got := allowedHost(tt.host, tt.addr); got != tt.want {
+			t.Errorf("allowedHost(%q, %q) = %v, want %v", tt.host, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	tests := []struct {
+		name        string
+		host        string
+		origin      string
+		contentType string
+		want        int
+	}{
+		{"allowed", "127.0.0.1:8080", "", "application/json", http.StatusOK},
+		{"charset", "localhost:8080", "", "application/json; charset=utf-8", http.StatusOK},
+		{"foreign host", "evil.example.com:8080", "", "application/json", http.StatusForbidden},
+		{"origin", "127.0.0.1:8080", "http://evil.example.com", "application/json", http.StatusForbidden},
+		{"same origin", "127.0.0.1:8080", "http://127.0.0.1:8080", "application/json", http.StatusForbidden},
+		{"form", "127.0.0.1:8080", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"text", "127.0.0.1:8080", "", "text/plain", http.StatusUnsupportedMediaType},
+		{"no content type", "127.0.0.1:8080", "", "", http.StatusUnsupportedMediaType},
+	}
+	s := &server{addr: "127.0.0.1:8080"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := s.guard(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/sort", strings.NewReader("{}"))
+			req.Host = tt.host
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if called != (tt.want == http.StatusOK) {
+				t.Errorf("handler called = %v with status %d", called, rec.Code)
+			}
+			if tt.want != http.StatusOK && !strings.Contains(rec.Body.String(), `"error"`) {
+				t.Errorf("body = %s, want a JSON error", rec.Body)
+			}
+		})
+	}
+}
+
+func TestHandleSortRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"get", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"invalid body", http.MethodPost, "{", http.StatusBadRequest},
+		{"missing destination", http.MethodPost, `{"src": "/photos"}`, http.StatusBadRequest},
+	}
+	s := &server{addr: "127.0.0.1:8080"}
+	server := httptest.NewServer(s.guard(s.handleSort))
+	defer server.Close()
+	s.addr = strings.TrimPrefix(server.URL, "http://")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+"/sort", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
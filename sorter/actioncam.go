@@ -0,0 +1,77 @@
+package sorter
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Extensions of the files action cams and 360 cameras write: Insta360
+// photos and videos, and the low resolution proxies GoPro and Insta360 record
+// next to every video for previews.
+const (
+	extInsp = ".insp"
+	extInsv = ".insv"
+	extLrv  = ".lrv"
+)
+
+func init() {
+	mediaExtensions[extInsp] = kindImage
+	mediaExtensions[extInsv] = kindVideo
+	mediaExtensions[extLrv] = kindVideo
+}
+
+// goproProxyRegex matches the name of a GoPro proxy, such as GL010123,
+// capturing the chapter and file number it shares with its video, GX010123
+// or GH010123.
+var goproProxyRegex = regexp.MustCompile(`(?i)^GL(\d{6})$`)
+
+// insta360ProxyRegex matches the name of an Insta360 proxy, such as
+// LRV_20230501_120000_01_001, capturing the time and number it shares with
+// its video, VID_20230501_120000_00_001.
+var insta360ProxyRegex = regexp.MustCompile(`(?i)^LRV_(\d{8}_\d{6})_\d{2}_(\d{3})$`)
+
+// insta360VideoRegex matches the name of an Insta360 video, capturing the
+// time and number it shares with its proxy.
+var insta360VideoRegex = regexp.MustCompile(`(?i)^VID_(\d{8}_\d{6})_\d{2}_(\d{3})$`)
+
+// pairProxies returns the index of the full resolution video of every .lrv
+// proxy among files, keyed by the index of the proxy. Proxies are paired with
+// the video of the same name, GOPR0123.MP4 for GOPR0123.LRV, or of the
+// matching GoPro or Insta360 name, in the same directory.
+func pairProxies(files []mediaFile) map[int]int {
+	videos := make(map[string]int)
+	for i, file := range files {
+		ext := fileExt(file.path)
+		if mediaKind(file.path) != kindVideo || ext == extLrv {
+			continue
+		}
+		dir, base := filepath.Dir(file.path), strings.TrimSuffix(filepath.Base(file.path), filepath.Ext(file.path))
+		videos[strings.ToLower(filepath.Join(dir, base))] = i
+		if matches := insta360VideoRegex.FindStringSubmatch(base); matches != nil {
+			videos[strings.ToLower(filepath.Join(dir, "VID_"+matches[1]+"_"+matches[2]))] = i
+		}
+	}
+
+	pairs := make(map[int]int)
+	for i, file := range files {
+		if fileExt(file.path) != extLrv {
+			continue
+		}
+		dir, base := filepath.Dir(file.path), strings.TrimSuffix(filepath.Base(file.path), filepath.Ext(file.path))
+		candidates := []string{base}
+		if matches := goproProxyRegex.FindStringSubmatch(base); matches != nil {
+			candidates = append(candidates, "GX"+matches[1], "GH"+matches[1])
+		}
+		if matches := insta360ProxyRegex.FindStringSubmatch(base); matches != nil {
+			candidates = append(candidates, "VID_"+matches[1]+"_"+matches[2])
+		}
+		for _, candidate := range candidates {
+			if j, ok := videos[strings.ToLower(filepath.Join(dir, candidate))]; ok {
+				pairs[i] = j
+				break
+			}
+		}
+	}
+	return pairs
+}
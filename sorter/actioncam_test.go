@@ -0,0 +1,68 @@
+package sorter
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestActionCamMediaKinds(t *testing.T) {
+	tests := map[string]string{
+		"IMG_20230501_120000_00_001.insp": kindImage,
+		"VID_20230501_120000_00_001.insv": kindVideo,
+		"GL010123.LRV":                    kindVideo,
+	}
+	for path, want := range tests {
+		if got := mediaKind(path); got != want {
+			t.Errorf("mediaKind(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestPairProxies(t *testing.T) {
+	files := []mediaFile{
+		{path: "src/GOPR0123.MP4"},
+		{path: "src/GOPR0123.LRV"},
+		{path: "src/GX010124.MP4"},
+		{path: "src/GL010124.LRV"},
+		{path: "src/gh010125.mp4"},
+		{path: "src/GL010125.lrv"},
+		{path: "src/VID_20230501_120000_00_001.insv"},
+		{path: "src/LRV_20230501_120000_01_001.lrv"},
+		// Proxies pair with a video of the same directory only
+		{path: "src/other/GL010124.LRV"},
+		// Proxies without a video, or beside a photo of their name, are
+		// left alone
+		{path: "src/GL010126.LRV"},
+		{path: "src/GOPR0127.JPG"},
+		{path: "src/GOPR0127.LRV"},
+	}
+	want := map[int]int{1: 0, 3: 2, 5: 4, 7: 6}
+	if got := pairProxies(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("pairProxies() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildPlanProxies(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	paths := []string{filepath.Join(src, "GX010124.MP4"), filepath.Join(src, "GL010124.LRV"), filepath.Join(src, "GL010126.LRV")}
+	writeFiles(t, paths...)
+
+	// The proxies carry the time they were last copied around, and only the
+	// paired one takes the date of its video
+	shot := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	copied := time.Date(2023, 8, 20, 9, 0, 0, 0, time.UTC)
+	files := []mediaFile{{path: paths[0], date: shot}, {path: paths[1], date: copied}, {path: paths[2], date: copied}}
+	opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01", OnConflict: ConflictRename}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{
+		filepath.Join(dest, "2023", "05", "GX010124.MP4"),
+		filepath.Join(dest, "2023", "05", "GL010124.LRV"),
+		filepath.Join(dest, "2023", "08", "GL010126.LRV"),
+	})
+}
@@ -0,0 +1,183 @@
+package sorter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CheckpointName is the name of the checkpoint kept in the destination
+// directory by resumable runs.
+const CheckpointName = ".exif-sorter.checkpoint"
+
+// ReasonCheckpoint skips a file in a directory an earlier run completed.
+const ReasonCheckpoint = "checkpoint"
+
+// checkpointInterval is how often the checkpoint is saved while executing.
+const checkpointInterval = 10 * time.Second
+
+// Checkpoint remembers the source directories whose files were all sorted or
+// skipped, so a resumed run does not extract their dates again. A directory
+// with a file that failed is never completed. Directories are stored as
+// absolute paths.
+type Checkpoint struct {
+	mu       sync.Mutex
+	saveMu   sync.Mutex
+	path     string
+	done     map[string]bool
+	pending  map[string]int
+	failed   map[string]bool
+	lastSave time.Time
+}
+
+// checkpointFile is the on-disk format of a Checkpoint.
+type checkpointFile struct {
+	Version int      `json:"version"`
+	Done    []string `json:"done"`
+}
+
+// OpenCheckpoint loads the checkpoint at path. A missing checkpoint starts
+// empty and is created when first saved.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	cp := &Checkpoint{path: path, done: make(map[string]bool), pending: make(map[string]int), failed: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "invalid checkpoint %q", path)
+	}
+	for _, dir := range file.Done {
+		cp.done[dir] = true
+	}
+	return cp, nil
+}
+
+// Completed returns the number of directories completed so far.
+func (cp *Checkpoint) Completed() int {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return len(cp.done)
+}
+
+// checkpointDir returns the directory of path as stored in a checkpoint.
+func checkpointDir(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return filepath.Dir(path)
+	}
+	return dir
+}
+
+// completed reports whether the directory of the file at path was completed.
+func (cp *Checkpoint) completed(path string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.done[checkpointDir(path)]
+}
+
+// fail keeps the directory of the file at path from being completed.
+func (cp *Checkpoint) fail(path string) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.failed[checkpointDir(path)] = true
+}
+
+// expect counts the entries of a plan left to finish in each directory.
+func (cp *Checkpoint) expect(plan []PlanEntry) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for _, entry := range plan {
+		cp.pending[checkpointDir(entry.Src)]++
+	}
+}
+
+// finish records that the entry for the file at path is over, and completes
+// its directory once no entry of it is left. The checkpoint is saved every
+// checkpointInterval.
+func (cp *Checkpoint) finish(path string, ok bool) error {
+	cp.mu.Lock()
+	dir := checkpointDir(path)
+	if !ok {
+		cp.failed[dir] = true
+	}
+	cp.pending[dir]--
+	if cp.pending[dir] == 0 && !cp.failed[dir] {
+		cp.done[dir] = true
+	}
+	due := time.Since(cp.lastSave) >= checkpointInterval
+	cp.mu.Unlock()
+	if !due {
+		return nil
+	}
+	return cp.Save()
+}
+
+// Save writes the checkpoint. It is synced to disk under a temporary name and
+// then renamed over the previous one, so a crash leaves either checkpoint
+// intact.
+func (cp *Checkpoint) Save() error {
+	cp.saveMu.Lock()
+	defer cp.saveMu.Unlock()
+	cp.mu.Lock()
+	file := checkpointFile{Version: 1}
+	for dir := range cp.done {
+		file.Done = append(file.Done, dir)
+	}
+	cp.lastSave = time.Now()
+	cp.mu.Unlock()
+	sort.Strings(file.Done)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return replaceFile(cp.path, append(data, '\n'))
+}
+
+// Remove deletes the checkpoint, once a run no longer needs resuming.
+func (cp *Checkpoint) Remove() error {
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// failCheckpoint keeps the directory of the file at path from being
+// completed, when checkpointing.
+func (opts Options) failCheckpoint(path string) {
+	if opts.Checkpoint != nil {
+		opts.Checkpoint.fail(path)
+	}
+}
+
+// stopCheckpoint keeps the directories a walk stopped early in from being
+// completed: the directory of the file at path and its parents up to the
+// source, whose files past path were never walked. It does nothing unless
+// checkpointing.
+func (opts Options) stopCheckpoint(path string) {
+	if opts.Checkpoint == nil {
+		return
+	}
+	root, err := filepath.Abs(opts.Src)
+	if err != nil {
+		root = opts.Src
+	}
+	cp := opts.Checkpoint
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	for dir := checkpointDir(path); ; dir = filepath.Dir(dir) {
+		cp.failed[dir] = true
+		if dir == root || filepath.Dir(dir) == dir {
+			return
+		}
+	}
+}
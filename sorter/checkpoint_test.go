@@ -0,0 +1,178 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointStoppedWalk(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	cp, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Src: src, Checkpoint: cp}
+
+	// The walk went through src/2022 and stopped in src/2023/may, before the
+	// rest of src/2023/may and of src were walked
+	walked := []string{
+		filepath.Join(src, "IMG_0001.jpg"),
+		filepath.Join(src, "2022", "IMG_0002.jpg"),
+		filepath.Join(src, "2023", "may", "IMG_0003.jpg"),
+	}
+	opts.stopCheckpoint(filepath.Join(src, "2023", "may", "IMG_0004.jpg"))
+
+	var plan []PlanEntry
+	for _, path := range walked {
+		plan = append(plan, PlanEntry{Src: path})
+	}
+	cp.expect(plan)
+	for _, path := range walked {
+		if err := cp.finish(path, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(src, "2022", "IMG_0005.jpg"), true},
+		{filepath.Join(src, "2023", "may", "IMG_0005.jpg"), false},
+		{filepath.Join(src, "2023", "IMG_0005.jpg"), false},
+		{filepath.Join(src, "IMG_0005.jpg"), false},
+	}
+	for _, tt := range tests {
+		if got := cp.completed(tt.path); got != tt.want {
+			t.Errorf("completed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, CheckpointName)
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Completed() != 0 || fileExists(path) {
+		t.Fatalf("a missing checkpoint opened with %d completed directories, or was created", cp.Completed())
+	}
+
+	done := []string{filepath.Join(dir, "src", "a", "IMG_0001.jpg"), filepath.Join(dir, "src", "a", "IMG_0002.jpg")}
+	failed := filepath.Join(dir, "src", "b", "IMG_0003.jpg")
+	var plan []PlanEntry
+	for _, path := range append(done, failed) {
+		plan = append(plan, PlanEntry{Src: path})
+	}
+	cp.expect(plan)
+	if err := cp.finish(done[0], true); err != nil {
+		t.Fatal(err)
+	}
+	if cp.completed(done[1]) {
+		t.Error("a directory was completed with an entry left")
+	}
+	if err := cp.finish(done[1], true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.finish(failed, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.completed(filepath.Join(dir, "src", "a", "IMG_0009.jpg")) || saved.completed(failed) || saved.Completed() != 1 {
+		t.Errorf("reopened checkpoint completed %d directories, want only the one without failures", saved.Completed())
+	}
+
+	if err := saved.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(path) {
+		t.Error("checkpoint still exists once removed")
+	}
+	if err := saved.Remove(); err != nil {
+		t.Errorf("Remove() error = %v for a removed checkpoint", err)
+	}
+}
+
+func TestOpenCheckpointInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CheckpointName)
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCheckpoint(path); err == nil {
+		t.Error("OpenCheckpoint() error = nil for an invalid checkpoint")
+	}
+}
+
+func TestExecuteCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	cp, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted := []string{filepath.Join(dir, "src", "a", "IMG_0001.jpg"), filepath.Join(dir, "src", "a", "IMG_0002.jpg")}
+	writeFiles(t, sorted...)
+	skipped := filepath.Join(dir, "src", "b", "IMG_0003.jpg")
+	writeFiles(t, skipped)
+	missing := filepath.Join(dir, "src", "c", "IMG_0004.jpg")
+
+	var plan []PlanEntry
+	for _, path := range append(sorted, missing) {
+		plan = append(plan, PlanEntry{Src: path, Dest: filepath.Join(dir, "dest", filepath.Base(path)), Action: ActionCopy})
+	}
+	plan = append(plan, PlanEntry{Src: skipped, Dest: filepath.Join(dir, "dest", "IMG_0003.jpg"), Action: ActionSkip})
+	if err := Execute(plan, Options{Checkpoint: cp, CopyWorkers: 2}, NewStats()); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	// Directories whose files were all sorted or skipped are completed, and
+	// the one whose file failed is retried
+	saved, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{sorted[0]: true, skipped: true, missing: false} {
+		if got := saved.completed(path); got != want {
+			t.Errorf("completed(%q) = %v after Execute(), want %v", path, got, want)
+		}
+	}
+}
+
+func TestCollectFilesSkipsCompleted(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	done := filepath.Join(src, "a", "notes.txt")
+	left := filepath.Join(src, "b", "notes.txt")
+	writeFiles(t, done, left)
+
+	cp, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.expect([]PlanEntry{{Src: done}})
+	if err := cp.finish(done, true); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := NewStats()
+	stats.ExplainSkips = true
+	files := collectFiles(Options{Src: src, IncludeNonMedia: true, Checkpoint: cp}, stats)
+	if len(files) != 1 || files[0].path != left {
+		t.Errorf("collectFiles() found %v, want only %s", files, left)
+	}
+	want := []SkippedFile{{Path: done, Reason: ReasonCheckpoint}}
+	if got := stats.Skips(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Skips() = %v, want %v", got, want)
+	}
+}
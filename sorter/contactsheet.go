@@ -0,0 +1,198 @@
+package sorter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// contactSheetName is the name of the contact sheet written into each top
+// level folder of the destination.
+const contactSheetName = "index.html"
+
+// thumbnailsDir is the hidden folder next to a contact sheet holding the
+// thumbnails embedded in the files it lists, below their path on the sheet.
+const thumbnailsDir = ".thumbnails"
+
+// contactSheetTemplate renders a contact sheet. Files are shown by the
+// thumbnail embedded in them when they have one, and photos without one are
+// scaled down by the browser. Other videos and documents are linked.
+var contactSheetTemplate = template.Must(template.New("sheet").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+figure { display: inline-block; margin: 0.5em; width: 200px; vertical-align: top; }
+img { max-width: 200px; max-height: 200px; }
+figcaption { font-size: small; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Files}}<figure>
+<a href="{{.Path}}">{{if .Thumb}}<img src="{{.Thumb}}" loading="lazy" alt="{{.Name}}">{{else if .Image}}<img src="{{.Path}}" loading="lazy" alt="{{.Name}}">{{else if .Video}}&#9654; video{{else}}&#128196; document{{end}}</a>
+<figcaption>{{.Path}}</figcaption>
+</figure>
+{{end}}</body>
+</html>
+`))
+
+// contactSheetFile is a photo or video listed on a contact sheet.
+type contactSheetFile struct {
+	Path  string
+	Name  string
+	Thumb string
+	Image bool
+	Video bool
+}
+
+// contactSheetRoots returns the top level folders of root, such as year
+// folders, that the given folders lie in.
+func contactSheetRoots(root string, folders []FolderSummary) []string {
+	seen := make(map[string]bool)
+	var roots []string
+	for _, folder := range folders {
+		rel, err := filepath.Rel(root, folder.Dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		top := filepath.Join(root, strings.Split(filepath.ToSlash(rel), "/")[0])
+		if !seen[top] {
+			seen[top] = true
+			roots = append(roots, top)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// writeContactSheet writes a contact sheet listing every photo and video
+// below dir, along with their thumbnails. Files are shown without thumbnails
+// when exiftool fails to extract them.
+func writeContactSheet(dir string, opts Options) error {
+	var files []contactSheetFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && isHidden(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		kind := mediaKind(path)
+		if info.IsDir() || kind == "" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, contactSheetFile{Path: filepath.ToSlash(rel), Name: info.Name(), Image: kind == kindImage, Video: kind == kindVideo})
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	thumbs, err := writeThumbnails(dir, files, opts)
+	if err != nil {
+		log.Warn("Error while extracting thumbnails, showing files as they are", "dir", dir, "err", err)
+	}
+	for i := range files {
+		files[i].Thumb = thumbs[files[i].Path]
+	}
+
+	f, err := os.Create(filepath.Join(dir, contactSheetName))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = contactSheetTemplate.Execute(f, struct {
+		Title string
+		Files []contactSheetFile
+	}{filepath.Base(dir), files})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.WithStack(err)
+}
+
+// writeContactSheets writes a contact sheet into every top level folder of
+// root that files were sorted into. Failures are logged and otherwise
+// ignored.
+func writeContactSheets(root string, folders []FolderSummary, opts Options) {
+	for _, dir := range contactSheetRoots(root, folders) {
+		if err := writeContactSheet(dir, opts); err != nil {
+			log.Error("Error while writing contact sheet", "dir", dir, "err", err)
+			continue
+		}
+		log.Info("Wrote contact sheet", "path", filepath.Join(dir, contactSheetName))
+	}
+}
+
+// writeThumbnails writes the EXIF thumbnails of the files of a contact sheet
+// of dir into its thumbnails folder, replacing those of earlier sheets, and
+// returns their paths on the sheet keyed by the path of their file. A single
+// exiftool run reads them all, as base64 in its JSON output.
+func writeThumbnails(dir string, files []contactSheetFile, opts Options) (map[string]string, error) {
+	if err := os.RemoveAll(filepath.Join(dir, thumbnailsDir)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	var args strings.Builder
+	for _, file := range files {
+		args.WriteString(filepath.FromSlash(file.Path) + "\n")
+	}
+	cmd := exec.Command(exiftoolBinary(opts), "-json", "-b", "-ThumbnailImage", "-@", "-")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(args.String())
+
+	// exiftool fails when any file could not be read, but still reports the
+	// others
+	out, err := cmd.Output()
+	var results []struct {
+		SourceFile     string
+		ThumbnailImage string
+	}
+	if jsonErr := json.Unmarshal(out, &results); jsonErr != nil {
+		if err == nil {
+			err = jsonErr
+		}
+		return nil, errors.Wrap(err, "running exiftool")
+	}
+
+	thumbs := make(map[string]string)
+	for _, result := range results {
+		if !strings.HasPrefix(result.ThumbnailImage, "base64:") {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(result.ThumbnailImage, "base64:"))
+		if err != nil {
+			return thumbs, errors.Wrapf(err, "invalid thumbnail of %q", result.SourceFile)
+		}
+		rel := filepath.ToSlash(result.SourceFile)
+		thumb := thumbnailsDir + "/" + rel + ".jpg"
+		path := filepath.Join(dir, filepath.FromSlash(thumb))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return thumbs, errors.WithStack(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return thumbs, errors.WithStack(err)
+		}
+		thumbs[rel] = thumb
+	}
+	return thumbs, nil
+}
@@ -0,0 +1,110 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// stubThumbnailExiftool answers the files listed on its standard input with
+// the thumbnail JPEGDATA for those whose name contains thumb, and fails as
+// exiftool does when some files could not be read.
+const stubThumbnailExiftool = `#!/bin/sh
+sep=""
+printf '['
+while read -r f; do
+	case "$f" in
+	*thumb*) printf '%s{"SourceFile":"%s","ThumbnailImage":"base64:SlBFR0RBVEE="}' "$sep" "$f" ;;
+	*) printf '%s{"SourceFile":"%s"}' "$sep" "$f" ;;
+	esac
+	sep=","
+done
+printf ']\n'
+exit 1
+`
+
+func TestContactSheetRoots(t *testing.T) {
+	root := "dest"
+	folders := []FolderSummary{
+		{Dir: filepath.Join(root, "2023", "05", "01")},
+		{Dir: filepath.Join(root, "2022", "12")},
+		{Dir: filepath.Join(root, "2023", "06")},
+		{Dir: root},
+		{Dir: filepath.Join("elsewhere", "2021")},
+	}
+	want := []string{filepath.Join(root, "2022"), filepath.Join(root, "2023")}
+	if got := contactSheetRoots(root, folders); !reflect.DeepEqual(got, want) {
+		t.Errorf("contactSheetRoots() = %v, want %v", got, want)
+	}
+}
+
+func TestWriteContactSheet(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub exiftool is a shell script")
+	}
+	binary := filepath.Join(t.TempDir(), "exiftool")
+	writeFile(t, binary, stubThumbnailExiftool)
+	if err := os.Chmod(binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "2023")
+	writeFiles(t,
+		filepath.Join(dir, "05", "IMG_thumb.jpg"),
+		filepath.Join(dir, "05", "IMG_plain.jpg"),
+		filepath.Join(dir, "06", "VID_thumb.mp4"),
+		filepath.Join(dir, "06", "VID_plain.mp4"),
+		filepath.Join(dir, "06", "notes.txt"),
+		filepath.Join(dir, "06", ".hidden.jpg"),
+		filepath.Join(dir, thumbnailsDir, "05", "IMG_gone.jpg.jpg"),
+	)
+	if err := writeContactSheet(dir, Options{ExiftoolPath: binary}); err != nil {
+		t.Fatalf("writeContactSheet() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, contactSheetName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet := string(data)
+
+	for _, want := range []string{
+		`<img src=".thumbnails/05/IMG_thumb.jpg.jpg"`,
+		`<img src="05/IMG_plain.jpg"`,
+		`<img src=".thumbnails/06/VID_thumb.mp4.jpg"`,
+		`<a href="06/VID_plain.mp4">&#9654; video</a>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("contact sheet lacks %s:\n%s", want, sheet)
+		}
+	}
+	for _, unwanted := range []string{"notes.txt", ".hidden.jpg", "IMG_gone"} {
+		if strings.Contains(sheet, unwanted) {
+			t.Errorf("contact sheet lists %s:\n%s", unwanted, sheet)
+		}
+	}
+	if thumb, _ := os.ReadFile(filepath.Join(dir, thumbnailsDir, "05", "IMG_thumb.jpg.jpg")); string(thumb) != "JPEGDATA" {
+		t.Errorf("thumbnail = %q, want %q", thumb, "JPEGDATA")
+	}
+	if fileExists(filepath.Join(dir, thumbnailsDir, "05", "IMG_gone.jpg.jpg")) {
+		t.Error("the thumbnail of an earlier sheet was kept")
+	}
+}
+
+func TestWriteContactSheetWithoutExiftool(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "2023")
+	writeFiles(t, filepath.Join(dir, "05", "IMG_0001.jpg"))
+	opts := Options{ExiftoolPath: filepath.Join(t.TempDir(), "missing-exiftool")}
+	if err := writeContactSheet(dir, opts); err != nil {
+		t.Fatalf("writeContactSheet() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, contactSheetName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<img src="05/IMG_0001.jpg"`) {
+		t.Errorf("contact sheet does not show the photo itself:\n%s", data)
+	}
+}
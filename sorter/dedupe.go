@@ -0,0 +1,101 @@
+package sorter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ReasonDuplicate skips a file whose content is already in the library.
+const ReasonDuplicate = "duplicate"
+
+// DedupeDB remembers the content of the files placed in a library across
+// runs, so that duplicates imported later are caught. Paths are stored
+// relative to the library root they were placed in with slash separators, so
+// the database moves along with the library. A library spread across several
+// volumes has a root per volume, and a file keeps the same path below
+// whichever root it lands in.
+type DedupeDB struct {
+	mu     sync.Mutex
+	path   string
+	roots  []string
+	hashes map[string]string
+}
+
+// dedupeFile is the on-disk format of a DedupeDB.
+type dedupeFile struct {
+	Version int               `json:"version"`
+	Hashes  map[string]string `json:"hashes"`
+}
+
+// OpenDedupeDB loads the dedupe database at path for the library at roots. A
+// missing database is created empty when first saved.
+func OpenDedupeDB(path string, roots ...string) (*DedupeDB, error) {
+	db := &DedupeDB{path: path, roots: roots, hashes: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var file dedupeFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "invalid dedupe database %q", path)
+	}
+	for hash, rel := range file.Hashes {
+		db.hashes[hash] = rel
+	}
+	return db, nil
+}
+
+// lookup returns where the content with the given hash lives in the library,
+// in whichever of its roots holds it. Entries whose file is gone are
+// forgotten.
+func (db *DedupeDB) lookup(hash string) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	rel, ok := db.hashes[hash]
+	if !ok {
+		return "", false
+	}
+	for _, root := range db.roots {
+		if path := filepath.Join(root, filepath.FromSlash(rel)); fileExists(path) {
+			return path, true
+		}
+	}
+	delete(db.hashes, hash)
+	return "", false
+}
+
+// add records that the content with the given hash was placed at path, below
+// one of the roots of the library. Paths outside of the library are not
+// recorded.
+func (db *DedupeDB) add(hash, path string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, root := range db.roots {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		db.hashes[hash] = filepath.ToSlash(rel)
+		return
+	}
+}
+
+// Save writes the database. It is synced to disk under a temporary name and
+// then renamed over the previous one, so a crash leaves either database
+// intact.
+func (db *DedupeDB) Save() error {
+	db.mu.Lock()
+	data, err := json.Marshal(dedupeFile{Version: 1, Hashes: db.hashes})
+	db.mu.Unlock()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return replaceFile(db.path, append(data, '\n'))
+}
@@ -0,0 +1,106 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDedupeDBRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dedupe.json")
+	first, second := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	photo, video := filepath.Join(first, "2023", "IMG_0001.jpg"), filepath.Join(second, "2023", "VID_0001.mp4")
+	writeFiles(t, photo, video)
+
+	db, err := OpenDedupeDB(path, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.add("photo", photo)
+	db.add("video", video)
+	db.add("outside", filepath.Join(dir, "elsewhere", "IMG_0002.jpg"))
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind, stat error = %v", err)
+	}
+
+	// Paths are stored relative to their root
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), dir) || !strings.Contains(string(data), `"2023/VID_0001.mp4"`) {
+		t.Errorf("database holds %s, want paths relative to the roots", data)
+	}
+
+	loaded, err := OpenDedupeDB(path, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hash string
+		want string
+		ok   bool
+	}{
+		{"photo", photo, true},
+		{"video", video, true},
+		{"outside", "", false},
+		{"unknown", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := loaded.lookup(tt.hash); got != tt.want || ok != tt.ok {
+			t.Errorf("lookup(%q) = %q, %v, want %q, %v", tt.hash, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDedupeDBMovedLibrary(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dedupe.json")
+	old, moved := filepath.Join(dir, "old"), filepath.Join(dir, "moved")
+	writeFiles(t, filepath.Join(old, "2023", "IMG_0001.jpg"))
+
+	db, err := OpenDedupeDB(path, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.add("photo", filepath.Join(old, "2023", "IMG_0001.jpg"))
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(old, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	// The library is found on whichever root now holds it
+	loaded, err := OpenDedupeDB(path, filepath.Join(dir, "other"), moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := loaded.lookup("photo"); !ok || got != filepath.Join(moved, "2023", "IMG_0001.jpg") {
+		t.Errorf("lookup() = %q, %v, want the file in the moved library", got, ok)
+	}
+
+	// Entries whose file is gone are forgotten
+	if err := os.Remove(filepath.Join(moved, "2023", "IMG_0001.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := loaded.lookup("photo"); ok {
+		t.Errorf("lookup() = %q after the file was removed, want nothing", got)
+	}
+	if _, ok := loaded.hashes["photo"]; ok {
+		t.Error("entry of the removed file was kept")
+	}
+}
+
+func TestOpenDedupeDBInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.json")
+	writeFile(t, path, "{not json")
+	if _, err := OpenDedupeDB(path, "dest"); err == nil {
+		t.Error("OpenDedupeDB() error = nil, want an error for an invalid database")
+	}
+}
@@ -0,0 +1,82 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/charmbracelet/log"
+)
+
+// DuplicateGroup is a set of source files with the same content.
+type DuplicateGroup struct {
+	Hash  string
+	Size  int64
+	Paths []string
+}
+
+// Wasted returns the space taken by every copy but one.
+func (g DuplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Paths)-1)
+}
+
+// FindDuplicates walks the source directory and groups the files with the
+// same content, without extracting any date or touching the filesystem. Only
+// files of the same size are hashed. Groups are sorted by wasted space, the
+// largest first.
+func FindDuplicates(opts Options) ([]DuplicateGroup, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	bySize := make(map[int64][]string)
+	filepath.Walk(opts.Src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Error("Error while accessing file", "src", path, "err", err)
+			return nil
+		}
+		if !opts.IncludeHidden && path != opts.Src && isHidden(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || opts.mediaKind(path) == "" && !opts.IncludeNonMedia {
+			return nil
+		}
+		if opts.MinSize > 0 && info.Size() < opts.MinSize || opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+			return nil
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], path)
+		return nil
+	})
+
+	var groups []DuplicateGroup
+	for size, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+		byHash := make(map[string][]string)
+		for _, path := range paths {
+			hash, err := hashFile(osFS, path)
+			if err != nil {
+				log.Error("Error while hashing file", "src", path, "err", err)
+				continue
+			}
+			byHash[hash] = append(byHash[hash], path)
+		}
+		for hash, paths := range byHash {
+			if len(paths) > 1 {
+				sort.Strings(paths)
+				groups = append(groups, DuplicateGroup{Hash: hash, Size: size, Paths: paths})
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Wasted() != groups[j].Wasted() {
+			return groups[i].Wasted() > groups[j].Wasted()
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+	return groups, nil
+}
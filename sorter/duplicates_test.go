@@ -0,0 +1,41 @@
+package sorter
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	small := []string{filepath.Join(src, "a", "IMG_0001.jpg"), filepath.Join(src, "b", "IMG_0001.jpg")}
+	large := []string{filepath.Join(src, "a", "VID_0001.mp4"), filepath.Join(src, "b", "VID_0001.mp4"), filepath.Join(src, "c", "VID_0001.mp4")}
+	for _, path := range small {
+		writeFile(t, path, "photo")
+	}
+	for _, path := range large {
+		writeFile(t, path, "a longer video")
+	}
+	// Same size as the photos but a different content
+	writeFile(t, filepath.Join(src, "c", "IMG_0002.jpg"), "other")
+	writeFile(t, filepath.Join(src, ".hidden", "IMG_0001.jpg"), "photo")
+	writeFile(t, filepath.Join(src, "notes.txt"), "photo")
+
+	groups, err := FindDuplicates(Options{Src: src, OnConflict: ConflictRename, DatePrefer: PreferExif})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2: %+v", len(groups), groups)
+	}
+	if !reflect.DeepEqual(groups[0].Paths, large) || groups[0].Size != 14 || groups[0].Wasted() != 28 {
+		t.Errorf("first group = %+v, want the videos wasting 28 bytes", groups[0])
+	}
+	if !reflect.DeepEqual(groups[1].Paths, small) || groups[1].Wasted() != 5 {
+		t.Errorf("second group = %+v, want the photos wasting 5 bytes", groups[1])
+	}
+	if groups[0].Hash == groups[1].Hash {
+		t.Errorf("both groups have the hash %s", groups[0].Hash)
+	}
+}
@@ -0,0 +1,921 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// DateTags holds the EXIF tags consulted for each kind of media, in order
+// of preference.
+type DateTags struct {
+	Image    []string
+	Video    []string
+	Document []string
+
+	// File are the filesystem dates exiftool reports, tried before the
+	// modification time the walk found when falling back to it.
+	File []string
+}
+
+// DefaultDateTags are the tags consulted unless configured otherwise.
+// Besides DateTimeOriginal, images fall back to CreateDate, which is the EXIF
+// DateTimeDigitized and the scan date of scanned film, and to dates exiftool
+// reports from the thumbnail IFD and common maker notes, which survive some
+// stripping tools.
+// Videos prefer the QuickTime CreationDate key written by iPhones, which holds
+// the local time with its offset, then the ContentCreateDate and
+// DateTimeOriginal Android and Samsung phones write with their offset, over
+// MediaCreateDate, which is in UTC. Documents use the creation date of the
+// PDF Info dictionary, which exiftool reports as CreateDate, or of their XMP.
+// Filesystem dates come from FileModifyDate, which carries the offset of the
+// machine exiftool runs on.
+var DefaultDateTags = DateTags{
+	Image:    []string{"DateTimeOriginal", "CreateDate", "DateCreated", "ModifyDate", "SubSecCreateDate", "SonyDateTime", "TimeStamp"},
+	Video:    []string{"CreationDate", "ContentCreateDate", "DateTimeOriginal", "MediaCreateDate", "CreateDate", "TrackCreateDate"},
+	Document: []string{"CreateDate", "CreationDate"},
+	File:     []string{"FileModifyDate"},
+}
+
+// AutoDateTags ranks every date-bearing tag exiftool reports for the formats
+// it reads, from the capture date down to dates that may only record an
+// edit. With Options.AutoDateTags the first one present is used for any kind
+// of file, so unusual formats need no tags configured.
+var AutoDateTags = []string{
+	"SubSecDateTimeOriginal", "DateTimeOriginal", "CreationDate", "ContentCreateDate", "DateCreated",
+	"SubSecCreateDate", "CreateDate", "MediaCreateDate", "TrackCreateDate",
+	"CreationTime", "DateTimeCreated", "SonyDateTime", "TimeStamp", "ModifyDate",
+}
+
+// tagAliases maps the EXIF names of date tags to the names exiftool reports
+// them under, so either name can be configured. DateTimeOriginal is when the
+// photo was taken, while DateTimeDigitized is when it was stored digitally:
+// the same moment for digital cameras, but the scan date for scanned film,
+// whose shot date only scanning software fills into DateTimeOriginal.
+var tagAliases = map[string]string{
+	"DateTimeDigitized": "CreateDate",
+}
+
+// Kinds of media, which decide the tags a date is read from and written to.
+const (
+	kindImage    = "image"
+	kindVideo    = "video"
+	kindDocument = "document"
+)
+
+// mediaExtensions maps the lowercase extensions of supported files to their
+// kind of media.
+var mediaExtensions = map[string]string{
+	".jpg":  kindImage,
+	".jpeg": kindImage,
+	".mp4":  kindVideo,
+	".mov":  kindVideo,
+	".png":  kindImage,
+	".webp": kindImage,
+	".avi":  kindVideo,
+	".mpg":  kindVideo,
+	".mpeg": kindVideo,
+	".heic": kindImage,
+	".heif": kindImage,
+	".pdf":  kindDocument,
+}
+
+// mediaKind returns the kind of media of a file, or an empty string for
+// files that are not supported photos or videos.
+func mediaKind(path string) string {
+	return mediaExtensions[fileExt(path)]
+}
+
+// mediaKind returns the kind of media of the file at path that is sorted as
+// such, leaving documents out unless they are included.
+func (opts Options) mediaKind(path string) string {
+	kind := mediaKind(path)
+	if kind == kindDocument && !opts.IncludeDocuments {
+		return ""
+	}
+	return kind
+}
+
+// fileExt returns the extension of path in lowercase, with its dot. Only the
+// last extension counts, so photo.JPG.mov is a video and video.final.MP4 has
+// the extension .mp4. Extensions are always matched through it, never by
+// checking suffixes of the name.
+func fileExt(path string) string {
+	return strings.ToLower(filepath.Ext(path))
+}
+
+// exifDateLayouts are the layouts exiftool reports dates in, with and
+// without sub-seconds and time zone.
+var exifDateLayouts = []string{
+	"2006:01:02 15:04:05",
+	"2006:01:02 15:04:05Z07:00",
+	"2006:01:02 15:04:05.999999999",
+	"2006:01:02 15:04:05.999999999Z07:00",
+}
+
+// parseInZone parses a date and converts it to loc. Dates carrying an offset
+// are converted from it, and dates without one are taken as wall clock time
+// in loc. A nil loc leaves dates as parsed.
+func parseInZone(layout, value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		return time.Parse(layout, value)
+	}
+	date, err := time.ParseInLocation(layout, value, loc)
+	return date.In(loc), err
+}
+
+// parseExifDate parses a date as reported by exiftool, converted to loc.
+func parseExifDate(value string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range exifDateLayouts {
+		date, err := parseInZone(layout, value, loc)
+		if err == nil && !date.IsZero() {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// iptcDateTime completes an IPTC DateCreated value, which holds only the
+// date, with the separate TimeCreated field. XMP dates that already carry a
+// time are returned unchanged.
+func iptcDateTime(fileInfo exiftool.FileMetadata, date string) string {
+	if len(date) != len("2006:01:02") {
+		return date
+	}
+	clock, err := fileInfo.GetString("TimeCreated")
+	if err != nil {
+		clock = "00:00:00"
+	}
+	return date + " " + clock
+}
+
+// firstTagDate returns the first date found in tags, in order.
+func firstTagDate(fileInfo exiftool.FileMetadata, tags []string, loc *time.Location) (time.Time, bool) {
+	for _, tag := range tags {
+		name := tag
+		if alias, ok := tagAliases[tag]; ok {
+			name = alias
+		}
+		value, err := fileInfo.GetString(name)
+		if err != nil {
+			continue
+		}
+		if tag == "DateCreated" {
+			value = iptcDateTime(fileInfo, value)
+		}
+		if date, ok := parseExifDate(value, loc); ok {
+			log.Debugf("Using %v for the date of %v", tag, fileInfo.File)
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// gpsDate returns the UTC time of the GPS fix of a file, from its
+// GPSDateStamp and GPSTimeStamp.
+func gpsDate(fileInfo exiftool.FileMetadata) (time.Time, bool) {
+	date, err := fileInfo.GetString("GPSDateStamp")
+	if err != nil {
+		return time.Time{}, false
+	}
+	clock, err := fileInfo.GetString("GPSTimeStamp")
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parseExifDate(date+" "+clock, time.UTC)
+}
+
+// FilenamePattern extracts a date from a file name: the first submatch of
+// Regexp is parsed with Layout.
+type FilenamePattern struct {
+	Regexp *regexp.Regexp
+	Layout string
+}
+
+// DefaultFilenamePatterns are tried in order, the more specific first, and
+// the first pattern matching a name wins. Each pattern anchors its token
+// between non-digits so that a longer run of digits is never misread as a
+// shorter date. Only year first dates are recognized, see
+// FilenamePatternsFor for day and month first ones.
+var DefaultFilenamePatterns = []FilenamePattern{
+	// Phone bursts and Pixel shots, such as 00001IMG_00001_BURST20230501120000.jpg
+	// and PXL_20230501_120000123.jpg
+	{regexp.MustCompile(`BURST(\d{14})(?:\D|$)`), "20060102150405"},
+	{regexp.MustCompile(`PXL_(\d{8}_\d{6})\d{3}(?:\D|$)`), "20060102_150405"},
+	// Date and time, such as PANO_20230501_120000.jpg, 20230501-120000.mp4 or
+	// 2023-05-01 12.00.00.jpg
+	{regexp.MustCompile(`(?:^|\D)(\d{8}_\d{6})(?:\D|$)`), "20060102_150405"},
+	{regexp.MustCompile(`(?:^|\D)(\d{8}-\d{6})(?:\D|$)`), "20060102-150405"},
+	{regexp.MustCompile(`(?:^|\D)(\d{4}-\d{2}-\d{2} \d{2}\.\d{2}\.\d{2})(?:\D|$)`), "2006-01-02 15.04.05"},
+	{regexp.MustCompile(`(?:^|\D)(\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2})(?:\D|$)`), "2006-01-02_15-04-05"},
+	// Separated dates, such as 2023-05-01.jpg or 2023.05.01.jpg
+	{regexp.MustCompile(`(?:^|\D)(\d{4}-\d{2}-\d{2})(?:\D|$)`), "2006-01-02"},
+	{regexp.MustCompile(`(?:^|\D)(\d{4}\.\d{2}\.\d{2})(?:\D|$)`), "2006.01.02"},
+	// Spelled out months, such as May 1 2023.jpg or 1 May 2023.jpg
+	{regexp.MustCompile(`(?i)(?:^|[^a-z])((?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec) \d{1,2} \d{4})(?:\D|$)`), "Jan 2 2006"},
+	{regexp.MustCompile(`(?i)(?:^|\D)(\d{1,2} (?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec) \d{4})(?:\D|$)`), "2 Jan 2006"},
+	{regexp.MustCompile(`(?i)(?:^|[^a-z])((?:january|february|march|april|june|july|august|september|october|november|december) \d{1,2} \d{4})(?:\D|$)`), "January 2 2006"},
+	{regexp.MustCompile(`(?i)(?:^|\D)(\d{1,2} (?:january|february|march|april|june|july|august|september|october|november|december) \d{4})(?:\D|$)`), "2 January 2006"},
+	// Date only, such as VID_20230501.mp4
+	{regexp.MustCompile(`(?:^|\D)(\d{8})(?:\D|$)`), "20060102"},
+}
+
+// Orders of the day and month in dates such as 01-05-2023, which cannot be
+// told apart from the date itself.
+const (
+	DateOrderYMD = "ymd"
+	DateOrderDMY = "dmy"
+	DateOrderMDY = "mdy"
+)
+
+// FilenamePatternsFor returns DefaultFilenamePatterns followed by the
+// patterns for year last dates, such as 01-05-2023 or 01.05.2023, read in
+// the given order. The ymd order recognizes no year last dates.
+func FilenamePatternsFor(order string) ([]FilenamePattern, error) {
+	var layout string
+	switch order {
+	case DateOrderYMD:
+		return DefaultFilenamePatterns, nil
+	case DateOrderDMY:
+		layout = "02-01-2006"
+	case DateOrderMDY:
+		layout = "01-02-2006"
+	default:
+		return nil, errors.Errorf("unknown date order %q", order)
+	}
+	patterns := append([]FilenamePattern(nil), DefaultFilenamePatterns...)
+	return append(patterns,
+		FilenamePattern{regexp.MustCompile(`(?:^|\D)(\d{2}-\d{2}-\d{4})(?:\D|$)`), layout},
+		FilenamePattern{regexp.MustCompile(`(?:^|\D)(\d{2}\.\d{2}\.\d{4})(?:\D|$)`), strings.ReplaceAll(layout, "-", ".")},
+	), nil
+}
+
+// filenameDate returns the date matched by the first matching pattern in the
+// base name of path, taken as wall clock time in loc as dates without an
+// offset are.
+func filenameDate(path string, patterns []FilenamePattern, loc *time.Location) (time.Time, bool) {
+	name := filepath.Base(path)
+	for _, pattern := range patterns {
+		matches := pattern.Regexp.FindStringSubmatch(name)
+		if len(matches) < 2 {
+			continue
+		}
+		date, err := parseInZone(pattern.Layout, matches[1], loc)
+		if err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ErrNoDate is returned for files with neither an EXIF nor a file name date.
+var ErrNoDate = errors.New("unable to extract date from EXIF data or filename")
+
+// monthNames maps English month names and their common abbreviations to
+// months, for dates read from directory names.
+var monthNames = map[string]time.Month{}
+
+func init() {
+	for m := time.January; m <= time.December; m++ {
+		name := strings.ToLower(m.String())
+		monthNames[name] = m
+		monthNames[name[:3]] = m
+	}
+	monthNames["sept"] = time.September
+}
+
+// pathDate returns the date matched by pattern in a directory path, relative
+// to the source root and with slash separators. The pattern names its groups
+// year, month and day. Month may be a number or an English month name, and
+// month and day default to the first when the pattern has no such group.
+func pathDate(dir string, pattern *regexp.Regexp, loc *time.Location) (time.Time, bool) {
+	matches := pattern.FindStringSubmatch(filepath.ToSlash(dir))
+	if matches == nil {
+		return time.Time{}, false
+	}
+	year, month, day := 0, time.January, 1
+	for i, name := range pattern.SubexpNames() {
+		value := strings.ToLower(matches[i])
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		switch {
+		case name == "year" && err == nil:
+			year = n
+		case name == "month" && err == nil:
+			month = time.Month(n)
+		case name == "month":
+			m, ok := monthNames[value]
+			if !ok {
+				return time.Time{}, false
+			}
+			month = m
+		case name == "day" && err == nil:
+			day = n
+		}
+	}
+	if year == 0 || month < time.January || month > time.December || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc), true
+}
+
+// Which date to trust when EXIF data and the file name both yield one.
+const (
+	PreferExif     = "exif"
+	PreferFilename = "filename"
+	PreferOldest   = "oldest"
+)
+
+// datePreference returns the date preference for a kind of media.
+func (opts Options) datePreference(kind string) string {
+	switch {
+	case kind == kindImage && opts.ImageDatePrefer != "":
+		return opts.ImageDatePrefer
+	case kind == kindVideo && opts.VideoDatePrefer != "":
+		return opts.VideoDatePrefer
+	}
+	return opts.DatePrefer
+}
+
+// extractDate sets the date of a file and where it came from, along with the
+// metadata fields exiftool reported for it and every candidate date found.
+func extractDate(et Extractor, file *mediaFile, opts Options) error {
+	path := file.path
+
+	// Extract date from EXIF data
+	fileInfos := et.ExtractMetadata(path)
+	if len(fileInfos) == 0 {
+		return errors.Errorf("no metadata extracted from %q", path)
+	}
+
+	for _, fileInfo := range fileInfos {
+		if fileInfo.Err != nil {
+			log.Errorf("Error concerning %v: %v", fileInfo.File, fileInfo.Err)
+			continue
+		}
+
+		for k, v := range fileInfo.Fields {
+			log.Debugf("[%v] %v", k, v)
+		}
+	}
+
+	var exifDate time.Time
+	var exifOK bool
+	switch kind := mediaKind(path); {
+	case opts.AutoDateTags && kind != "":
+		exifDate, exifOK = firstTagDate(fileInfos[0], AutoDateTags, opts.DisplayZone)
+	case kind == kindVideo:
+		exifDate, exifOK = firstTagDate(fileInfos[0], opts.Tags.Video, opts.DisplayZone)
+	case kind == kindImage:
+		exifDate, exifOK = firstTagDate(fileInfos[0], opts.Tags.Image, opts.DisplayZone)
+	case kind == kindDocument:
+		exifDate, exifOK = firstTagDate(fileInfos[0], opts.Tags.Document, opts.DisplayZone)
+	}
+	file.fields = fileInfos[0].Fields
+	file.screenshot = isScreenshot(path, file.fields, opts.ScreenshotPattern)
+	exifSource := SourceExif
+
+	// Read the date of videos without one from their thumbnail sidecar
+	if !exifOK && mediaKind(path) == kindVideo {
+		exifDate, exifOK = sidecarDate(et, path, opts)
+	}
+
+	// Check the capture date against the GPS time, which is always UTC
+	if gps, ok := gpsDate(fileInfos[0]); ok && exifOK {
+		diff := exifDate.Sub(gps)
+		if diff < 0 {
+			diff = -diff
+		}
+		if opts.GPSDisagreement > 0 && diff > opts.GPSDisagreement {
+			log.Warn("EXIF and GPS dates disagree", "src", path, "exif", exifDate, "gps", gps, "prefer_gps", opts.PreferGPSTime)
+		}
+		if opts.PreferGPSTime {
+			loc := exifDate.Location()
+			if opts.DisplayZone != nil {
+				loc = opts.DisplayZone
+			}
+			exifDate, exifSource = gps.In(loc), SourceGPS
+		}
+	}
+	if exifOK {
+		file.exifDate = exifDate
+	}
+
+	// Extract date from filename
+	nameDate, nameOK := filenameDate(path, opts.FilenamePatterns, opts.DisplayZone)
+	if nameOK {
+		file.nameDate = nameDate
+	}
+
+	// Trust a date in the directory path over all others
+	if opts.PathPattern != nil {
+		if date, ok := pathDate(file.srcFolder, opts.PathPattern, opts.DisplayZone); ok {
+			file.date, file.source = date, SourcePath
+			return nil
+		}
+	}
+
+	// Date files without a date of their own by their parent directory
+	var parentDate time.Time
+	var parentOK bool
+	if opts.ParentDateFallback {
+		parentDate, parentOK = filenameDate(filepath.Dir(path), opts.FilenamePatterns, opts.DisplayZone)
+	}
+
+	// Date frames without a date of their own by their session folder
+	var session time.Time
+	var sessionOK bool
+	if opts.SessionPattern != nil {
+		session, sessionOK = sessionDate(path, opts.SessionPattern, opts.DisplayZone)
+	}
+
+	switch {
+	case exifOK && nameOK:
+		// Flag dates that disagree, which hints at a wrong clock or a renamed file
+		diff := exifDate.Sub(nameDate)
+		if diff < 0 {
+			diff = -diff
+		}
+		prefer := opts.datePreference(mediaKind(path))
+		if opts.DateDisagreement > 0 && diff > opts.DateDisagreement {
+			log.Warn("EXIF and filename dates disagree", "src", path, "exif", exifDate, "filename", nameDate, "prefer", prefer)
+		}
+		if prefer == PreferFilename || prefer == PreferOldest && nameDate.Before(exifDate) {
+			file.date, file.source = nameDate, SourceFilename
+		} else {
+			file.date, file.source = exifDate, exifSource
+		}
+	case exifOK:
+		file.date, file.source = exifDate, exifSource
+	case nameOK:
+		file.date, file.source = nameDate, SourceFilename
+	case parentOK:
+		log.Debug("Using parent directory date", "src", path)
+		file.date, file.source = parentDate, SourcePath
+	case opts.SessionPattern != nil && sessionOK:
+		file.date, file.source = session, SourceSession
+	case opts.MtimeFallback || mediaKind(path) == kindDocument:
+		// Fall back to the filesystem dates as a last resort, which
+		// documents always do since scans rarely carry a date. They are
+		// parsed from exiftool like every other date when it reports them
+		log.Debug("Using modification time as date", "src", path)
+		file.date, file.source = file.modTime, SourceMtime
+		if date, ok := firstTagDate(fileInfos[0], opts.Tags.File, opts.DisplayZone); ok {
+			file.date = date
+		} else if opts.DisplayZone != nil {
+			file.date = file.date.In(opts.DisplayZone)
+		}
+	default:
+		return errors.WithStack(ErrNoDate)
+	}
+	return nil
+}
+
+// DefaultDateSidecars are the extensions of the sidecars dating videos
+// without a date of their own, such as the .THM thumbnails of camcorders.
+var DefaultDateSidecars = []string{"thm"}
+
+// sidecarDate returns the date of the first sidecar of the video at path with
+// one of the DateSidecars extensions, in either case, read with the image
+// date tags.
+func sidecarDate(et Extractor, path string, opts Options) (time.Time, bool) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range opts.DateSidecars {
+		ext = strings.TrimPrefix(ext, ".")
+		for _, sidecar := range []string{base + "." + strings.ToLower(ext), base + "." + strings.ToUpper(ext)} {
+			if !fileExists(sidecar) {
+				continue
+			}
+			fileInfos := et.ExtractMetadata(sidecar)
+			if len(fileInfos) == 0 || fileInfos[0].Err != nil {
+				continue
+			}
+			if date, ok := firstTagDate(fileInfos[0], opts.Tags.Image, opts.DisplayZone); ok {
+				log.Debug("Using sidecar date", "src", path, "sidecar", sidecar)
+				return date, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// serialTags are the tags holding the serial number of the camera body, in
+// order of preference.
+var serialTags = []string{"SerialNumber", "BodySerialNumber", "InternalSerialNumber"}
+
+// UnknownBody is the {serial} of files without a camera serial number.
+const UnknownBody = "UnknownBody"
+
+// cameraSerial returns the serial number of the camera body a file was shot
+// with, replaced by its friendly name in names when it has one.
+func cameraSerial(fields map[string]interface{}, names map[string]string) string {
+	for _, tag := range serialTags {
+		value, ok := fields[tag]
+		if !ok {
+			continue
+		}
+		serial := fmt.Sprint(value)
+		if number, ok := value.(float64); ok {
+			// exiftool reports numeric serials as JSON numbers
+			serial = strconv.FormatFloat(number, 'f', -1, 64)
+		}
+		serial = strings.TrimSpace(serial)
+		if serial == "" {
+			continue
+		}
+		if name, ok := names[serial]; ok {
+			return name
+		}
+		return serial
+	}
+	return UnknownBody
+}
+
+// UnknownModel is the {model} of files without a camera make or model.
+const UnknownModel = "UnknownModel"
+
+// cameraModel returns the make and model of the camera a file was shot with,
+// as in Canon EOS 5D Mark IV. Models that already start with the first word
+// of the make, as most do, do not repeat it.
+func cameraModel(fields map[string]interface{}) string {
+	maker, _ := fields["Make"].(string)
+	model, _ := fields["Model"].(string)
+	maker, model = strings.TrimSpace(maker), strings.TrimSpace(model)
+	switch {
+	case maker == "" && model == "":
+		return UnknownModel
+	case maker == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(strings.Fields(maker)[0])):
+		return sanitizeName(model)
+	case model == "":
+		return sanitizeName(maker)
+	}
+	return sanitizeName(maker + " " + model)
+}
+
+// keywordTags are the tags holding the keywords of a file, in order of
+// preference.
+var keywordTags = []string{"Subject", "Keywords", "HierarchicalSubject"}
+
+// primaryKeyword returns the first keyword of a file, or fallback when it has
+// none. Hierarchical keywords such as Places|Paris use their last level.
+func primaryKeyword(fields map[string]interface{}, fallback string) string {
+	for _, tag := range keywordTags {
+		value := fields[tag]
+		if list, ok := value.([]interface{}); ok && len(list) > 0 {
+			value = list[0]
+		}
+		keyword, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if i := strings.LastIndex(keyword, "|"); i >= 0 {
+			keyword = keyword[i+1:]
+		}
+		if keyword = sanitizeName(strings.TrimSpace(keyword)); keyword != "" {
+			return keyword
+		}
+	}
+	return fallback
+}
+
+// extensionAliases maps extensions to the one exiftool reports for the same
+// file type.
+var extensionAliases = map[string]string{
+	".jpeg": ".jpg",
+	".tiff": ".tif",
+	".m4v":  ".mp4",
+	".qt":   ".mov",
+}
+
+// contentExt returns the extension matching the content of a file, as
+// detected by exiftool, in lowercase with its dot, or an empty string when
+// unknown.
+func contentExt(fields map[string]interface{}) string {
+	ext, ok := fields["FileTypeExtension"].(string)
+	if !ok || ext == "" {
+		return ""
+	}
+	return "." + strings.ToLower(ext)
+}
+
+// sameExt reports whether two extensions name the same file type.
+func sameExt(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if alias, ok := extensionAliases[a]; ok {
+		a = alias
+	}
+	if alias, ok := extensionAliases[b]; ok {
+		b = alias
+	}
+	return a == b
+}
+
+// countryTags are the tags holding the country a file was taken in, as
+// filled in by cameras or by reverse geocoding in photo managers.
+var countryTags = []string{"Country", "Country-PrimaryLocationName", "LocationShownCountryName", "LocationCreatedCountryName"}
+
+// UnknownCountry is the {country} of files without a country.
+const UnknownCountry = "UnknownCountry"
+
+// country returns the country a file was taken in, safe for use as a folder
+// name.
+func country(fields map[string]interface{}) string {
+	for _, tag := range countryTags {
+		value := fields[tag]
+		if list, ok := value.([]interface{}); ok && len(list) > 0 {
+			value = list[0]
+		}
+		if name, ok := value.(string); ok {
+			if name = sanitizeName(strings.TrimSpace(name)); name != "" {
+				return name
+			}
+		}
+	}
+	return UnknownCountry
+}
+
+// Orientations a file can be bucketed into.
+const (
+	OrientationPortrait  = "Portrait"
+	OrientationLandscape = "Landscape"
+	OrientationSquare    = "Square"
+	OrientationUnknown   = "Unknown"
+)
+
+// orientation returns how a file is displayed, from its dimensions and its
+// EXIF Orientation, which swaps them for images rotated by 90 or 270 degrees.
+func orientation(fields map[string]interface{}) string {
+	width, height, ok := dimensions(fields)
+	if !ok {
+		return OrientationUnknown
+	}
+	if quarterTurn(fields["Orientation"]) {
+		width, height = height, width
+	}
+	switch {
+	case width > height:
+		return OrientationLandscape
+	case width < height:
+		return OrientationPortrait
+	}
+	return OrientationSquare
+}
+
+// quarterTurn reports whether an EXIF Orientation rotates an image by 90 or
+// 270 degrees, either as exiftool describes it, such as "Rotate 270 CW", or
+// as its numeric value, 5 to 8.
+func quarterTurn(rotation interface{}) bool {
+	switch rotation := rotation.(type) {
+	case string:
+		return strings.Contains(rotation, "90") || strings.Contains(rotation, "270")
+	case float64:
+		return rotation >= 5 && rotation <= 8
+	}
+	return false
+}
+
+// initialExiftoolBuffer is the size exiftool's output buffer starts at when
+// its maximum size is configured, growing up to that maximum as needed.
+const initialExiftoolBuffer = 64 * 1024
+
+// newExiftool starts an exiftool process, running the binary configured in
+// opts when set. The process runs with -stay_open, so that it is started once
+// and then serves every extraction of its caller until closed.
+func newExiftool(opts Options) (*exiftool.Exiftool, error) {
+	var options []func(*exiftool.Exiftool) error
+	if opts.ExiftoolPath != "" {
+		options = append(options, exiftool.SetExiftoolBinaryPath(opts.ExiftoolPath))
+	}
+	for _, charset := range opts.Charsets {
+		options = append(options, exiftool.Charset(charset))
+	}
+	if opts.ExiftoolBuffer > 0 {
+		size := initialExiftoolBuffer
+		if opts.ExiftoolBuffer < size {
+			size = opts.ExiftoolBuffer
+		}
+		options = append(options, exiftool.Buffer(make([]byte, size), opts.ExiftoolBuffer))
+	}
+	if opts.ExifBackups || opts.ExifBackupDir != "" {
+		options = append(options, exiftool.BackupOriginal())
+	}
+	return exiftool.NewExiftool(options...)
+}
+
+// checkExecutable returns an error unless path is an executable file.
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "exiftool binary %q", path)
+	}
+	if info.IsDir() {
+		return errors.Errorf("exiftool binary %q is a directory", path)
+	}
+	if runtime.GOOS != "windows" && info.Mode()&0o111 == 0 {
+		return errors.Errorf("exiftool binary %q is not executable", path)
+	}
+	return nil
+}
+
+// exiftoolBinary returns the exiftool binary to run.
+func exiftoolBinary(opts Options) string {
+	if opts.ExiftoolPath != "" {
+		return opts.ExiftoolPath
+	}
+	return "exiftool"
+}
+
+// checkExiftoolVersion returns an error when the exiftool run is older than
+// opts.MinExiftoolVersion.
+func checkExiftoolVersion(opts Options) error {
+	if _, err := parseVersion(opts.MinExiftoolVersion); err != nil {
+		return errors.Errorf("invalid minimum exiftool version %q", opts.MinExiftoolVersion)
+	}
+	version, err := exiftoolVersion(exiftoolBinary(opts))
+	if err != nil {
+		return err
+	}
+	older, err := olderVersion(version, opts.MinExiftoolVersion)
+	if err != nil {
+		return errors.Errorf("unexpected exiftool version %q", version)
+	}
+	if older {
+		return errors.Errorf("exiftool %s is older than the minimum version %s", version, opts.MinExiftoolVersion)
+	}
+	return nil
+}
+
+// exiftoolVersions caches the version of every exiftool binary run, since
+// options are validated again by every entry point.
+var exiftoolVersions = struct {
+	sync.Mutex
+	versions map[string]string
+}{versions: make(map[string]string)}
+
+// exiftoolVersion returns the version reported by exiftool -ver for binary,
+// running it only the first time.
+func exiftoolVersion(binary string) (string, error) {
+	exiftoolVersions.Lock()
+	defer exiftoolVersions.Unlock()
+	if version, ok := exiftoolVersions.versions[binary]; ok {
+		return version, nil
+	}
+	out, err := exec.Command(binary, "-ver").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "reading the exiftool version")
+	}
+	version := strings.TrimSpace(string(out))
+	exiftoolVersions.versions[binary] = version
+	return version, nil
+}
+
+// olderVersion reports whether version is older than minimum. Versions are
+// compared part by part as integers, so 12.10 is newer than 12.9, and missing
+// parts count as zero.
+func olderVersion(version, minimum string) (bool, error) {
+	current, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	least, err := parseVersion(minimum)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < len(current) || i < len(least); i++ {
+		var a, b int
+		if i < len(current) {
+			a = current[i]
+		}
+		if i < len(least) {
+			b = least[i]
+		}
+		if a != b {
+			return a < b, nil
+		}
+	}
+	return false, nil
+}
+
+// parseVersion splits a dotted version such as 12.40 into its numbers.
+func parseVersion(version string) ([]int, error) {
+	var parts []int
+	for _, part := range strings.Split(strings.TrimSpace(version), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// dateWriteTags returns the tags the date of the file at path is written to,
+// by its kind of media, along with the tag its previous date is read from.
+// Files of other kinds have no date written.
+func dateWriteTags(path string) (string, []string) {
+	switch mediaKind(path) {
+	case kindVideo:
+		return "MediaCreateDate", []string{"MediaCreateDate", "CreateDate"}
+	case kindImage:
+		return "DateTaken", []string{"DateTimeOriginal", "CreateDate"}
+	}
+	return "", nil
+}
+
+// updateExif rewrites the dates of the sorted file at path, along with the
+// tags to keep, and moves exiftool's backup of it to the backup directory. It
+// logs to logger.
+func updateExif(path string, date time.Time, opts Options, logger *log.Logger) error {
+	e, err := newExiftool(opts)
+	if err != nil {
+		logger.Errorf("Error when intializing: %v", err)
+		return err
+	}
+	defer e.Close()
+
+	fileInfos := e.ExtractMetadata(path)
+	if len(fileInfos) == 0 {
+		return errors.Errorf("reading EXIF data of %q: no metadata", path)
+	}
+	if fileInfos[0].Err != nil {
+		return errors.Wrapf(fileInfos[0].Err, "reading EXIF data of %q", path)
+	}
+
+	if previous, tags := dateWriteTags(path); len(tags) > 0 {
+		dateStr, _ := fileInfos[0].GetString(previous)
+		logger.Infof("Date Original %v changed to %v", dateStr, date.Format("2006-01-02 15:04:05"))
+	}
+	written := []exiftool.FileMetadata{rewrittenMetadata(fileInfos[0], date, opts)}
+	e.WriteMetadata(written)
+	if written[0].Err != nil {
+		return errors.Wrapf(written[0].Err, "writing EXIF data of %q", path)
+	}
+	return moveExifBackup(path, opts, logger)
+}
+
+// exifBackupSuffix is appended by exiftool to the name of its backups.
+const exifBackupSuffix = "_original"
+
+// moveExifBackup moves the backup exiftool made of path to the backup
+// directory, when there is one. exiftool makes no backup of files it left
+// unchanged.
+func moveExifBackup(path string, opts Options, logger *log.Logger) error {
+	if opts.ExifBackupDir == "" || !fileExists(path+exifBackupSuffix) {
+		return nil
+	}
+	rel, err := filepath.Rel(opts.Dest, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	backup := filepath.Join(opts.ExifBackupDir, rel) + exifBackupSuffix
+	if err := ensureDir(osFS, backup); err != nil {
+		return err
+	}
+	return renameFile(osFS, path+exifBackupSuffix, backup, opts.CopyBuffer, logger)
+}
+
+// rewrittenMetadata returns the metadata a rewrite of a file writes: the tags
+// kept from what was read, and the date in the tags it is written to.
+func rewrittenMetadata(read exiftool.FileMetadata, date time.Time, opts Options) exiftool.FileMetadata {
+	written := exiftool.FileMetadata{File: read.File, Fields: keptTags(read.Fields, opts)}
+	_, tags := dateWriteTags(read.File)
+	for _, tag := range tags {
+		written.SetString(tag, date.Format("2006-01-02 15:04:05"))
+	}
+	return written
+}
+
+// keptTags returns the tags of fields that a rewrite of the file writes back
+// explicitly: all of them, unless opts.KeepTags narrows them down to its own
+// and opts.KeepAllTags is not set.
+func keptTags(fields map[string]interface{}, opts Options) map[string]interface{} {
+	kept := make(map[string]interface{})
+	if len(opts.KeepTags) == 0 || opts.KeepAllTags {
+		for tag, value := range fields {
+			kept[tag] = value
+		}
+		return kept
+	}
+	for _, tag := range opts.KeepTags {
+		if value, ok := fields[tag]; ok {
+			kept[tag] = value
+		}
+	}
+	return kept
+}
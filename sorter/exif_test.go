@@ -0,0 +1,1171 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+func TestOrientation(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   string
+	}{
+		{"landscape", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0}, OrientationLandscape},
+		{"portrait", map[string]interface{}{"ImageWidth": 3000.0, "ImageHeight": 4000.0}, OrientationPortrait},
+		{"square", map[string]interface{}{"ImageWidth": 3000.0, "ImageHeight": 3000.0}, OrientationSquare},
+		{"unknown", map[string]interface{}{}, OrientationUnknown},
+		{"horizontal", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0, "Orientation": "Horizontal (normal)"}, OrientationLandscape},
+		{"rotate 90", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0, "Orientation": "Rotate 90 CW"}, OrientationPortrait},
+		{"rotate 270", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0, "Orientation": "Rotate 270 CW"}, OrientationPortrait},
+		{"mirror rotate 270", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0, "Orientation": "Mirror horizontal and rotate 270 CW"}, OrientationPortrait},
+		{"rotate 180", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0, "Orientation": "Rotate 180"}, OrientationLandscape},
+		{"numeric 8", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0, "Orientation": 8.0}, OrientationPortrait},
+		{"numeric 3", map[string]interface{}{"ImageWidth": 4000.0, "ImageHeight": 3000.0, "Orientation": 3.0}, OrientationLandscape},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orientation(tt.fields); got != tt.want {
+				t.Errorf("orientation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilenameDateInZone(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	tests := []struct {
+		name string
+		loc  *time.Location
+		want time.Time
+	}{
+		{"as parsed", nil, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{"in zone", paris, time.Date(2023, 5, 1, 12, 0, 0, 0, paris)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := filenameDate("src/PANO_20230501_120000.jpg", DefaultFilenamePatterns, tt.loc)
+			if !ok {
+				t.Fatal("filenameDate() found no date")
+			}
+			if !got.Equal(tt.want) || got.Location() != tt.want.Location() {
+				t.Errorf("filenameDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileExt(t *testing.T) {
+	tests := []struct {
+		path string
+		ext  string
+		kind string
+	}{
+		{"IMG_0001.jpg", ".jpg", kindImage},
+		{"IMG_0001.JPG", ".jpg", kindImage},
+		{"a.b.JPG", ".jpg", kindImage},
+		{"photo.JPG.jpg", ".jpg", kindImage},
+		{"photo.JPG.mov", ".mov", kindVideo},
+		{"video.final.MP4", ".mp4", kindVideo},
+		{"x.tar.gz", ".gz", ""},
+		{"jpg", "", ""},
+		{"notes.jpg.txt", ".txt", ""},
+		{".hidden", ".hidden", ""},
+		{"dir.jpg/README", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := fileExt(tt.path); got != tt.ext {
+				t.Errorf("fileExt(%q) = %q, want %q", tt.path, got, tt.ext)
+			}
+			if got := mediaKind(tt.path); got != tt.kind {
+				t.Errorf("mediaKind(%q) = %q, want %q", tt.path, got, tt.kind)
+			}
+		})
+	}
+}
+
+// fakeExtractor reports canned metadata fields for every file.
+type fakeExtractor map[string]map[string]interface{}
+
+func (e fakeExtractor) ExtractMetadata(files ...string) []exiftool.FileMetadata {
+	var fileInfos []exiftool.FileMetadata
+	for _, file := range files {
+		fileInfos = append(fileInfos, exiftool.FileMetadata{File: file, Fields: e[file]})
+	}
+	return fileInfos
+}
+
+func TestExtractDateAndroidVideo(t *testing.T) {
+	want := time.Date(2023, 5, 1, 14, 0, 0, 0, time.FixedZone("", 2*60*60))
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+	}{
+		{"samsung", map[string]interface{}{
+			"MediaCreateDate":   "2023:05:01 12:00:00",
+			"TrackCreateDate":   "2023:05:01 12:00:00",
+			"ContentCreateDate": "2023:05:01 14:00:00+02:00",
+		}},
+		{"android zero media date", map[string]interface{}{
+			"MediaCreateDate": "0000:00:00 00:00:00",
+			"CreateDate":      "0000:00:00 00:00:00",
+			"CreationDate":    "2023:05:01 14:00:00+02:00",
+		}},
+		{"android utc media date", map[string]interface{}{
+			"MediaCreateDate":  "2023:05:01 12:00:00",
+			"DateTimeOriginal": "2023:05:01 14:00:00+02:00",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "DCIM/Camera/clip.mp4"
+			file := mediaFile{path: path}
+			opts := Options{Tags: DefaultDateTags}
+			if err := extractDate(fakeExtractor{path: tt.fields}, &file, opts); err != nil {
+				t.Fatalf("extractDate() error = %v", err)
+			}
+			if !file.date.Equal(want) || file.date.Hour() != want.Hour() {
+				t.Errorf("date = %v, want %v", file.date, want)
+			}
+			if file.source != SourceExif {
+				t.Errorf("source = %q, want %q", file.source, SourceExif)
+			}
+		})
+	}
+}
+
+func TestExtractDateDocument(t *testing.T) {
+	path := "scans/invoice.pdf"
+	if kind := (Options{}).mediaKind(path); kind != "" {
+		t.Errorf("mediaKind(%q) = %q without -include-documents, want none", path, kind)
+	}
+	if kind := (Options{IncludeDocuments: true}).mediaKind(path); kind != kindDocument {
+		t.Errorf("mediaKind(%q) = %q, want %q", path, kind, kindDocument)
+	}
+
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   time.Time
+		source DateSource
+	}{
+		{"create date", map[string]interface{}{"CreateDate": "2023:05:01 14:00:00+02:00", "CreationDate": "2022:01:01 00:00:00+00:00"}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), SourceExif},
+		{"creation date", map[string]interface{}{"CreationDate": "2023:05:01 14:00:00+02:00"}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), SourceExif},
+		{"modification time", nil, modTime, SourceMtime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := mediaFile{path: path, modTime: modTime}
+			opts := Options{IncludeDocuments: true, Tags: DefaultDateTags, FilenamePatterns: DefaultFilenamePatterns}
+			if err := extractDate(fakeExtractor{path: tt.fields}, &file, opts); err != nil {
+				t.Fatalf("extractDate() error = %v", err)
+			}
+			if !file.date.Equal(tt.want) || file.source != tt.source {
+				t.Errorf("date = %v from %q, want %v from %q", file.date, file.source, tt.want, tt.source)
+			}
+		})
+	}
+}
+
+func TestDateWriteTags(t *testing.T) {
+	image := []string{"DateTimeOriginal", "CreateDate"}
+	video := []string{"MediaCreateDate", "CreateDate"}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"2023/05/01/IMG_0001.jpeg", image},
+		{"2023/05/01/IMG_0001.JPEG", image},
+		{"2023/05/01/IMG_0001.jpg", image},
+		{"2023/05/01/IMG_0001.heic", image},
+		{"2023/05/01/VID_0001.mp4", video},
+		{"2023/05/01/IMG_0001.jepg", nil},
+		{"2023/05/01/notes.txt", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, got := dateWriteTags(tt.path)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("dateWriteTags(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilenameDate(t *testing.T) {
+	day := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	at := func(year int, month time.Month, d, hour, min, sec int) time.Time {
+		return time.Date(year, month, d, hour, min, sec, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		order string
+		want  time.Time
+		ok    bool
+	}{
+		{"00001IMG_00001_BURST20230501120000.jpg", DateOrderYMD, at(2023, 5, 1, 12, 0, 0), true},
+		{"PXL_20230501_120000123.jpg", DateOrderYMD, at(2023, 5, 1, 12, 0, 0), true},
+		{"PANO_20230501_120000.jpg", DateOrderYMD, at(2023, 5, 1, 12, 0, 0), true},
+		{"20230501-120000.mp4", DateOrderYMD, at(2023, 5, 1, 12, 0, 0), true},
+		{"2023-05-01 12.00.00.jpg", DateOrderYMD, at(2023, 5, 1, 12, 0, 0), true},
+		{"2023-05-01_12-00-00.jpg", DateOrderYMD, at(2023, 5, 1, 12, 0, 0), true},
+		{"2023-05-01.jpg", DateOrderYMD, day(2023, 5, 1), true},
+		{"2023.05.01.jpg", DateOrderYMD, day(2023, 5, 1), true},
+		{"May 1 2023.jpg", DateOrderYMD, day(2023, 5, 1), true},
+		{"1 May 2023.jpg", DateOrderYMD, day(2023, 5, 1), true},
+		{"September 12 2023.jpg", DateOrderYMD, day(2023, 9, 12), true},
+		{"VID_20230501.mp4", DateOrderYMD, day(2023, 5, 1), true},
+		// An 8 digit date beside an unrelated dash is not misread
+		{"trip-VID_20230501.mp4", DateOrderYMD, day(2023, 5, 1), true},
+		// Longer runs of digits are not dates
+		{"IMG_202305011.jpg", DateOrderYMD, time.Time{}, false},
+		{"IMG_0001.jpg", DateOrderYMD, time.Time{}, false},
+		{"20231301.jpg", DateOrderYMD, time.Time{}, false},
+		// Year last dates depend on the order
+		{"01-05-2023.jpg", DateOrderYMD, time.Time{}, false},
+		{"01-05-2023.jpg", DateOrderDMY, day(2023, 5, 1), true},
+		{"01-05-2023.jpg", DateOrderMDY, day(2023, 1, 5), true},
+		{"01.05.2023.jpg", DateOrderDMY, day(2023, 5, 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order+"/"+tt.name, func(t *testing.T) {
+			patterns, err := FilenamePatternsFor(tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := filenameDate(tt.name, patterns, nil)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("filenameDate(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFilenamePatternsAnchored(t *testing.T) {
+	patterns, err := FilenamePatternsFor(DateOrderDMY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range patterns {
+		if pattern.Regexp.NumSubexp() != 1 {
+			t.Errorf("pattern %s has %d groups, want the date token alone", pattern.Regexp, pattern.Regexp.NumSubexp())
+		}
+		if pattern.Layout == "" {
+			t.Errorf("pattern %s has no layout", pattern.Regexp)
+		}
+	}
+}
+
+func TestPathDate(t *testing.T) {
+	ymd := regexp.MustCompile(`(?P<year>\d{4})/(?P<month>\d{2})/(?P<day>\d{2})`)
+	named := regexp.MustCompile(`(?P<year>\d{4})/(?P<month>[A-Za-z]+)`)
+	yearOnly := regexp.MustCompile(`(?:^|/)(?P<year>\d{4})(?:/|$)`)
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		dir     string
+		pattern *regexp.Regexp
+		loc     *time.Location
+		want    time.Time
+		ok      bool
+	}{
+		{"2023/05/01", ymd, nil, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"trips/2023/05/01/beach", ymd, nil, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{filepath.Join("2023", "05", "01"), ymd, nil, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2023/05/01", ymd, paris, time.Date(2023, 5, 1, 0, 0, 0, 0, paris), true},
+		{"2023/May", named, nil, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2023/sept", named, nil, time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2023/DECEMBER", named, nil, time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), true},
+		{"scans/1998/family", yearOnly, nil, time.Date(1998, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2023/Mayday", named, nil, time.Time{}, false},
+		{"2023/13/01", ymd, nil, time.Time{}, false},
+		{"2023/05/32", ymd, nil, time.Time{}, false},
+		{"2023/05/00", ymd, nil, time.Time{}, false},
+		{"trips/beach", ymd, nil, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := pathDate(tt.dir, tt.pattern, tt.loc)
+		if ok != tt.ok || !got.Equal(tt.want) || ok && got.Location() != tt.want.Location() {
+			t.Errorf("pathDate(%q, %s) = %v, %v, want %v, %v", tt.dir, tt.pattern, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestExtractDateFallbacks(t *testing.T) {
+	session := regexp.MustCompile(`(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2})_timelapse`)
+	byPath := regexp.MustCompile(`(?P<year>\d{4})/(?P<month>\d{2})`)
+	exif := map[string]interface{}{"DateTimeOriginal": "2021:07:04 10:00:00"}
+	day := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		path   string
+		fields map[string]interface{}
+		opts   Options
+		want   time.Time
+		source DateSource
+		err    error
+	}{
+		{"parent", "src/2023-05-01 Trip/IMG_0001.jpg", nil, Options{ParentDateFallback: true}, day(2023, 5, 1), SourcePath, nil},
+		{"parent disabled", "src/2023-05-01 Trip/IMG_0001.jpg", nil, Options{}, time.Time{}, "", ErrNoDate},
+		{"parent without a date", "src/Trip/IMG_0001.jpg", nil, Options{ParentDateFallback: true}, time.Time{}, "", ErrNoDate},
+		{"exif over parent", "src/2023-05-01 Trip/IMG_0001.jpg", exif, Options{ParentDateFallback: true}, time.Date(2021, 7, 4, 10, 0, 0, 0, time.UTC), SourceExif, nil},
+		{"file name over parent", "src/2023-05-01 Trip/IMG_20220302.jpg", nil, Options{ParentDateFallback: true}, day(2022, 3, 2), SourceFilename, nil},
+		{"parent over modification time", "src/2023-05-01 Trip/IMG_0001.jpg", nil, Options{ParentDateFallback: true, MtimeFallback: true}, day(2023, 5, 1), SourcePath, nil},
+		{"session", "src/2023-06-10_timelapse/frame_0001.jpg", nil, Options{SessionPattern: session}, day(2023, 6, 10), SourceSession, nil},
+		{"session of a parent folder", "src/2023-06-10_timelapse/raw/frame_0001.jpg", nil, Options{SessionPattern: session}, time.Time{}, "", ErrNoDate},
+		{"exif over session", "src/2023-06-10_timelapse/frame_0001.jpg", exif, Options{SessionPattern: session}, time.Date(2021, 7, 4, 10, 0, 0, 0, time.UTC), SourceExif, nil},
+		{"parent over session", "src/2023-06-10_timelapse/frame_0001.jpg", nil, Options{ParentDateFallback: true, SessionPattern: session}, day(2023, 6, 10), SourcePath, nil},
+		{"path over exif", "src/2019/08/IMG_0001.jpg", exif, Options{PathPattern: byPath}, day(2019, 8, 1), SourcePath, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := mediaFile{path: tt.path, srcFolder: filepath.Dir(strings.TrimPrefix(tt.path, "src/"))}
+			opts := tt.opts
+			opts.Tags = DefaultDateTags
+			opts.FilenamePatterns = DefaultFilenamePatterns
+			err := extractDate(fakeExtractor{tt.path: tt.fields}, &file, opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("extractDate() error = %v, want %v", err, tt.err)
+			}
+			if !file.date.Equal(tt.want) || file.source != tt.source {
+				t.Errorf("date = %v from %q, want %v from %q", file.date, file.source, tt.want, tt.source)
+			}
+		})
+	}
+}
+
+func TestMoveExifBackup(t *testing.T) {
+	dir := t.TempDir()
+	dest, backups := filepath.Join(dir, "dest"), filepath.Join(dir, "backups")
+	path := filepath.Join(dest, "2023", "05", "IMG_0001.jpg")
+	writeFiles(t, path, path+exifBackupSuffix)
+
+	// Without a backup directory the backup stays next to its file
+	if err := moveExifBackup(path, Options{Dest: dest}, log.Default()); err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(path + exifBackupSuffix) {
+		t.Fatal("backup moved without a backup directory")
+	}
+
+	opts := Options{Dest: dest, ExifBackupDir: backups}
+	if err := moveExifBackup(path, opts, log.Default()); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(backups, "2023", "05", "IMG_0001.jpg"+exifBackupSuffix)
+	if fileExists(path+exifBackupSuffix) || !fileExists(moved) {
+		t.Errorf("backup not moved to %s", moved)
+	}
+	// Files left unchanged have no backup to move
+	if err := moveExifBackup(path, opts, log.Default()); err != nil {
+		t.Errorf("moveExifBackup() without a backup error = %v", err)
+	}
+
+	// Files outside the destination are backed up by their name
+	outside := filepath.Join(dir, "quarantine", "IMG_0002.jpg")
+	writeFiles(t, outside+exifBackupSuffix)
+	if err := moveExifBackup(outside, opts, log.Default()); err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(backups, "IMG_0002.jpg"+exifBackupSuffix)) {
+		t.Error("backup of a file outside the destination not moved to the top of the backup directory")
+	}
+}
+
+func TestExtractDateSidecar(t *testing.T) {
+	dir := t.TempDir()
+	upper, lower := filepath.Join(dir, "MVI_0001.MOV"), filepath.Join(dir, "mvi_0002.mov")
+	writeFiles(t, upper, filepath.Join(dir, "MVI_0001.THM"), lower, filepath.Join(dir, "mvi_0002.thm"))
+	thumbnail := map[string]interface{}{"DateTimeOriginal": "2009:07:04 10:00:00"}
+	extractor := fakeExtractor{
+		filepath.Join(dir, "MVI_0001.THM"): thumbnail,
+		filepath.Join(dir, "mvi_0002.thm"): thumbnail,
+	}
+	want := time.Date(2009, 7, 4, 10, 0, 0, 0, time.UTC)
+	opts := Options{Tags: DefaultDateTags, FilenamePatterns: DefaultFilenamePatterns, DateSidecars: DefaultDateSidecars}
+
+	for _, path := range []string{upper, lower} {
+		file := mediaFile{path: path}
+		if err := extractDate(extractor, &file, opts); err != nil {
+			t.Fatalf("extractDate(%s) error = %v", path, err)
+		}
+		if !file.date.Equal(want) || file.source != SourceExif {
+			t.Errorf("%s dated %v from %q, want %v from %q", path, file.date, file.source, want, SourceExif)
+		}
+	}
+
+	// A date of the video's own wins over its sidecar's
+	extractor[upper] = map[string]interface{}{"CreateDate": "2009:07:05 12:00:00"}
+	file := mediaFile{path: upper}
+	if err := extractDate(extractor, &file, opts); err != nil || file.date.Day() != 5 {
+		t.Errorf("extractDate() = %v, %v, want the video's own date", file.date, err)
+	}
+
+	opts.DateSidecars = nil
+	file = mediaFile{path: lower}
+	if err := extractDate(extractor, &file, opts); !errors.Is(err, ErrNoDate) {
+		t.Errorf("extractDate() error = %v without sidecars, want %v", err, ErrNoDate)
+	}
+}
+
+func TestKeptTags(t *testing.T) {
+	fields := map[string]interface{}{"Artist": "Jane", "Copyright": "Jane 2023", "Make": "Canon"}
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"everything by default", Options{}, []string{"Artist", "Copyright", "Make"}},
+		{"narrowed", Options{KeepTags: []string{"Artist", "Rating"}}, []string{"Artist"}},
+		{"all over narrowed", Options{KeepTags: []string{"Artist"}, KeepAllTags: true}, []string{"Artist", "Copyright", "Make"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept := keptTags(fields, tt.opts)
+			var got []string
+			for tag := range kept {
+				got = append(got, tag)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("keptTags() = %v, want %v", got, tt.want)
+			}
+			for tag := range kept {
+				if kept[tag] != fields[tag] {
+					t.Errorf("%s = %v, want %v", tag, kept[tag], fields[tag])
+				}
+			}
+		})
+	}
+}
+
+func TestRewrittenMetadata(t *testing.T) {
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	read := exiftool.FileMetadata{
+		File:   "dest/2023/05/01/IMG_0001.jpg",
+		Fields: map[string]interface{}{"Artist": "Jane", "Make": "Canon", "DateTimeOriginal": "2000:01:01 00:00:00"},
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]string
+	}{
+		{"everything read", Options{}, map[string]string{
+			"Artist": "Jane", "Make": "Canon", "DateTimeOriginal": "2023-05-01 12:00:00", "CreateDate": "2023-05-01 12:00:00",
+		}},
+		{"kept tags", Options{KeepTags: []string{"Artist"}}, map[string]string{
+			"Artist": "Jane", "DateTimeOriginal": "2023-05-01 12:00:00", "CreateDate": "2023-05-01 12:00:00",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			written := rewrittenMetadata(read, date, tt.opts)
+			if written.File != read.File {
+				t.Errorf("file = %q, want %q", written.File, read.File)
+			}
+			if len(written.Fields) != len(tt.want) {
+				t.Errorf("wrote %v, want %v", written.Fields, tt.want)
+			}
+			for tag, want := range tt.want {
+				if got, _ := written.GetString(tag); got != want {
+					t.Errorf("%s = %q, want %q", tag, got, want)
+				}
+			}
+		})
+	}
+	if read.Fields["DateTimeOriginal"] != "2000:01:01 00:00:00" {
+		t.Errorf("read fields were modified: %v", read.Fields)
+	}
+}
+
+func TestOlderVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		minimum string
+		want    bool
+	}{
+		{"12.40", "12.40", false},
+		{"12.39", "12.40", true},
+		{"12.41", "12.40", false},
+		{"12.10", "12.9", false},
+		{"12.9", "12.10", true},
+		{"13.00", "12.99", false},
+		{"12", "12.01", true},
+		{"12.40.1", "12.40", false},
+		{" 12.40\n", "12.40", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+"<"+tt.minimum, func(t *testing.T) {
+			got, err := olderVersion(tt.version, tt.minimum)
+			if err != nil || got != tt.want {
+				t.Errorf("olderVersion(%q, %q) = %v, %v, want %v", tt.version, tt.minimum, got, err, tt.want)
+			}
+		})
+	}
+	for _, invalid := range []string{"", "12.x", "v12", "12..4", "-1.2"} {
+		if _, err := olderVersion(invalid, "12.40"); err == nil {
+			t.Errorf("olderVersion(%q) error = nil, want an invalid version", invalid)
+		}
+	}
+}
+
+func TestCheckExiftoolVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub exiftool is a shell script")
+	}
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	binary := filepath.Join(dir, "exiftool")
+	writeFile(t, binary, "#!/bin/sh\necho run >> "+calls+"\necho 12.10\n")
+	if err := os.Chmod(binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		minimum string
+		ok      bool
+	}{
+		{"12.9", true},
+		{"12.10", true},
+		{"12.11", false},
+		{"twelve", false},
+	}
+	for _, tt := range tests {
+		err := checkExiftoolVersion(Options{ExiftoolPath: binary, MinExiftoolVersion: tt.minimum})
+		if (err == nil) != tt.ok {
+			t.Errorf("checkExiftoolVersion(%q) error = %v, want ok %v", tt.minimum, err, tt.ok)
+		}
+	}
+
+	// exiftool is only asked for its version once
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(data), "run"); runs != 1 {
+		t.Errorf("exiftool ran %d times, want once", runs)
+	}
+}
+
+func TestParseExifDate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2023:05:01 12:34:56", time.Date(2023, 5, 1, 12, 34, 56, 0, time.UTC), true},
+		{"2023:05:01 12:34:56+02:00", time.Date(2023, 5, 1, 10, 34, 56, 0, time.UTC), true},
+		{"2023:05:01 12:34:56.25", time.Date(2023, 5, 1, 12, 34, 56, 250000000, time.UTC), true},
+		{"2023:05:01 12:34:56.25Z", time.Date(2023, 5, 1, 12, 34, 56, 250000000, time.UTC), true},
+		{"0000:00:00 00:00:00", time.Time{}, false},
+		{"2023:05:01", time.Time{}, false},
+		{"   ", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseExifDate(tt.value, nil)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseExifDate(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFirstTagDate(t *testing.T) {
+	tags := DefaultDateTags.Image
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   time.Time
+		ok     bool
+	}{
+		{"original", map[string]interface{}{
+			"DateTimeOriginal": "2023:05:01 12:00:00",
+			"ModifyDate":       "2024:01:01 00:00:00",
+		}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		// Stripping tools often drop the main IFD but keep the thumbnail's
+		// ModifyDate or the maker notes
+		{"thumbnail ifd", map[string]interface{}{
+			"ModifyDate": "2023:05:01 12:00:00",
+		}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"maker notes", map[string]interface{}{
+			"SonyDateTime": "2023:05:01 12:00:00",
+			"TimeStamp":    "2024:01:01 00:00:00",
+		}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"invalid tags are skipped", map[string]interface{}{
+			"DateTimeOriginal": "0000:00:00 00:00:00",
+			"CreateDate":       "not a date",
+			"ModifyDate":       "2023:05:01 12:00:00",
+		}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), true},
+		{"none", map[string]interface{}{"Make": "Canon"}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := firstTagDate(exiftool.FileMetadata{File: "IMG_0001.jpg", Fields: tt.fields}, tags, nil)
+			if ok != tt.ok || !got.Equal(tt.want) {
+				t.Errorf("firstTagDate() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestExtractDateConfiguredTags(t *testing.T) {
+	path := "src/IMG_0001.jpg"
+	fields := map[string]interface{}{
+		"DateTimeOriginal": "2023:05:01 12:00:00",
+		"ModifyDate":       "2024:02:03 04:05:06",
+	}
+	file := mediaFile{path: path}
+	opts := Options{Tags: DateTags{Image: []string{"ModifyDate", "DateTimeOriginal"}}}
+	if err := extractDate(fakeExtractor{path: fields}, &file, opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC); !file.date.Equal(want) {
+		t.Errorf("date = %v, want %v from the first configured tag", file.date, want)
+	}
+}
+
+func TestFirstTagDateIPTC(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   time.Time
+	}{
+		{"date and time", map[string]interface{}{
+			"DateCreated": "2023:05:01",
+			"TimeCreated": "12:34:56",
+		}, time.Date(2023, 5, 1, 12, 34, 56, 0, time.UTC)},
+		{"time with offset", map[string]interface{}{
+			"DateCreated": "2023:05:01",
+			"TimeCreated": "12:34:56+02:00",
+		}, time.Date(2023, 5, 1, 10, 34, 56, 0, time.UTC)},
+		{"date only", map[string]interface{}{
+			"DateCreated": "2023:05:01",
+		}, time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)},
+		// XMP-photoshop:DateCreated carries its own time
+		{"xmp date", map[string]interface{}{
+			"DateCreated": "2023:05:01 08:00:00",
+			"TimeCreated": "12:34:56",
+		}, time.Date(2023, 5, 1, 8, 0, 0, 0, time.UTC)},
+		{"after exif", map[string]interface{}{
+			"DateTimeOriginal": "2022:01:02 03:04:05",
+			"DateCreated":      "2023:05:01",
+			"TimeCreated":      "12:34:56",
+		}, time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := firstTagDate(exiftool.FileMetadata{File: "IMG_0001.jpg", Fields: tt.fields}, DefaultDateTags.Image, nil)
+			if !ok || !got.Equal(tt.want) {
+				t.Errorf("firstTagDate() = %v, %v, want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractDatePhoneNames(t *testing.T) {
+	want := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"PANO_20230501_120000.jpg", "00001IMG_00001_BURST20230501120000.jpg", "PXL_20230501_120000123.jpg"} {
+		path := filepath.Join("DCIM", "Camera", name)
+		file := mediaFile{path: path}
+		opts := Options{Tags: DefaultDateTags, FilenamePatterns: DefaultFilenamePatterns}
+		if err := extractDate(fakeExtractor{path: {"Make": "Google"}}, &file, opts); err != nil {
+			t.Fatalf("extractDate(%q) error = %v", name, err)
+		}
+		if !file.date.Equal(want) || file.source != SourceFilename {
+			t.Errorf("extractDate(%q) = %v from %s, want %v from %s", name, file.date, file.source, want, SourceFilename)
+		}
+	}
+}
+
+func TestExtractDatePrefer(t *testing.T) {
+	path := filepath.Join("src", "IMG_20230501_120000.jpg")
+	exifDate := time.Date(2023, 5, 3, 9, 0, 0, 0, time.UTC)
+	nameDate := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		prefer       string
+		disagreement time.Duration
+		want         time.Time
+		source       DateSource
+		warned       bool
+	}{
+		{PreferExif, 24 * time.Hour, exifDate, SourceExif, true},
+		{PreferFilename, 24 * time.Hour, nameDate, SourceFilename, true},
+		{PreferOldest, 24 * time.Hour, nameDate, SourceFilename, true},
+		{PreferExif, 72 * time.Hour, exifDate, SourceExif, false},
+		{PreferExif, 0, exifDate, SourceExif, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.prefer, " ", tt.disagreement), func(t *testing.T) {
+			logged := useLog(t)
+			file := mediaFile{path: path}
+			opts := Options{Tags: DefaultDateTags, FilenamePatterns: DefaultFilenamePatterns, DatePrefer: tt.prefer, DateDisagreement: tt.disagreement}
+			fields := map[string]interface{}{"DateTimeOriginal": "2023:05:03 09:00:00"}
+			if err := extractDate(fakeExtractor{path: fields}, &file, opts); err != nil {
+				t.Fatal(err)
+			}
+			if !file.date.Equal(tt.want) || file.source != tt.source {
+				t.Errorf("date = %v from %s, want %v from %s", file.date, file.source, tt.want, tt.source)
+			}
+			if warned := strings.Contains(logged.String(), "EXIF and filename dates disagree"); warned != tt.warned {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.warned, logged)
+			}
+		})
+	}
+
+	// The oldest date wins whichever source it comes from
+	file := mediaFile{path: path}
+	opts := Options{Tags: DefaultDateTags, FilenamePatterns: DefaultFilenamePatterns, DatePrefer: PreferOldest}
+	fields := map[string]interface{}{"DateTimeOriginal": "2023:04:30 09:00:00"}
+	if err := extractDate(fakeExtractor{path: fields}, &file, opts); err != nil {
+		t.Fatal(err)
+	}
+	if file.source != SourceExif {
+		t.Errorf("source = %s, want the older %s date", file.source, SourceExif)
+	}
+}
+
+func TestExtractDateQuickTime(t *testing.T) {
+	path := filepath.Join("DCIM", "100APPLE", "IMG_0001.MOV")
+	if kind := mediaKind(path); kind != kindVideo {
+		t.Fatalf("mediaKind(%q) = %q, want %q", path, kind, kindVideo)
+	}
+
+	// Shot late in the evening in New York, which is the next day in UTC
+	fields := map[string]interface{}{
+		"CreationDate":    "2023:05:01 23:30:00-04:00",
+		"MediaCreateDate": "2023:05:02 03:30:00",
+	}
+	file := mediaFile{path: path}
+	if err := extractDate(fakeExtractor{path: fields}, &file, Options{Tags: DefaultDateTags}); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2023, 5, 2, 3, 30, 0, 0, time.UTC); !file.date.Equal(want) || file.date.Day() != 1 {
+		t.Errorf("date = %v, want %v on the local day", file.date, want)
+	}
+
+	// Without the QuickTime key the UTC media date is used
+	delete(fields, "CreationDate")
+	file = mediaFile{path: path}
+	if err := extractDate(fakeExtractor{path: fields}, &file, Options{Tags: DefaultDateTags}); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2023, 5, 2, 3, 30, 0, 0, time.UTC); !file.date.Equal(want) {
+		t.Errorf("date = %v, want %v", file.date, want)
+	}
+}
+
+func TestCameraSerial(t *testing.T) {
+	names := map[string]string{"12345": "CameraA"}
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   string
+	}{
+		{"named", map[string]interface{}{"SerialNumber": "12345"}, "CameraA"},
+		{"numeric", map[string]interface{}{"SerialNumber": float64(12345)}, "CameraA"},
+		{"unnamed", map[string]interface{}{"SerialNumber": "67890"}, "67890"},
+		{"body", map[string]interface{}{"SerialNumber": " ", "BodySerialNumber": "0042"}, "0042"},
+		{"none", map[string]interface{}{"Model": "EOS R5"}, UnknownBody},
+	}
+	for _, tt := range tests {
+		if got := cameraSerial(tt.fields, names); got != tt.want {
+			t.Errorf("%s: cameraSerial() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCameraModel(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   string
+	}{
+		{"make and model", map[string]interface{}{"Make": "SONY", "Model": "ILCE-7M3"}, "SONY ILCE-7M3"},
+		{"model with the make", map[string]interface{}{"Make": "Canon", "Model": "Canon EOS 5D Mark IV"}, "Canon EOS 5D Mark IV"},
+		{"model with the first word of the make", map[string]interface{}{"Make": "NIKON CORPORATION", "Model": "NIKON D850"}, "NIKON D850"},
+		{"model only", map[string]interface{}{"Model": " iPhone 14 Pro "}, "iPhone 14 Pro"},
+		{"make only", map[string]interface{}{"Make": "GoPro"}, "GoPro"},
+		{"unsafe", map[string]interface{}{"Make": "Acme", "Model": "Cam/2"}, "Acme Cam_2"},
+		{"none", nil, UnknownModel},
+	}
+	for _, tt := range tests {
+		if got := cameraModel(tt.fields); got != tt.want {
+			t.Errorf("%s: cameraModel() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractDateMtimeFallback(t *testing.T) {
+	path := "src/IMG_0001.jpg"
+	modTime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	opts := Options{Tags: DefaultDateTags, FilenamePatterns: DefaultFilenamePatterns}
+
+	file := mediaFile{path: path, modTime: modTime}
+	if err := extractDate(fakeExtractor{path: nil}, &file, opts); !errors.Is(err, ErrNoDate) {
+		t.Fatalf("extractDate() error = %v, want %v without the fallback", err, ErrNoDate)
+	}
+
+	opts.MtimeFallback = true
+	file = mediaFile{path: path, modTime: modTime}
+	if err := extractDate(fakeExtractor{path: nil}, &file, opts); err != nil {
+		t.Fatalf("extractDate() error = %v", err)
+	}
+	if !file.date.Equal(modTime) || file.source != SourceMtime {
+		t.Errorf("date = %v from %q, want %v from %q", file.date, file.source, modTime, SourceMtime)
+	}
+
+	// The modification date exiftool reports keeps its offset, unlike the
+	// walked one
+	file = mediaFile{path: path, modTime: modTime}
+	fields := map[string]interface{}{"FileModifyDate": "2023:05:01 14:00:00+02:00"}
+	if err := extractDate(fakeExtractor{path: fields}, &file, opts); err != nil {
+		t.Fatalf("extractDate() error = %v", err)
+	}
+	if !file.date.Equal(modTime) || file.date.Hour() != 14 || file.source != SourceMtime {
+		t.Errorf("date = %v from %q, want %v at 14:00 from %q", file.date, file.source, modTime, SourceMtime)
+	}
+	opts.Tags.File = nil
+	file = mediaFile{path: path, modTime: modTime.Add(time.Hour)}
+	if err := extractDate(fakeExtractor{path: fields}, &file, opts); err != nil || !file.date.Equal(modTime.Add(time.Hour)) {
+		t.Errorf("extractDate() = %v, %v without file date tags, want the walked %v", file.date, err, modTime.Add(time.Hour))
+	}
+	opts.Tags.File = DefaultDateTags.File
+
+	// A date of its own still wins over the modification time
+	file = mediaFile{path: "src/IMG_20220302.jpg", modTime: modTime}
+	if err := extractDate(fakeExtractor{file.path: nil}, &file, opts); err != nil || file.source != SourceFilename {
+		t.Errorf("extractDate() = %v from %q, %v, want the file name date", file.date, file.source, err)
+	}
+}
+
+func TestCheckExecutable(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "exiftool")
+	writeFile(t, binary, "#!/bin/sh\n")
+	if err := os.Chmod(binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "exiftool.txt")
+	writeFile(t, plain, "not a program")
+	tests := []struct {
+		path string
+		want string
+	}{
+		{binary, ""},
+		{filepath.Join(dir, "missing"), "exiftool binary"},
+		{dir, "is a directory"},
+		{plain, "is not executable"},
+	}
+	for _, tt := range tests {
+		if tt.path == plain && runtime.GOOS == "windows" {
+			// Windows has no executable bit
+			continue
+		}
+		err := checkExecutable(tt.path)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("checkExecutable(%q) error = %v, want %q", tt.path, err, tt.want)
+		}
+	}
+
+	// The binary is checked before anything runs
+	opts := Options{OnConflict: ConflictRename, DatePrefer: PreferExif, ExiftoolPath: filepath.Join(dir, "missing")}
+	if err := opts.Validate(); err == nil {
+		t.Error("Validate() with a missing exiftool succeeded")
+	}
+	if got := exiftoolBinary(Options{}); got != "exiftool" {
+		t.Errorf("exiftoolBinary() = %q, want exiftool from the PATH", got)
+	}
+	if got := exiftoolBinary(Options{ExiftoolPath: binary}); got != binary {
+		t.Errorf("exiftoolBinary() = %q, want %q", got, binary)
+	}
+}
+
+func TestExtractDateGPS(t *testing.T) {
+	path := "src/IMG_0001.jpg"
+	// The camera clock was left on local time two hours ahead of UTC
+	fields := map[string]interface{}{
+		"DateTimeOriginal": "2023:05:01 14:00:00",
+		"GPSDateStamp":     "2023:05:01",
+		"GPSTimeStamp":     "12:00:00",
+	}
+	gps := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		opts   Options
+		want   time.Time
+		source DateSource
+		warned bool
+	}{
+		{"within threshold", Options{GPSDisagreement: 3 * time.Hour}, gps.Add(2 * time.Hour), SourceExif, false},
+		{"beyond threshold", Options{GPSDisagreement: time.Hour}, gps.Add(2 * time.Hour), SourceExif, true},
+		{"prefer gps", Options{PreferGPSTime: true}, gps, SourceGPS, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := useLog(t)
+			file := mediaFile{path: path}
+			opts := tt.opts
+			opts.Tags = DefaultDateTags
+			if err := extractDate(fakeExtractor{path: fields}, &file, opts); err != nil {
+				t.Fatal(err)
+			}
+			if !file.date.Equal(tt.want) || file.source != tt.source {
+				t.Errorf("date = %v from %s, want %v from %s", file.date, file.source, tt.want, tt.source)
+			}
+			if warned := strings.Contains(logged.String(), "EXIF and GPS dates disagree"); warned != tt.warned {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.warned, logged)
+			}
+		})
+	}
+
+	// Without a capture date the GPS time alone does not date a file
+	file := mediaFile{path: path}
+	gpsOnly := map[string]interface{}{"GPSDateStamp": "2023:05:01", "GPSTimeStamp": "12:00:00"}
+	if err := extractDate(fakeExtractor{path: gpsOnly}, &file, Options{Tags: DefaultDateTags, PreferGPSTime: true}); !errors.Is(err, ErrNoDate) {
+		t.Errorf("extractDate() error = %v, want %v", err, ErrNoDate)
+	}
+}
+
+func TestPrimaryKeyword(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   string
+	}{
+		{"subject list", map[string]interface{}{"Subject": []interface{}{"Vacation", "Family"}}, "Vacation"},
+		{"single subject", map[string]interface{}{"Subject": "Family"}, "Family"},
+		{"iptc keywords", map[string]interface{}{"Keywords": []interface{}{"Beach"}}, "Beach"},
+		{"hierarchical", map[string]interface{}{"HierarchicalSubject": []interface{}{"Places|France|Paris"}}, "Paris"},
+		{"unsafe", map[string]interface{}{"Subject": "Work/Travel"}, "Work_Travel"},
+		{"blank", map[string]interface{}{"Subject": " ", "Keywords": "Beach"}, "Beach"},
+		{"untagged", map[string]interface{}{"Make": "Canon"}, "Untagged"},
+	}
+	for _, tt := range tests {
+		if got := primaryKeyword(tt.fields, "Untagged"); got != tt.want {
+			t.Errorf("%s: primaryKeyword() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilenamePatternsFor(t *testing.T) {
+	if _, err := FilenamePatternsFor("ydm"); err == nil {
+		t.Error("FilenamePatternsFor() of an unknown order succeeded")
+	}
+
+	// Year first dates win over year last ones whatever the order
+	patterns, err := FilenamePatternsFor(DateOrderDMY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != len(DefaultFilenamePatterns)+2 {
+		t.Errorf("FilenamePatternsFor() returned %d patterns, want the %d default ones and 2 more", len(patterns), len(DefaultFilenamePatterns))
+	}
+	got, ok := filenameDate("2023-05-01 copy of 02-03-2022.jpg", patterns, nil)
+	if want := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC); !ok || !got.Equal(want) {
+		t.Errorf("filenameDate() = %v, %v, want %v", got, ok, want)
+	}
+}
+
+func TestDatePreferencePerKind(t *testing.T) {
+	opts := Options{
+		Tags:             DefaultDateTags,
+		FilenamePatterns: DefaultFilenamePatterns,
+		DatePrefer:       PreferExif,
+		VideoDatePrefer:  PreferFilename,
+	}
+	tests := []struct {
+		path   string
+		fields map[string]interface{}
+		source DateSource
+	}{
+		{"src/IMG_20230501_120000.jpg", map[string]interface{}{"DateTimeOriginal": "2023:05:03 09:00:00"}, SourceExif},
+		{"src/VID_20230501_120000.mp4", map[string]interface{}{"CreateDate": "2023:05:03 09:00:00"}, SourceFilename},
+	}
+	for _, tt := range tests {
+		file := mediaFile{path: tt.path}
+		if err := extractDate(fakeExtractor{tt.path: tt.fields}, &file, opts); err != nil {
+			t.Fatal(err)
+		}
+		if file.source != tt.source {
+			t.Errorf("%s dated from %s, want %s", tt.path, file.source, tt.source)
+		}
+	}
+
+	valid := Options{OnConflict: ConflictRename, DatePrefer: PreferExif, ImageDatePrefer: PreferOldest}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	invalid := Options{OnConflict: ConflictRename, DatePrefer: PreferExif, VideoDatePrefer: "filename-first"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate() of an unknown video date preference succeeded")
+	}
+}
+
+// stubStayOpenExiftool answers every -execute with the file it was given, as
+// exiftool -stay_open does, and records the arguments of each start in a file
+// beside itself.
+const stubStayOpenExiftool = `#!/bin/sh
+echo "$*" >> "$0.starts"
+file=""
+while read -r line; do
+	case "$line" in
+	-execute)
+		[ -n "$stop" ] && exit 0
+		printf '[{"SourceFile":"%s","Make":"Canon"}]\n{ready}\n' "$file"
+		;;
+	-stay_open|-j) ;;
+	False) stop=1 ;;
+	*) file="$line" ;;
+	esac
+done
+`
+
+// writeStayOpenExiftool writes the stub exiftool and returns its path.
+func writeStayOpenExiftool(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub exiftool is a shell script")
+	}
+	binary := filepath.Join(t.TempDir(), "exiftool")
+	writeFile(t, binary, stubStayOpenExiftool)
+	if err := os.Chmod(binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
+
+func TestNewExiftoolStaysOpen(t *testing.T) {
+	binary := writeStayOpenExiftool(t)
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"IMG_0001.jpg", "IMG_0002.jpg", "IMG_0003.jpg"} {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	writeFiles(t, paths...)
+
+	et, err := newExiftool(Options{ExiftoolPath: binary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		info := et.ExtractMetadata(path)[0]
+		if info.Err != nil || info.Fields["SourceFile"] != path {
+			t.Errorf("ExtractMetadata(%q) = %v, %v", path, info.Fields, info.Err)
+		}
+	}
+	et.Close()
+
+	// One process served every extraction
+	starts, err := os.ReadFile(binary + ".starts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSpace(string(starts)), "\n"); len(got) != 1 || got[0] != "-stay_open True -@ -" {
+		t.Errorf("exiftool started with %q, want once with -stay_open", got)
+	}
+}
+
+func TestNewExiftoolBuffer(t *testing.T) {
+	binary := writeStayOpenExiftool(t)
+	path := filepath.Join(t.TempDir(), "IMG_0001.jpg")
+	writeFiles(t, path)
+
+	et, err := newExiftool(Options{ExiftoolPath: binary, ExiftoolBuffer: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer et.Close()
+	if info := et.ExtractMetadata(path)[0]; !errors.Is(info.Err, exiftool.ErrBufferTooSmall) {
+		t.Errorf("ExtractMetadata() error = %v, want %v past the buffer size", info.Err, exiftool.ErrBufferTooSmall)
+	}
+}
+
+func TestNewExiftoolCharsets(t *testing.T) {
+	binary := writeStayOpenExiftool(t)
+	et, err := newExiftool(Options{ExiftoolPath: binary, Charsets: []string{"filename=utf8", "exif=cp1252"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	et.Close()
+	starts, err := os.ReadFile(binary + ".starts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-stay_open True -@ - -common_args -charset filename=utf8 -charset exif=cp1252"; strings.TrimSpace(string(starts)) != want {
+		t.Errorf("exiftool started with %q, want %q", strings.TrimSpace(string(starts)), want)
+	}
+}
+
+func TestFirstTagDateDigitized(t *testing.T) {
+	// A scanned film frame: shot in 1985, scanned in 2023
+	fields := map[string]interface{}{
+		"DateTimeOriginal": "1985:07:14 15:00:00",
+		"CreateDate":       "2023:05:01 12:00:00",
+	}
+	info := exiftool.FileMetadata{File: "scan_0001.jpg", Fields: fields}
+	tests := []struct {
+		tags []string
+		want time.Time
+	}{
+		{[]string{"DateTimeOriginal", "DateTimeDigitized"}, time.Date(1985, 7, 14, 15, 0, 0, 0, time.UTC)},
+		{[]string{"DateTimeDigitized", "DateTimeOriginal"}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{[]string{"CreateDate"}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got, ok := firstTagDate(info, tt.tags, nil); !ok || !got.Equal(tt.want) {
+			t.Errorf("firstTagDate(%v) = %v, %v, want %v", tt.tags, got, ok, tt.want)
+		}
+	}
+}
+
+func TestExtractDateAutoTags(t *testing.T) {
+	tests := []struct {
+		path   string
+		fields map[string]interface{}
+		want   time.Time
+	}{
+		// PNG stores its date in a text chunk, which no image tag covers
+		{"src/image.png", map[string]interface{}{"CreationTime": "2023:05:01 12:00:00"}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)},
+		// AVI carries the EXIF style date of its RIFF header
+		{"src/clip.avi", map[string]interface{}{"DateTimeOriginal": "2023:05:01 12:00:00", "ModifyDate": "2024:01:01 00:00:00"}, time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{"src/photo.webp", map[string]interface{}{"ModifyDate": "2023:05:01 12:00:00", "SubSecCreateDate": "2022:01:02 03:04:05.50"}, time.Date(2022, 1, 2, 3, 4, 5, 500000000, time.UTC)},
+	}
+	for _, tt := range tests {
+		file := mediaFile{path: tt.path}
+		opts := Options{Tags: DefaultDateTags, AutoDateTags: true}
+		if err := extractDate(fakeExtractor{tt.path: tt.fields}, &file, opts); err != nil {
+			t.Fatalf("extractDate(%q) error = %v", tt.path, err)
+		}
+		if !file.date.Equal(tt.want) || file.source != SourceExif {
+			t.Errorf("extractDate(%q) = %v from %s, want %v from %s", tt.path, file.date, file.source, tt.want, SourceExif)
+		}
+	}
+
+	// Without auto detection the PNG date goes unread
+	file := mediaFile{path: "src/image.png"}
+	fields := map[string]interface{}{"CreationTime": "2023:05:01 12:00:00"}
+	if err := extractDate(fakeExtractor{file.path: fields}, &file, Options{Tags: DefaultDateTags}); !errors.Is(err, ErrNoDate) {
+		t.Errorf("extractDate() error = %v, want %v", err, ErrNoDate)
+	}
+}
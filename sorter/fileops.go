@@ -0,0 +1,477 @@
+package sorter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// Policies for a destination that already holds a different file.
+const (
+	ConflictRename    = "rename"
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+)
+
+// DefaultConflictSuffix numbers renamed files as IMG_0001_1.jpg.
+const DefaultConflictSuffix = "_%d"
+
+// validateConflictSuffix checks that suffix numbers names with a single
+// integer verb, such as " (%d)" or ".%03d", and keeps them in their folder.
+func validateConflictSuffix(suffix string) error {
+	one, two := fmt.Sprintf(suffix, 1), fmt.Sprintf(suffix, 2)
+	if strings.Contains(one, "%!") || one == two || strings.ContainsAny(one, `/\`) {
+		return errors.Errorf("invalid conflict suffix %q, expected a single number verb such as _%%d", suffix)
+	}
+	return nil
+}
+
+// conflictSuffix returns the format numbering renamed files.
+func (opts Options) conflictSuffix() string {
+	if opts.ConflictSuffix == "" {
+		return DefaultConflictSuffix
+	}
+	return opts.ConflictSuffix
+}
+
+// resolveConflict decides where src should go when dest is already taken,
+// either on disk or by another file of the plan in planned. A destination
+// with identical content is always skipped as a re-run, while a different
+// file is handled according to policy. Renamed files are numbered from 1 with
+// suffix before their extension. Files of the same plan are never
+// overwritten. It returns the destination to use and, when the file should be
+// skipped, the reason why, logging to logger.
+func resolveConflict(store Storage, src, dest, policy, suffix string, planned map[string]string, logger *log.Logger) (string, string, error) {
+	occupant, inPlan, err := destOccupant(store, dest, planned)
+	if err != nil || occupant == "" {
+		return dest, "", err
+	}
+
+	same, err := occupantSame(store, src, occupant, inPlan)
+	if err != nil {
+		return dest, "", err
+	}
+	if same {
+		logger.Info("Skipping identical file", "src", src, "dest", dest)
+		return dest, ReasonIdentical, nil
+	}
+
+	logger.Warn("Different file already exists at destination", "src", src, "dest", dest, "policy", policy)
+	switch {
+	case policy == ConflictSkip:
+		return dest, ReasonConflict, nil
+	case policy == ConflictOverwrite && !inPlan:
+		return dest, "", nil
+	}
+
+	// Find the first free name by numbering the file, keeping its extension
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	for i := 1; ; i++ {
+		candidate := base + fmt.Sprintf(suffix, i) + ext
+		occupant, inPlan, err := destOccupant(store, candidate, planned)
+		if err != nil {
+			return dest, "", err
+		}
+		if occupant == "" {
+			return candidate, "", nil
+		}
+		if same, err := occupantSame(store, src, occupant, inPlan); err != nil {
+			return dest, "", err
+		} else if same {
+			logger.Info("Skipping identical file", "src", src, "dest", candidate)
+			return candidate, ReasonIdentical, nil
+		}
+	}
+}
+
+// destOccupant returns the file whose content will be at dest: the source
+// planned to go there, or the file already in store. It returns an empty path
+// when dest is free, and whether the occupant comes from the plan.
+func destOccupant(store Storage, dest string, planned map[string]string) (string, bool, error) {
+	if src, ok := planned[dest]; ok {
+		return src, true, nil
+	}
+	exists, err := store.Exists(dest)
+	if err != nil || !exists {
+		return "", false, err
+	}
+	return dest, false, nil
+}
+
+// occupantSame reports whether src has the content of the occupant of a
+// destination, which is a local source when it comes from the plan.
+func occupantSame(store Storage, src, occupant string, inPlan bool) (bool, error) {
+	if inPlan {
+		return sameContent(osFS, src, occupant)
+	}
+	return store.Same(src, occupant)
+}
+
+// samePath reports whether src and dest name the same file, either by their
+// absolute path or by pointing at the same file on disk.
+func samePath(src, dest string) bool {
+	absSrc, srcErr := filepath.Abs(src)
+	absDest, destErr := filepath.Abs(dest)
+	if srcErr == nil && destErr == nil && absSrc == absDest {
+		return true
+	}
+	srcInfo, srcErr := os.Stat(src)
+	destInfo, destErr := os.Stat(dest)
+	return srcErr == nil && destErr == nil && os.SameFile(srcInfo, destInfo)
+}
+
+// ensureDir creates the directory a file is about to be written into.
+func ensureDir(fsys fileSystem, path string) error {
+	exPath := filepath.Dir(path)
+	err := fsys.MkdirAll(exPath, os.ModePerm)
+	return errors.Wrapf(err, "creating directory %q", exPath)
+}
+
+// replaceFile writes data to path through a temporary file, which is synced
+// to disk before being renamed over path, so path holds either its previous
+// or its new content whenever the system crashes.
+func replaceFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmp, path))
+}
+
+// DefaultCopyBuffer is the size of the buffer files are copied through,
+// unless configured otherwise.
+const DefaultCopyBuffer = 1 << 20
+
+// copyFile copies src to dest on fsys through a buffer of up to bufSize
+// bytes.
+func copyFile(fsys fileSystem, src, dest string, bufSize int) error {
+	// Open source file for reading
+	srcFile, err := fsys.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "opening source %q", src)
+	}
+	defer srcFile.Close()
+
+	err = ensureDir(fsys, dest)
+	if err != nil {
+		return err
+	}
+
+	// Create destination file for writing
+	destFile, err := fsys.Create(dest)
+	if err != nil {
+		return errors.Wrapf(err, "creating destination %q", dest)
+	}
+
+	// Copy file contents, removing the partial destination on failure. The
+	// destination is wrapped so that the buffer is used rather than the
+	// file's own ReadFrom, which copies in small chunks over network shares.
+	_, err = io.CopyBuffer(struct{ io.Writer }{destFile}, srcFile, copyBuffer(srcFile, bufSize))
+	if err != nil {
+		err = errors.Wrapf(err, "copying %q to %q", src, dest)
+	} else if err = destFile.Sync(); err != nil {
+		err = errors.Wrapf(err, "syncing destination %q", dest)
+	}
+	if closeErr := destFile.Close(); err == nil && closeErr != nil {
+		err = errors.Wrapf(closeErr, "closing destination %q", dest)
+	}
+	if err != nil {
+		fsys.Remove(dest)
+	}
+	return err
+}
+
+// resumeCheckSize is how many bytes before the end of a partial copy are
+// compared with the source before the copy is resumed.
+const resumeCheckSize = 1 << 20
+
+// copyFileResumable copies src to dest through a .part file which is left in
+// place on failure. A later copy resumes from the end of the .part file when
+// its last bytes match the source, and starts over otherwise. It logs to
+// logger.
+func copyFileResumable(src, dest string, bufSize int, logger *log.Logger) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "opening source %q", src)
+	}
+	defer srcFile.Close()
+
+	if err := ensureDir(osFS, dest); err != nil {
+		return err
+	}
+
+	part := dest + ".part"
+	partFile, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return errors.Wrapf(err, "opening partial copy %q", part)
+	}
+	defer partFile.Close()
+
+	offset, err := resumeOffset(srcFile, partFile, logger)
+	if err != nil {
+		return errors.Wrapf(err, "checking partial copy %q", part)
+	}
+	if offset > 0 {
+		logger.Info("Resuming copy", "src", src, "dest", dest, "offset", offset)
+	}
+	if err := partFile.Truncate(offset); err != nil {
+		return errors.Wrapf(err, "truncating partial copy %q", part)
+	}
+	if _, err := srcFile.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "seeking source %q", src)
+	}
+	if _, err := partFile.Seek(offset, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "seeking partial copy %q", part)
+	}
+
+	if _, err := io.CopyBuffer(struct{ io.Writer }{partFile}, srcFile, copyBuffer(srcFile, bufSize)); err != nil {
+		return errors.Wrapf(err, "copying %q to %q", src, part)
+	}
+	if err := partFile.Sync(); err != nil {
+		return errors.Wrapf(err, "syncing partial copy %q", part)
+	}
+	if err := partFile.Close(); err != nil {
+		return errors.Wrapf(err, "closing partial copy %q", part)
+	}
+	return errors.Wrapf(os.Rename(part, dest), "renaming %q to %q", part, dest)
+}
+
+// resumeOffset returns the offset a partial copy can be resumed from: its
+// size when its last bytes match the source, or zero.
+func resumeOffset(src, part *os.File, logger *log.Logger) (int64, error) {
+	srcInfo, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	partInfo, err := part.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := partInfo.Size()
+	if size == 0 || size > srcInfo.Size() {
+		return 0, nil
+	}
+
+	check := int64(resumeCheckSize)
+	if size < check {
+		check = size
+	}
+	srcTail := make([]byte, check)
+	partTail := make([]byte, check)
+	if _, err := src.ReadAt(srcTail, size-check); err != nil {
+		return 0, err
+	}
+	if _, err := part.ReadAt(partTail, size-check); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(srcTail, partTail) {
+		logger.Warn("Partial copy does not match the source, starting over", "part", part.Name())
+		return 0, nil
+	}
+	return size, nil
+}
+
+// copyBuffer allocates the buffer for copying a file, no larger than the file
+// itself. A bufSize below one uses DefaultCopyBuffer.
+func copyBuffer(src *os.File, bufSize int) []byte {
+	if bufSize < 1 {
+		bufSize = DefaultCopyBuffer
+	}
+	if info, err := src.Stat(); err == nil && info.Size() < int64(bufSize) {
+		bufSize = int(info.Size()) + 1
+	}
+	return make([]byte, bufSize)
+}
+
+// fileSystem holds the filesystem operations that moving and copying a file
+// rely on, so they can be replaced, for instance to simulate a rename across
+// devices.
+type fileSystem interface {
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+	Open(name string) (*os.File, error)
+	Create(name string) (*os.File, error)
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// osFileSystem is the fileSystem of the operating system.
+type osFileSystem struct{}
+
+// Rename implements fileSystem.
+func (osFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Remove implements fileSystem.
+func (osFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Stat implements fileSystem.
+func (osFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Open implements fileSystem.
+func (osFileSystem) Open(name string) (*os.File, error) {
+	return os.Open(name)
+}
+
+// Create implements fileSystem.
+func (osFileSystem) Create(name string) (*os.File, error) {
+	return os.Create(name)
+}
+
+// MkdirAll implements fileSystem.
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// osFS is the fileSystem files are moved on.
+var osFS fileSystem = osFileSystem{}
+
+// renameFile moves src to dest on fsys, falling back to copying and removing
+// the source when they lie on different devices, logging to logger.
+func renameFile(fsys fileSystem, src, dest string, bufSize int, logger *log.Logger) error {
+	err := ensureDir(fsys, dest)
+	if err != nil {
+		return err
+	}
+
+	err = fsys.Rename(src, dest)
+	if errors.Is(err, syscall.EXDEV) {
+		return moveAcrossDevices(fsys, src, dest, bufSize, logger)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "renaming %q to %q", src, dest)
+	}
+
+	return nil
+}
+
+// moveAcrossDevices moves a file that cannot be renamed onto another device.
+// The source is only deleted once the copy has been verified, and any failure
+// leaves the source intact with no partial destination behind.
+func moveAcrossDevices(fsys fileSystem, src, dest string, bufSize int, logger *log.Logger) error {
+	logger.Debug("Copying across devices", "src", src, "dest", dest)
+	if err := copyFile(fsys, src, dest, bufSize); err != nil {
+		return err
+	}
+
+	logger.Debug("Verifying copy", "src", src, "dest", dest)
+	same, err := sameContent(fsys, src, dest)
+	if err == nil && !same {
+		err = errors.Errorf("copy of %q does not match the source", src)
+	}
+	if err != nil {
+		fsys.Remove(dest)
+		return err
+	}
+
+	logger.Debug("Removing source", "src", src)
+	return errors.Wrapf(fsys.Remove(src), "removing source %q", src)
+}
+
+// sameContent reports whether two files on fsys have the same size and
+// checksum.
+func sameContent(fsys fileSystem, a, b string) (bool, error) {
+	aInfo, err := fsys.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bInfo, err := fsys.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if aInfo.Size() != bInfo.Size() {
+		return false, nil
+	}
+
+	aSum, err := hashFile(fsys, a)
+	if err != nil {
+		return false, err
+	}
+	bSum, err := hashFile(fsys, b)
+	if err != nil {
+		return false, err
+	}
+	return aSum == bSum, nil
+}
+
+// hashFile returns the hex encoded SHA-256 checksum of the contents of a file
+// on fsys.
+func hashFile(fsys fileSystem, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %q for hashing", path)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "hashing %q", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// trash keeps files that would otherwise be overwritten or deleted, under a
+// subdirectory of the trash directory named after the time of the run.
+type trash struct {
+	dir     string
+	root    string
+	bufSize int
+}
+
+// newTrash returns the trash configured in opts, or nil when files should be
+// replaced for good.
+func newTrash(opts Options) *trash {
+	if opts.TrashDir == "" {
+		return nil
+	}
+	return &trash{
+		dir:     filepath.Join(opts.TrashDir, time.Now().Format("20060102-150405")),
+		root:    opts.Dest,
+		bufSize: opts.CopyBuffer,
+	}
+}
+
+// put moves a file into the trash, preserving its path relative to the
+// destination root, or its absolute path when it lies outside of it. It logs
+// to logger.
+func (t *trash) put(path string, logger *log.Logger) error {
+	rel, err := filepath.Rel(t.root, path)
+	if t.root == "" || err != nil || strings.HasPrefix(rel, "..") {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel = strings.TrimPrefix(abs, filepath.VolumeName(abs))
+	}
+	dest := filepath.Join(t.dir, rel)
+	logger.Info("Moving file to trash", "src", path, "dest", dest)
+	return renameFile(osFS, path, dest, t.bufSize, logger)
+}
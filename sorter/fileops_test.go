@@ -0,0 +1,488 @@
+package sorter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// crossDeviceFS is the operating system's fileSystem, except that renames
+// fail as they do across devices. It records the files created, opened,
+// stat'ed and removed.
+type crossDeviceFS struct {
+	osFileSystem
+	created, opened, stated, removed []string
+}
+
+func (fsys *crossDeviceFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+}
+
+func (fsys *crossDeviceFS) Create(name string) (*os.File, error) {
+	fsys.created = append(fsys.created, name)
+	return fsys.osFileSystem.Create(name)
+}
+
+func (fsys *crossDeviceFS) Open(name string) (*os.File, error) {
+	fsys.opened = append(fsys.opened, name)
+	return fsys.osFileSystem.Open(name)
+}
+
+func (fsys *crossDeviceFS) Stat(name string) (os.FileInfo, error) {
+	fsys.stated = append(fsys.stated, name)
+	return fsys.osFileSystem.Stat(name)
+}
+
+func (fsys *crossDeviceFS) Remove(name string) error {
+	fsys.removed = append(fsys.removed, name)
+	return fsys.osFileSystem.Remove(name)
+}
+
+// contains reports whether paths holds path.
+func contains(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRenameFileAcrossDevices(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	dest := filepath.Join(dir, "dest", "2023", "05", "IMG_0001.jpg")
+	content := []byte("not really a JPEG")
+	if err := os.MkdirAll(filepath.Dir(src), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(src, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := &crossDeviceFS{}
+	if err := renameFile(fsys, src, dest, 0, log.Default()); err != nil {
+		t.Fatalf("renameFile() error = %v", err)
+	}
+
+	if !contains(fsys.created, dest) {
+		t.Errorf("destination was not copied, created %v", fsys.created)
+	}
+	if !contains(fsys.stated, src) || !contains(fsys.stated, dest) {
+		t.Errorf("copy was not verified, stat'ed %v", fsys.stated)
+	}
+	if len(fsys.removed) != 1 || fsys.removed[0] != src {
+		t.Errorf("removed %v, want only the source %q", fsys.removed, src)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source still exists, stat error = %v", err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatalf("reading destination: %v", err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("destination holds %q, want %q", got, content)
+	}
+}
+
+func TestRenameFileAcrossDevicesMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "missing.jpg")
+	dest := filepath.Join(dir, "dest", "missing.jpg")
+
+	fsys := &crossDeviceFS{}
+	if err := renameFile(fsys, src, dest, 0, log.Default()); err == nil {
+		t.Fatal("renameFile() error = nil, want an error for a missing source")
+	}
+	if len(fsys.removed) != 0 {
+		t.Errorf("removed %v after a failed copy", fsys.removed)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("destination exists after a failed copy, stat error = %v", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	src := writeTestFile(t, 3*1000+17)
+	for _, bufSize := range []int{0, 1000, DefaultCopyBuffer} {
+		dest := filepath.Join(t.TempDir(), "copy", "VID_0001.mp4")
+		if err := copyFile(osFS, src, dest, bufSize); err != nil {
+			t.Fatalf("copyFile() with a %d byte buffer error = %v", bufSize, err)
+		}
+		want, _ := os.ReadFile(src)
+		if got, _ := os.ReadFile(dest); !bytes.Equal(got, want) {
+			t.Errorf("copyFile() with a %d byte buffer copied %d bytes, want the %d bytes of the source", bufSize, len(got), len(want))
+		}
+	}
+}
+
+func BenchmarkCopyFile(b *testing.B) {
+	const size = 64 << 20
+	src := writeTestFile(b, size)
+	dest := filepath.Join(b.TempDir(), "VID_0001.mp4")
+	for _, bufSize := range []int{32 << 10, 256 << 10, DefaultCopyBuffer, 8 << 20} {
+		b.Run(fmt.Sprintf("buffer=%dKiB", bufSize>>10), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if err := copyFile(osFS, src, dest, bufSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// corruptingFS moves across devices as crossDeviceFS does, but the copies it
+// creates are corrupted once written, keeping their size.
+type corruptingFS struct {
+	crossDeviceFS
+}
+
+func (fsys *corruptingFS) Stat(name string) (os.FileInfo, error) {
+	if contains(fsys.created, name) {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(name, bytes.Repeat([]byte("x"), int(info.Size())), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return fsys.crossDeviceFS.Stat(name)
+}
+
+func TestRenameFileAcrossDevicesCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	dest := filepath.Join(dir, "dest", "IMG_0001.jpg")
+	writeFiles(t, src)
+
+	fsys := &corruptingFS{}
+	err := renameFile(fsys, src, dest, 0, log.Default())
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("renameFile() error = %v, want a mismatch", err)
+	}
+	if content, err := os.ReadFile(src); err != nil || string(content) != src {
+		t.Errorf("source = %q, %v after a failed move, want it intact", content, err)
+	}
+	if fileExists(dest) {
+		t.Error("the corrupted copy was left behind")
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a": "same content", "b": "same content", "c": "other content", "d": "same c0ntent"}
+	for name, content := range files {
+		writeFile(t, filepath.Join(dir, name), content)
+	}
+	tests := []struct {
+		a, b string
+		want bool
+		err  bool
+	}{
+		{"a", "b", true, false},
+		{"a", "c", false, false},
+		{"a", "d", false, false},
+		{"a", "missing", false, true},
+	}
+	for _, tt := range tests {
+		got, err := sameContent(osFS, filepath.Join(dir, tt.a), filepath.Join(dir, tt.b))
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("sameContent(%s, %s) = %v, %v, want %v, error %v", tt.a, tt.b, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	writeFile(t, src, "new shot")
+	dest := filepath.Join(dir, "dest", "IMG_0001.jpg")
+	numbered := func(n int) string { return filepath.Join(dir, "dest", fmt.Sprintf("IMG_0001_%d.jpg", n)) }
+
+	tests := []struct {
+		name     string
+		existing map[string]string
+		policy   string
+		want     string
+		reason   string
+	}{
+		{"free", nil, ConflictRename, dest, ""},
+		{"identical", map[string]string{dest: "new shot"}, ConflictSkip, dest, ReasonIdentical},
+		{"rename", map[string]string{dest: "old shot"}, ConflictRename, numbered(1), ""},
+		{"rename past taken names", map[string]string{dest: "old shot", numbered(1): "other shot"}, ConflictRename, numbered(2), ""},
+		// A numbered copy from an earlier run is recognized
+		{"identical numbered", map[string]string{dest: "old shot", numbered(1): "new shot"}, ConflictRename, numbered(1), ReasonIdentical},
+		{"skip", map[string]string{dest: "old shot"}, ConflictSkip, dest, ReasonConflict},
+		{"overwrite", map[string]string{dest: "old shot"}, ConflictOverwrite, dest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.RemoveAll(filepath.Join(dir, "dest"))
+			for path, content := range tt.existing {
+				writeFile(t, path, content)
+			}
+			got, reason, err := resolveConflict(LocalStorage{}, src, dest, tt.policy, DefaultConflictSuffix, map[string]string{}, log.Default())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || reason != tt.reason {
+				t.Errorf("resolveConflict() = %q, %q, want %q, %q", got, reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func TestResolveConflictSuffix(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	writeFile(t, src, "new shot")
+	dest := filepath.Join(dir, "dest", "IMG_0001.jpg")
+	writeFile(t, dest, "old shot")
+	writeFile(t, filepath.Join(dir, "dest", "IMG_0001 (1).jpg"), "other shot")
+
+	tests := []struct {
+		suffix string
+		want   string
+	}{
+		{" (%d)", "IMG_0001 (2).jpg"},
+		{".%03d", "IMG_0001.001.jpg"},
+		{"-v%d", "IMG_0001-v1.jpg"},
+	}
+	for _, tt := range tests {
+		got, reason, err := resolveConflict(LocalStorage{}, src, dest, ConflictRename, tt.suffix, map[string]string{}, log.Default())
+		if err != nil || reason != "" || got != filepath.Join(dir, "dest", tt.want) {
+			t.Errorf("resolveConflict() with suffix %q = %q, %q, %v, want %s", tt.suffix, got, reason, err, tt.want)
+		}
+	}
+}
+
+func TestValidateConflictSuffix(t *testing.T) {
+	tests := []struct {
+		suffix string
+		valid  bool
+	}{
+		{DefaultConflictSuffix, true},
+		{" (%d)", true},
+		{".%03d", true},
+		{"_copy", false},
+		{"_%s", false},
+		{"_%d_%d", false},
+		{"/%d", false},
+		{`\%d`, false},
+	}
+	for _, tt := range tests {
+		if err := validateConflictSuffix(tt.suffix); (err == nil) != tt.valid {
+			t.Errorf("validateConflictSuffix(%q) error = %v, want valid %v", tt.suffix, err, tt.valid)
+		}
+	}
+	opts := Options{OnConflict: ConflictRename, DatePrefer: PreferExif, ConflictSuffix: "_copy"}
+	if err := opts.Validate(); err == nil {
+		t.Error("Validate() accepted a conflict suffix without a number")
+	}
+}
+
+func TestExecuteOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src", "IMG_0001.jpg"), filepath.Join(dir, "dest", "IMG_0001.jpg")
+	writeFile(t, src, "new shot")
+	writeFile(t, dest, "old shot")
+	plan := []PlanEntry{{Src: src, Dest: dest, Action: ActionCopy, Overwrite: true}}
+	if err := Execute(plan, Options{}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(dest); string(content) != "new shot" {
+		t.Errorf("destination = %q, want it overwritten", content)
+	}
+}
+
+func TestExecuteOverwriteTrash(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src", "IMG_0001.jpg"), filepath.Join(dir, "dest", "2023", "IMG_0001.jpg")
+	trashDir := filepath.Join(dir, "trash")
+	writeFile(t, src, "new shot")
+	writeFile(t, dest, "old shot")
+	plan := []PlanEntry{{Src: src, Dest: dest, Action: ActionCopy, Overwrite: true}}
+	if err := Execute(plan, Options{Dest: filepath.Join(dir, "dest"), TrashDir: trashDir}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(dest); string(content) != "new shot" {
+		t.Errorf("destination = %q, want it overwritten", content)
+	}
+
+	// The replaced file is kept under the run's subdirectory of the trash
+	runs, err := os.ReadDir(trashDir)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("trash holds %v (err %v), want one run", runs, err)
+	}
+	trashed := filepath.Join(trashDir, runs[0].Name(), "2023", "IMG_0001.jpg")
+	if content, _ := os.ReadFile(trashed); string(content) != "old shot" {
+		t.Errorf("trashed file = %q, want the replaced file", content)
+	}
+}
+
+func TestTrashOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "elsewhere", "IMG_0001.jpg")
+	writeFiles(t, path)
+	bin := &trash{dir: filepath.Join(dir, "trash"), root: filepath.Join(dir, "dest")}
+	if err := bin.put(path, log.Default()); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+	trashed := filepath.Join(bin.dir, strings.TrimPrefix(path, filepath.VolumeName(path)))
+	if !fileExists(trashed) || fileExists(path) {
+		t.Errorf("file not moved to %s", trashed)
+	}
+}
+
+func TestExecuteCopyWorkers(t *testing.T) {
+	dir := t.TempDir()
+	var plan []PlanEntry
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("IMG_%04d.jpg", i)
+		src := filepath.Join(dir, "src", name)
+		writeFiles(t, src)
+		plan = append(plan, PlanEntry{Src: src, Dest: filepath.Join(dir, "dest", name), Action: ActionMove})
+	}
+	stats := NewStats()
+	if err := Execute(plan, Options{CopyWorkers: 4}, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sorted != len(plan) {
+		t.Errorf("sorted %d files, want %d", stats.Sorted, len(plan))
+	}
+	for _, entry := range plan {
+		if content, _ := os.ReadFile(entry.Dest); string(content) != entry.Src {
+			t.Errorf("%s = %q, want the content of %s", entry.Dest, content, entry.Src)
+		}
+	}
+}
+
+func TestSamePath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "IMG_0001.jpg")
+	other := filepath.Join(dir, "IMG_0002.jpg")
+	writeFiles(t, path, other)
+	link := filepath.Join(dir, "linked.jpg")
+	if err := os.Link(path, link); err != nil {
+		t.Skip("hard links unsupported:", err)
+	}
+	tests := []struct {
+		dest string
+		want bool
+	}{
+		{path, true},
+		{filepath.Join(dir, ".", "sub", "..", "IMG_0001.jpg"), true},
+		{link, true},
+		{other, false},
+		{filepath.Join(dir, "missing.jpg"), false},
+	}
+	for _, tt := range tests {
+		if got := samePath(path, tt.dest); got != tt.want {
+			t.Errorf("samePath(%q, %q) = %v, want %v", path, tt.dest, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2023", "05", "IMG_0001.jpg")
+	writeFile(t, path, "shot")
+	stats := NewStats()
+	plan := []PlanEntry{{Src: path, Dest: path, Action: ActionCopy}}
+	if err := Execute(plan, Options{CopyWorkers: 1}, stats); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "shot" {
+		t.Errorf("file = %q after sorting it onto itself", content)
+	}
+	if stats.InPlace != 1 || stats.Sorted != 0 {
+		t.Errorf("%d in place and %d sorted, want 1 and 0", stats.InPlace, stats.Sorted)
+	}
+}
+
+func TestBuildPlanInPlace(t *testing.T) {
+	dest := t.TempDir()
+	path := filepath.Join(dest, "2023", "05", "IMG_0001.jpg")
+	writeFiles(t, path)
+	files := []mediaFile{{path: path, date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}}
+	plan, err := buildPlan(files, Options{Src: dest, Dest: dest, FolderFormat: "2006/01", OnConflict: ConflictRename}, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := plan[0]; entry.Dest != path || entry.Action != ActionSkip || entry.Reason != ReasonInPlace {
+		t.Errorf("planned %s to %q (%s), want it left in place", entry.Action, entry.Dest, entry.Reason)
+	}
+}
+
+func TestCopyFileErrorContext(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "IMG_0001.jpg")
+	blocker := filepath.Join(dir, "blocker")
+	writeFiles(t, src, blocker)
+	tests := []struct {
+		name string
+		src  string
+		dest string
+		want string
+	}{
+		{"missing source", filepath.Join(dir, "missing.jpg"), filepath.Join(dir, "dest", "IMG_0001.jpg"), fmt.Sprintf("opening source %q", filepath.Join(dir, "missing.jpg"))},
+		{"directory over a file", src, filepath.Join(blocker, "2023", "IMG_0001.jpg"), fmt.Sprintf("creating directory %q", filepath.Join(blocker, "2023"))},
+		{"destination is a directory", src, dir, fmt.Sprintf("creating destination %q", dir)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := copyFile(osFS, tt.src, tt.dest, 0)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want+": ") {
+				t.Errorf("copyFile() error = %v, want it to start with %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCopyFileResumable(t *testing.T) {
+	src := writeTestFile(t, 5000)
+	content, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		part    []byte
+		resumed bool
+	}{
+		{"no partial copy", nil, false},
+		{"matching partial copy", content[:3000], true},
+		{"corrupted partial copy", append(append([]byte(nil), content[:2999]...), content[2999]+1), false},
+		{"partial copy past the source", append(append([]byte(nil), content...), 'x'), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "VID_0001.mp4")
+			if tt.part != nil {
+				writeFile(t, dest+".part", string(tt.part))
+			}
+			var logged bytes.Buffer
+			if err := copyFileResumable(src, dest, 1000, log.New(&logged)); err != nil {
+				t.Fatalf("copyFileResumable() error = %v", err)
+			}
+			if got, _ := os.ReadFile(dest); !bytes.Equal(got, content) {
+				t.Errorf("copied %d bytes, want the %d bytes of the source", len(got), len(content))
+			}
+			if fileExists(dest + ".part") {
+				t.Error("the partial copy was kept after finishing")
+			}
+			if resumed := strings.Contains(logged.String(), "Resuming copy"); resumed != tt.resumed {
+				t.Errorf("resumed = %v, want %v: %s", resumed, tt.resumed, logged.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package sorter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FolderSummary describes the files a run sorted into a folder. Name is the
+// folder relative to the destination root.
+type FolderSummary struct {
+	Dir   string    `json:"-"`
+	Name  string    `json:"name"`
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Files int       `json:"files"`
+}
+
+// FolderMetadata writes a metadata file into each folder a run sorted files
+// into, for galleries to show folder level information without rescanning.
+type FolderMetadata interface {
+	Write(summary FolderSummary) error
+}
+
+// FolderMetadataFormats are the folder metadata formats available by name.
+var FolderMetadataFormats = map[string]FolderMetadata{
+	"json":   JSONFolderMetadata{},
+	"picasa": PicasaFolderMetadata{},
+}
+
+// JSONFolderMetadata writes the summary as .folder.json, merged with the
+// summary of earlier runs.
+type JSONFolderMetadata struct{}
+
+// Write implements FolderMetadata.
+func (JSONFolderMetadata) Write(summary FolderSummary) error {
+	path := filepath.Join(summary.Dir, ".folder.json")
+	var previous FolderSummary
+	if data, err := os.ReadFile(path); err == nil && json.Unmarshal(data, &previous) == nil && previous.Files > 0 {
+		if previous.From.Before(summary.From) {
+			summary.From = previous.From
+		}
+		if previous.To.After(summary.To) {
+			summary.To = previous.To
+		}
+		summary.Files += previous.Files
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(path, append(data, '\n'), 0o644))
+}
+
+// PicasaFolderMetadata writes a .picasa.ini naming the folder and dating it
+// by its earliest file.
+type PicasaFolderMetadata struct{}
+
+// Write implements FolderMetadata.
+func (PicasaFolderMetadata) Write(summary FolderSummary) error {
+	// Picasa dates count days since 1899-12-30, as OLE automation dates do
+	days := summary.From.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, summary.From.Location())).Hours() / 24
+	ini := fmt.Sprintf("[Picasa]\nname=%s\ndate=%.6f\n", summary.Name, days)
+	return errors.WithStack(os.WriteFile(filepath.Join(summary.Dir, ".picasa.ini"), []byte(ini), 0o644))
+}
+
+// add counts a file of the given date into the summary.
+func (s *FolderSummary) add(date time.Time) {
+	if s.Files == 0 || date.Before(s.From) {
+		s.From = date
+	}
+	if s.Files == 0 || date.After(s.To) {
+		s.To = date
+	}
+	s.Files++
+}
+
+// folderTracker collects the folders files are sorted into, from any
+// goroutine.
+type folderTracker struct {
+	mu      sync.Mutex
+	folders map[string]*FolderSummary
+}
+
+// track records a file of the given date sorted to dest.
+func (t *folderTracker) track(dest string, date time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dir := filepath.Dir(dest)
+	if t.folders == nil {
+		t.folders = make(map[string]*FolderSummary)
+	}
+	if t.folders[dir] == nil {
+		t.folders[dir] = &FolderSummary{Dir: dir}
+	}
+	t.folders[dir].add(date)
+}
+
+// summaries returns the summary of every tracked folder, sorted by folder,
+// named relative to root.
+func (t *folderTracker) summaries(root string) []FolderSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var summaries []FolderSummary
+	for _, summary := range t.folders {
+		summary.Name = filepath.Base(summary.Dir)
+		if rel, err := filepath.Rel(root, summary.Dir); root != "" && err == nil && !strings.HasPrefix(rel, "..") {
+			summary.Name = filepath.ToSlash(rel)
+		}
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Dir < summaries[j].Dir })
+	return summaries
+}
@@ -0,0 +1,98 @@
+package sorter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFolderTracker(t *testing.T) {
+	root := "dest"
+	may := filepath.Join(root, "2023", "05")
+	june := filepath.Join(root, "2023", "06")
+	first := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	last := time.Date(2023, 5, 20, 8, 0, 0, 0, time.UTC)
+	summer := time.Date(2023, 6, 21, 18, 0, 0, 0, time.UTC)
+
+	var tracker folderTracker
+	tracker.track(filepath.Join(may, "IMG_0002.jpg"), last)
+	tracker.track(filepath.Join(june, "IMG_0003.jpg"), summer)
+	tracker.track(filepath.Join(may, "IMG_0001.jpg"), first)
+	tracker.track(filepath.Join("elsewhere", "IMG_0004.jpg"), summer)
+
+	want := []FolderSummary{
+		{Dir: may, Name: "2023/05", From: first, To: last, Files: 2},
+		{Dir: june, Name: "2023/06", From: summer, To: summer, Files: 1},
+		{Dir: "elsewhere", Name: "elsewhere", From: summer, To: summer, Files: 1},
+	}
+	if got := tracker.summaries(root); !reflect.DeepEqual(got, want) {
+		t.Errorf("summaries() = %+v, want %+v", got, want)
+	}
+}
+
+func TestJSONFolderMetadata(t *testing.T) {
+	dir := t.TempDir()
+	first := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	last := time.Date(2023, 5, 20, 8, 0, 0, 0, time.UTC)
+	if err := (JSONFolderMetadata{}).Write(FolderSummary{Dir: dir, Name: "2023/05", From: last, To: last, Files: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later run into the same folder extends the earlier summary
+	if err := (JSONFolderMetadata{}).Write(FolderSummary{Dir: dir, Name: "2023/05", From: first, To: first, Files: 2}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".folder.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got FolderSummary
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := FolderSummary{Name: "2023/05", From: first, To: last, Files: 3}
+	if !got.From.Equal(want.From) || !got.To.Equal(want.To) || got.Name != want.Name || got.Files != want.Files {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+}
+
+func TestPicasaFolderMetadata(t *testing.T) {
+	dir := t.TempDir()
+	from := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := (PicasaFolderMetadata{}).Write(FolderSummary{Dir: dir, Name: "2023/05", From: from, To: from, Files: 1}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".picasa.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "[Picasa]\nname=2023/05\ndate=45047.500000\n"; string(data) != want {
+		t.Errorf(".picasa.ini = %q, want %q", data, want)
+	}
+}
+
+func TestExecuteFolderMetadata(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dest")
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	skipped := filepath.Join(dir, "src", "IMG_0002.jpg")
+	writeFiles(t, src, skipped)
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	plan := []PlanEntry{
+		{Src: src, Dest: filepath.Join(dest, "2023", "05", "IMG_0001.jpg"), Action: ActionCopy, Date: date},
+		{Src: skipped, Dest: filepath.Join(dest, "2023", "06", "IMG_0002.jpg"), Action: ActionSkip, Reason: ReasonExists},
+	}
+	opts := Options{Dest: dest, FolderMetadata: PicasaFolderMetadata{}, CopyWorkers: 1}
+	if err := Execute(plan, opts, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(dest, "2023", "05", ".picasa.ini")) {
+		t.Error("no metadata in the folder files were sorted into")
+	}
+	if fileExists(filepath.Join(dest, "2023", "06", ".picasa.ini")) {
+		t.Error("metadata written for a folder the run did not touch")
+	}
+}
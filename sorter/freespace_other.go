@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package sorter
+
+import (
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// freeSpace returns the bytes available on the filesystem holding path,
+// which is not supported on this platform yet.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.Errorf("free space is not available on %s", runtime.GOOS)
+}
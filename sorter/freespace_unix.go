@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package sorter
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// freeSpace returns the bytes available to unprivileged users on the
+// filesystem holding path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
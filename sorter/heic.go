@@ -0,0 +1,65 @@
+package sorter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// heicConverters are the commands tried in order to convert a HEIC image to
+// JPEG, given the source and destination paths.
+var heicConverters = [][]string{
+	{"heif-convert", "-q", "92"},
+	{"magick"},
+	{"convert"},
+}
+
+// isHEIC reports whether the file at path is a HEIC or HEIF image.
+func isHEIC(path string) bool {
+	ext := fileExt(path)
+	return ext == ".heic" || ext == ".heif"
+}
+
+// transcodeHEIC writes the HEIC image src to dest as a JPEG, with the first
+// converter installed, and copies every tag of src over, dates included. The
+// source is only read.
+func transcodeHEIC(src, dest string, opts Options) error {
+	if err := ensureDir(osFS, dest); err != nil {
+		return err
+	}
+	var convert []string
+	for _, converter := range heicConverters {
+		if _, err := exec.LookPath(converter[0]); err == nil {
+			convert = converter
+			break
+		}
+	}
+	if convert == nil {
+		return errors.New("no HEIC converter found, install libheif's heif-convert or ImageMagick")
+	}
+	args := append(append([]string{}, convert[1:]...), src, dest)
+	if out, err := exec.Command(convert[0], args...).CombinedOutput(); err != nil {
+		os.Remove(dest)
+		return errors.Wrapf(err, "converting %q with %s: %s", src, convert[0], strings.TrimSpace(string(out)))
+	}
+
+	out, err := exec.Command(exiftoolBinary(opts), "-q", "-overwrite_original", "-TagsFromFile", src, "-all:all", dest).CombinedOutput()
+	if err != nil {
+		os.Remove(dest)
+		return errors.Wrapf(err, "copying tags of %q: %s", src, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// keepHEIC copies the HEIC original of a transcoded entry next to its JPEG,
+// unless a file is already there.
+func keepHEIC(entry PlanEntry, bufSize int) error {
+	dest := strings.TrimSuffix(entry.Dest, filepath.Ext(entry.Dest)) + filepath.Ext(entry.Src)
+	if fileExists(dest) {
+		return errors.Errorf("%q already exists", dest)
+	}
+	return copyFile(osFS, entry.Src, dest, bufSize)
+}
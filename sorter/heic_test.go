@@ -0,0 +1,91 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildPlanTranscodeHEIC(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	paths := []string{filepath.Join(src, "IMG_0001.HEIC"), filepath.Join(src, "IMG_0002.jpg")}
+	writeFiles(t, paths...)
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := []mediaFile{{path: paths[0], date: date}, {path: paths[1], date: date}}
+
+	opts := Options{Src: src, Dest: dest, Copy: true, TranscodeHEIC: true, FolderFormat: "2006", OnConflict: ConflictRename}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{filepath.Join(dest, "2023", "IMG_0001.jpg"), filepath.Join(dest, "2023", "IMG_0002.jpg")})
+	if !plan[0].Transcode || plan[1].Transcode {
+		t.Errorf("transcoded = %v, %v, want only the HEIC image", plan[0].Transcode, plan[1].Transcode)
+	}
+
+	// Moved images are never transcoded, as that would lose the original
+	opts.Copy = false
+	plan, err = buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{filepath.Join(dest, "2023", "IMG_0001.HEIC"), filepath.Join(dest, "2023", "IMG_0002.jpg")})
+	if plan[0].Transcode {
+		t.Error("moved HEIC image is transcoded")
+	}
+}
+
+func TestExecuteTranscodeHEIC(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub converter is a shell script")
+	}
+	bin := t.TempDir()
+	writeFile(t, filepath.Join(bin, "heif-convert"), "#!/bin/sh\necho converted > \"$4\"\n")
+	exiftool := filepath.Join(bin, "exiftool")
+	writeFile(t, exiftool, "#!/bin/sh\necho \"$@\" > \"$0.args\"\n")
+	for _, name := range []string{"heif-convert", "exiftool"} {
+		if err := os.Chmod(filepath.Join(bin, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.HEIC")
+	writeFiles(t, src)
+	dest := filepath.Join(dir, "dest", "2023", "IMG_0001.jpg")
+	plan := []PlanEntry{{Src: src, Dest: dest, Action: ActionCopy, Transcode: true}}
+	stats := NewStats()
+	if err := Execute(plan, Options{ExiftoolPath: exiftool, KeepHEIC: true, CopyWorkers: 1}, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sorted != 1 || stats.Failed != 0 {
+		t.Errorf("sorted %d and failed %d files, want 1 sorted", stats.Sorted, stats.Failed)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "converted\n" {
+		t.Errorf("JPEG holds %q, want the converter's output", got)
+	}
+	args, err := os.ReadFile(exiftool + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-TagsFromFile " + src + " -all:all " + dest; !strings.Contains(string(args), want) {
+		t.Errorf("exiftool ran with %q, want the tags copied with %q", args, want)
+	}
+
+	// The original is kept next to the JPEG, and left in the source
+	kept := filepath.Join(dir, "dest", "2023", "IMG_0001.HEIC")
+	if got, _ := os.ReadFile(kept); string(got) != src {
+		t.Errorf("kept original holds %q, want %q", got, src)
+	}
+	if !fileExists(src) {
+		t.Error("source removed by a copy")
+	}
+	if err := keepHEIC(plan[0], 0); err == nil {
+		t.Error("keepHEIC() overwrote the kept original")
+	}
+}
@@ -0,0 +1,73 @@
+package sorter
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Ways to handle the IMG_E edited copies iPhones save next to IMG_ originals.
+const (
+	// EditsTogether sorts an edited copy by the date of its original, so the
+	// two always land in the same folder.
+	EditsTogether = "together"
+	// EditsPreferEdited sorts the edited copy with the date of its original
+	// and skips the original.
+	EditsPreferEdited = "prefer-edited"
+	// EditsSuffix sorts the edited copy with the date of its original and
+	// names it after the original with an _edited suffix.
+	EditsSuffix = "suffix"
+)
+
+// ReasonSuperseded skips an original superseded by its edited copy.
+const ReasonSuperseded = "superseded"
+
+// iphoneEditedRegex matches the name of an iPhone edited copy, such as
+// IMG_E1234.JPG, capturing the number it shares with its original.
+var iphoneEditedRegex = regexp.MustCompile(`(?i)^IMG_E(\d+)$`)
+
+// validateEdits checks an iPhone edits policy.
+func validateEdits(policy string) error {
+	switch policy {
+	case "", EditsTogether, EditsPreferEdited, EditsSuffix:
+		return nil
+	}
+	return errors.Errorf("unknown iPhone edits policy %q", policy)
+}
+
+// pairIphoneEdits returns the index of the original of every edited copy
+// among files, keyed by the index of the edited copy. Copies are paired with
+// the original of the same number and extension in the same directory.
+func pairIphoneEdits(files []mediaFile) map[int]int {
+	originals := make(map[string]int)
+	for i, file := range files {
+		originals[strings.ToLower(file.path)] = i
+	}
+
+	pairs := make(map[int]int)
+	for i, file := range files {
+		ext := filepath.Ext(file.path)
+		matches := iphoneEditedRegex.FindStringSubmatch(strings.TrimSuffix(filepath.Base(file.path), ext))
+		if matches == nil {
+			continue
+		}
+		original := filepath.Join(filepath.Dir(file.path), "IMG_"+matches[1]+ext)
+		if j, ok := originals[strings.ToLower(original)]; ok {
+			pairs[i] = j
+		}
+	}
+	return pairs
+}
+
+// editedName returns the name of an edited copy under the EditsSuffix
+// policy: IMG_E1234.JPG becomes IMG_1234_edited.JPG.
+func editedName(name string) string {
+	ext := filepath.Ext(name)
+	matches := iphoneEditedRegex.FindStringSubmatch(strings.TrimSuffix(name, ext))
+	if matches == nil {
+		return name
+	}
+	return name[:len("IMG_")] + matches[1] + "_edited" + ext
+}
@@ -0,0 +1,107 @@
+package sorter
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPairIphoneEdits(t *testing.T) {
+	files := []mediaFile{
+		{path: "src/a/IMG_0001.JPG"},
+		{path: "src/a/IMG_E0001.JPG"},
+		{path: "src/a/img_e0002.heic"},
+		{path: "src/a/IMG_0002.HEIC"},
+		// Edits pair with the original of the same directory and extension
+		{path: "src/b/IMG_E0001.JPG"},
+		{path: "src/a/IMG_E0001.MOV"},
+		{path: "src/a/IMG_E0003.JPG"},
+		{path: "src/a/IMG_EDIT.JPG"},
+	}
+	want := map[int]int{1: 0, 2: 3}
+	if got := pairIphoneEdits(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("pairIphoneEdits() = %v, want %v", got, want)
+	}
+}
+
+func TestEditedName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"IMG_E1234.JPG", "IMG_1234_edited.JPG"},
+		{"img_e1234.heic", "img_1234_edited.heic"},
+		{"IMG_1234.JPG", "IMG_1234.JPG"},
+		{"IMG_EDIT.JPG", "IMG_EDIT.JPG"},
+	}
+	for _, tt := range tests {
+		if got := editedName(tt.name); got != tt.want {
+			t.Errorf("editedName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildPlanIphoneEdits(t *testing.T) {
+	type want struct {
+		dest   string
+		reason string
+	}
+	tests := []struct {
+		policy string
+		want   []want
+	}{
+		{"", []want{
+			{"2023/05/IMG_0001.JPG", ""},
+			{"2023/06/IMG_E0001.JPG", ""},
+			{"2023/06/IMG_E0002.JPG", ""},
+		}},
+		{EditsTogether, []want{
+			{"2023/05/IMG_0001.JPG", ""},
+			{"2023/05/IMG_E0001.JPG", ""},
+			{"2023/06/IMG_E0002.JPG", ""},
+		}},
+		{EditsPreferEdited, []want{
+			{"2023/05/IMG_0001.JPG", ReasonSuperseded},
+			{"2023/05/IMG_E0001.JPG", ""},
+			{"2023/06/IMG_E0002.JPG", ""},
+		}},
+		// Only edits paired with their original are renamed
+		{EditsSuffix, []want{
+			{"2023/05/IMG_0001.JPG", ""},
+			{"2023/05/IMG_0001_edited.JPG", ""},
+			{"2023/06/IMG_E0002.JPG", ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+			paths := []string{filepath.Join(src, "IMG_0001.JPG"), filepath.Join(src, "IMG_E0001.JPG"), filepath.Join(src, "IMG_E0002.JPG")}
+			writeFiles(t, paths...)
+
+			// The edits were saved a month after the shots
+			taken := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+			edited := time.Date(2023, 6, 2, 12, 0, 0, 0, time.UTC)
+			files := []mediaFile{{path: paths[0], date: taken}, {path: paths[1], date: edited}, {path: paths[2], date: edited}}
+			opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01", OnConflict: ConflictRename, IphoneEdits: tt.policy}
+			plan, err := buildPlan(files, opts, NewStats())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plan) != len(tt.want) {
+				t.Fatalf("planned %d entries, want %d", len(plan), len(tt.want))
+			}
+			for i, entry := range plan {
+				want := tt.want[i]
+				action := ActionCopy
+				if want.reason != "" {
+					action = ActionSkip
+				}
+				if entry.Dest != filepath.Join(dest, filepath.FromSlash(want.dest)) || entry.Action != action || entry.Reason != want.reason {
+					t.Errorf("%s: %s to %q (%s), want %s to %q (%s)", filepath.Base(entry.Src), entry.Action, entry.Dest, entry.Reason, action, want.dest, want.reason)
+				}
+			}
+		})
+	}
+}
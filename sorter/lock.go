@@ -0,0 +1,68 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// LockName is the name of the lock file held in the destination directory
+// while a sorter works on it.
+const LockName = ".exif-sorter.lock"
+
+// lockPollInterval is how often a waiting sorter retries taking the lock.
+const lockPollInterval = time.Second
+
+// ErrLocked is returned when another sorter holds the destination lock.
+var ErrLocked = errors.New("destination is locked by another sorter")
+
+// Lock is a lock file held on a destination directory.
+type Lock struct {
+	path string
+}
+
+// AcquireLock takes the lock on a destination directory, creating the
+// directory when needed. When the lock is held by another sorter it waits for
+// it to be released if wait is set, or returns ErrLocked otherwise.
+func AcquireLock(dir string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	path := filepath.Join(dir, LockName)
+	waiting := false
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			if err := f.Close(); err != nil {
+				os.Remove(path)
+				return nil, errors.WithStack(err)
+			}
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.WithStack(err)
+		}
+		if !wait {
+			return nil, ErrLocked
+		}
+		if !waiting {
+			log.Info("Waiting for another sorter to finish", "lock", path)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Release removes the lock file. Releasing a nil lock does nothing.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	return errors.WithStack(os.Remove(l.path))
+}
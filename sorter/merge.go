@@ -0,0 +1,70 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+)
+
+// folderIndex finds the files already in destination folders with the same
+// content as a source, whatever their name, so sorting into folders built by
+// hand merges into them instead of adding copies. Folders are listed once,
+// and their files only hashed when their size matches a source.
+type folderIndex struct {
+	sizes  map[string]map[int64][]string
+	hashes map[string]string
+}
+
+// newFolderIndex returns an empty index.
+func newFolderIndex() *folderIndex {
+	return &folderIndex{sizes: make(map[string]map[int64][]string), hashes: make(map[string]string)}
+}
+
+// lookup returns the file in the folder of dest with the content of src, of
+// the given size, if any.
+func (idx *folderIndex) lookup(src, dest string, size int64) (string, error) {
+	dir := filepath.Dir(dest)
+	sizes, ok := idx.sizes[dir]
+	if !ok {
+		sizes = make(map[int64][]string)
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || isHidden(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			sizes[info.Size()] = append(sizes[info.Size()], path)
+		}
+		idx.sizes[dir] = sizes
+	}
+	if len(sizes[size]) == 0 {
+		return "", nil
+	}
+
+	hash, err := hashFile(osFS, src)
+	if err != nil {
+		return "", err
+	}
+	for _, existing := range sizes[size] {
+		existingHash, ok := idx.hashes[existing]
+		if !ok {
+			if existingHash, err = hashFile(osFS, existing); err != nil {
+				log.Warn("Error while hashing destination file", "dest", existing, "err", err)
+				continue
+			}
+			idx.hashes[existing] = existingHash
+		}
+		if existingHash == hash {
+			return existing, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,60 @@
+package sorter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildPlanMergeExisting(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	month := filepath.Join(dest, "2023", "05")
+	writeFile(t, filepath.Join(month, "Beach day.jpg"), "beach")
+	writeFile(t, filepath.Join(month, ".Beach day.jpg"), "sunset")
+	paths := []string{filepath.Join(src, "IMG_0001.jpg"), filepath.Join(src, "IMG_0002.jpg"), filepath.Join(src, "IMG_0003.jpg")}
+	writeFile(t, paths[0], "beach")
+	// Same size as the existing file, different content
+	writeFile(t, paths[1], "party")
+	// Only in a hidden file, which is not merged into
+	writeFile(t, paths[2], "sunset")
+
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	var files []mediaFile
+	for _, path := range paths {
+		files = append(files, mediaFile{path: path, date: date, size: 5})
+	}
+	opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01", OnConflict: ConflictRename, MergeExisting: true}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		action string
+		reason string
+	}{
+		{ActionSkip, ReasonDuplicate},
+		{ActionCopy, ""},
+		{ActionCopy, ""},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("planned %d entries, want %d", len(plan), len(want))
+	}
+	for i, entry := range plan {
+		if entry.Action != want[i].action || entry.Reason != want[i].reason {
+			t.Errorf("%s: %s (%s), want %s (%s)", entry.Src, entry.Action, entry.Reason, want[i].action, want[i].reason)
+		}
+	}
+
+	// A file sorted earlier is in place, not a duplicate of itself
+	sorted := filepath.Join(month, "IMG_0004.jpg")
+	writeFile(t, sorted, "other")
+	opts.Src = dest
+	plan, err = buildPlan([]mediaFile{{path: sorted, date: date, size: 5}}, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 1 || plan[0].Reason != ReasonInPlace {
+		t.Errorf("plan = %+v, want the file skipped in place", plan)
+	}
+}
@@ -0,0 +1,62 @@
+package sorter
+
+import (
+	"io"
+	"sync"
+)
+
+// indexedEntry is a plan entry handed to a worker along with its position in
+// the plan.
+type indexedEntry struct {
+	index int
+	entry PlanEntry
+}
+
+// orderedLog writes the log lines of plan entries in plan order, holding back
+// those of entries finished before earlier ones.
+type orderedLog struct {
+	mu      sync.Mutex
+	w       io.Writer
+	next    int
+	pending map[int][]byte
+}
+
+// newOrderedLog returns an orderedLog writing to w.
+func newOrderedLog(w io.Writer) *orderedLog {
+	return &orderedLog{w: w, pending: make(map[int][]byte)}
+}
+
+// add records the log lines of the entry at index, and writes those of every
+// entry no earlier entry is missing for. Adding to a nil orderedLog does
+// nothing.
+func (o *orderedLog) add(index int, lines []byte) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending[index] = append([]byte(nil), lines...)
+	for {
+		lines, ok := o.pending[o.next]
+		if !ok {
+			return
+		}
+		o.w.Write(lines)
+		delete(o.pending, o.next)
+		o.next++
+	}
+}
+
+// close writes the lines still held back, of entries after one that never
+// ran.
+func (o *orderedLog) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.pending) > 0 {
+		if lines, ok := o.pending[o.next]; ok {
+			o.w.Write(lines)
+			delete(o.pending, o.next)
+		}
+		o.next++
+	}
+}
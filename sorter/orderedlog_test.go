@@ -0,0 +1,60 @@
+package sorter
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOrderedLog(t *testing.T) {
+	var buf bytes.Buffer
+	o := newOrderedLog(&buf)
+	o.add(2, []byte("two\n"))
+	o.add(1, []byte("one\n"))
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q before the first entry finished", buf.String())
+	}
+	o.add(0, []byte("zero\n"))
+	if buf.String() != "zero\none\ntwo\n" {
+		t.Errorf("wrote %q, want the lines in entry order", buf.String())
+	}
+
+	// Lines held back behind an entry that never ran are written on close
+	o.add(5, []byte("five\n"))
+	o.add(4, nil)
+	o.close()
+	if buf.String() != "zero\none\ntwo\nfive\n" {
+		t.Errorf("wrote %q after close, want the held back lines", buf.String())
+	}
+
+	var nilLog *orderedLog
+	nilLog.add(0, []byte("ignored\n"))
+}
+
+func TestExecuteOrderedLog(t *testing.T) {
+	dir := t.TempDir()
+	var plan []PlanEntry
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("IMG_%04d.jpg", i)
+		src := filepath.Join(dir, "src", name)
+		writeFiles(t, src)
+		plan = append(plan, PlanEntry{Src: src, Dest: filepath.Join(dir, "dest", name), Action: ActionCopy})
+	}
+	var buf bytes.Buffer
+	opts := Options{Log: true, OrderedLog: true, LogOutput: &buf, CopyWorkers: 4}
+	if err := Execute(plan, opts, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every file is logged once, in plan order
+	last := -1
+	for _, entry := range plan {
+		i := strings.Index(buf.String(), entry.Src)
+		if i < 0 || i < last || strings.Count(buf.String(), entry.Src) != 1 {
+			t.Fatalf("%s logged at %d, after %d:\n%s", entry.Src, i, last, buf.String())
+		}
+		last = i
+	}
+}
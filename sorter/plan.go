@@ -0,0 +1,715 @@
+package sorter
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// Actions a plan entry can carry out.
+const (
+	ActionCopy = "copy"
+	ActionMove = "move"
+	ActionSkip = "skip"
+)
+
+// PlanEntry is a resolved decision for a single source file.
+type PlanEntry struct {
+	Src         string     `json:"src"`
+	Dest        string     `json:"dest"`
+	Action      string     `json:"action"`
+	Date        time.Time  `json:"date"`
+	Source      DateSource `json:"source,omitempty"`
+	UpdateExif  bool       `json:"update_exif,omitempty"`
+	Overwrite   bool       `json:"overwrite,omitempty"`
+	Quarantined bool       `json:"quarantined,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	SrcSize     int64      `json:"src_size,omitempty"`
+	SrcModTime  time.Time  `json:"src_mtime,omitempty"`
+	Hash        string     `json:"hash,omitempty"`
+	Transcode   bool       `json:"transcode,omitempty"`
+}
+
+// Reasons a plan entry can be skipped for.
+const (
+	ReasonExists    = "exists"
+	ReasonInPlace   = "in-place"
+	ReasonIdentical = "identical"
+	ReasonConflict  = "conflict"
+	ReasonHidden    = "hidden"
+	ReasonExtension = "extension"
+	ReasonSize      = "size"
+	ReasonNoDate    = "no-date"
+	ReasonNotNewer  = "not-newer"
+)
+
+// updateOnlyDest returns the file already at dest that an update-only run
+// compares the source with. It is only stat'ed when UpdateOnly is set and no
+// other file of the plan goes there.
+func (opts Options) updateOnlyDest(dest string, planned map[string]string) (os.FileInfo, bool) {
+	if !opts.UpdateOnly || planned[dest] != "" {
+		return nil, false
+	}
+	info, err := os.Stat(dest)
+	return info, err == nil
+}
+
+// buildPlan computes the destination of every file and resolves collisions
+// with existing files and between files of the plan. Files with an
+// implausible date are routed to the quarantine directory when one is set.
+func buildPlan(files []mediaFile, opts Options, stats *Stats) ([]PlanEntry, error) {
+	// Date iPhone edited copies like their originals, so they stay together
+	var edits map[int]int
+	superseded := make(map[int]bool)
+	if opts.IphoneEdits != "" {
+		edits = pairIphoneEdits(files)
+		for edited, original := range edits {
+			files[edited].date = files[original].date
+			if opts.IphoneEdits == EditsPreferEdited {
+				superseded[original] = true
+			}
+		}
+	}
+
+	// Date the low resolution proxies of action cam videos like their videos,
+	// so they are sorted along with them
+	for proxy, video := range pairProxies(files) {
+		files[proxy].date = files[video].date
+	}
+
+	// Sort the frames of a session together once any of them is dated by it
+	if opts.SessionPattern != nil {
+		dateSessions(files)
+	}
+
+	// Date the companions of RAW files like them, so pairs split into
+	// extension folders stay under the same date
+	if len(opts.ExtensionFolders) > 0 {
+		for companion, raw := range pairRawFiles(files) {
+			files[companion].date = files[raw].date
+		}
+	}
+
+	// Count files per day so sparse days can be flattened to month level
+	dayCounts := countByDay(files)
+
+	action := ActionMove
+	if opts.Copy {
+		action = ActionCopy
+	}
+
+	// Generate the folder of every file first, so files can be numbered
+	// within their folder. Files routed to extension folders are numbered
+	// within them, so RAW files and their companions share numbers
+	folders := make([]string, len(files))
+	for i, file := range files {
+		flat := opts.FlatMonth > 0 && dayCounts[dayKey(file.date)] < opts.FlatMonth
+		folders[i] = fileFolder(file, flat, opts)
+	}
+	seqs := sequenceInFolders(files, folders)
+
+	// Number files across the whole run too, in date order
+	indexes := sequenceInFolders(files, make([]string, len(files)))
+
+	var volumes *volumeSet
+	if len(opts.Volumes) > 0 {
+		volumes = newVolumeSet(opts.Volumes, opts.MinFree)
+	}
+
+	var existing *folderIndex
+	if opts.MergeExisting {
+		existing = newFolderIndex()
+	}
+
+	var plan []PlanEntry
+	planned := make(map[string]string)
+	hashes := make(map[string]string)
+	for i, file := range files {
+		// Generate new file name with date
+		ctx := fileTokens(file, opts)
+		ctx.seq, ctx.index = seqs[i], indexes[i]
+		_, edited := edits[i]
+		name := fileName(file, ctx, edited && opts.IphoneEdits == EditsSuffix, opts, log.Default())
+		transcode := opts.TranscodeHEIC && action == ActionCopy && isHEIC(file.path)
+		if transcode {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+		}
+		root, onVolume := opts.Dest, false
+		if volumes != nil {
+			root, onVolume = volumes.pick(filepath.Join(folders[i], name), file.size)
+		}
+		newName := filepath.Join(root, folders[i], name)
+
+		// Route implausible dates to quarantine, preserving the basename
+		quarantined := false
+		if !isPlausibleDate(file.date, opts.MinYear) {
+			if opts.QuarantineDir != "" {
+				log.Warn("Quarantining file with implausible date", "src", file.path, "date", file.date)
+				newName = filepath.Join(opts.QuarantineDir, filepath.Base(file.path))
+				quarantined = true
+			} else {
+				log.Warn("File has an implausible date", "src", file.path, "date", file.date)
+			}
+		}
+
+		// Leave files already in place alone, before they are looked up and
+		// found as duplicates of themselves
+		inPlace := !opts.remote() && samePath(file.path, newName)
+
+		// Skip any existing destination when not clobbering, without hashing
+		exists := !inPlace && opts.NoClobber && (planned[newName] != "" || opts.destExists(newName))
+
+		// Look up the content in the library and earlier in the run
+		hash, duplicateOf := "", ""
+		if opts.DedupeDB != nil && !inPlace && !exists {
+			var err error
+			if hash, err = hashFile(osFS, file.path); err != nil {
+				log.Error("Error while hashing file", "src", file.path, "err", err)
+				stats.inc(&stats.Failed)
+				opts.failCheckpoint(file.path)
+				continue
+			}
+			if existing, ok := opts.DedupeDB.lookup(hash); ok {
+				duplicateOf = existing
+			} else if existing, ok := hashes[hash]; ok {
+				duplicateOf = existing
+			}
+		}
+
+		// Merge into the destination folder when it already holds the content
+		// under another name
+		if existing != nil && duplicateOf == "" && !quarantined && !inPlace && !exists {
+			same, err := existing.lookup(file.path, newName, file.size)
+			if err != nil {
+				log.Error("Error while checking destination folder", "src", file.path, "dest", newName, "err", err)
+				stats.inc(&stats.Failed)
+				opts.failCheckpoint(file.path)
+				continue
+			}
+			duplicateOf = same
+		}
+
+		// Resolve an existing file at the destination, or skip it right away
+		// without comparing content when not clobbering
+		dest, skip, reason := newName, false, ""
+		if duplicateOf != "" {
+			log.Info("Skipping duplicate file", "src", file.path, "duplicate_of", duplicateOf)
+			skip, reason = true, ReasonDuplicate
+		} else if superseded[i] {
+			log.Debug("Skipping original superseded by its edited copy", "src", file.path)
+			skip, reason = true, ReasonSuperseded
+		} else if inPlace {
+			log.Debug("Skipping file already in place", "src", file.path)
+			skip, reason = true, ReasonInPlace
+		} else if exists {
+			log.Debug("Skipping file with existing destination", "src", file.path, "dest", newName)
+			skip, reason = true, ReasonExists
+		} else if info, ok := opts.updateOnlyDest(newName, planned); ok {
+			// Replace the destination only with a newer source, as rsync -u does
+			if !file.modTime.After(info.ModTime()) {
+				log.Debug("Skipping file not newer than its destination", "src", file.path, "dest", newName)
+				skip, reason = true, ReasonNotNewer
+			}
+		} else {
+			var err error
+			dest, reason, err = resolveConflict(opts.storage(), file.path, newName, opts.OnConflict, opts.conflictSuffix(), planned, log.Default())
+			skip = reason != ""
+			if err != nil {
+				log.Error("Error while checking destination", "src", file.path, "dest", newName, "err", err)
+				stats.inc(&stats.Failed)
+				opts.failCheckpoint(file.path)
+				continue
+			}
+		}
+
+		entry := PlanEntry{
+			Src:         file.path,
+			Dest:        dest,
+			Action:      action,
+			Date:        file.date,
+			Source:      file.source,
+			UpdateExif:  opts.UpdateExif && file.source == SourceFilename && !quarantined,
+			Quarantined: quarantined,
+			SrcSize:     file.size,
+			SrcModTime:  file.modTime,
+			Hash:        hash,
+			Transcode:   transcode && !quarantined,
+		}
+		if skip {
+			entry.Action = ActionSkip
+			entry.Reason = reason
+		}
+
+		// Let an embedder override what happens to the file
+		if opts.OnFile != nil {
+			decision := opts.OnFile(FileContext{
+				Path:   file.path,
+				Date:   file.date,
+				Fields: file.fields,
+				Dest:   entry.Dest,
+				Action: entry.Action,
+			})
+			if decision.Abort {
+				return plan, ErrAborted
+			}
+			if decision.Dest != "" {
+				entry.Dest = decision.Dest
+			}
+			if decision.Action != "" {
+				entry.Action = decision.Action
+			}
+			if decision.Reason != "" {
+				entry.Reason = decision.Reason
+			}
+		}
+
+		if entry.Action != ActionSkip {
+			// Take the file off the free space of its volume only once it is
+			// sure to be written there
+			if volumes != nil && !onVolume && !quarantined && strings.HasPrefix(entry.Dest, root+string(filepath.Separator)) {
+				volumes.reserve(root, file.size)
+			}
+			entry.Overwrite = entry.Dest == newName && planned[entry.Dest] == "" && opts.destExists(entry.Dest)
+			planned[entry.Dest] = file.path
+			if hash != "" {
+				hashes[hash] = file.path
+			}
+		}
+		plan = append(plan, entry)
+	}
+	return plan, nil
+}
+
+// organizedThreshold is the share of files laid out as they would be sorted
+// above which a source looks already organized.
+const organizedThreshold = 0.9
+
+// Organized counts the files of a plan already laid out below the source as
+// they would be below the destination, or already in place, out of the files
+// not skipped for another reason. A source above organizedThreshold of them
+// looks like a sorted tree, which sorting again would only churn through.
+func Organized(plan []PlanEntry, opts Options) (inPlace, total int, organized bool) {
+	for _, entry := range plan {
+		if entry.Action == ActionSkip && entry.Reason != ReasonInPlace {
+			continue
+		}
+		total++
+		src, srcErr := filepath.Rel(opts.Src, entry.Src)
+		dest, destErr := filepath.Rel(opts.Dest, entry.Dest)
+		if entry.Reason == ReasonInPlace || srcErr == nil && destErr == nil && src == dest {
+			inPlace++
+		}
+	}
+	return inPlace, total, total > 0 && float64(inPlace) >= organizedThreshold*float64(total)
+}
+
+// fileFolder returns the folder of file below the destination, from the first
+// folder rule it matches, or else from the folder format, or the month format
+// when flat.
+func fileFolder(file mediaFile, flat bool, opts Options) string {
+	format, ruled := ruleFormat(opts.FolderRules, file)
+	if !ruled {
+		format = opts.FolderFormat
+		if flat {
+			format = opts.MonthFormat
+		}
+	}
+	return filepath.Join(formatPath(format, fileTokens(file, opts)), extensionFolder(file.path, opts.ExtensionFolders))
+}
+
+// fileName returns the name file is sorted under, expanding the name format
+// with ctx when set, and marking it as an edited copy when edited.
+func fileName(file mediaFile, ctx tokenContext, edited bool, opts Options, logger *log.Logger) string {
+	name := filepath.Base(file.path)
+	if opts.NameFormat != "" {
+		name = formatPath(opts.NameFormat, ctx)
+		if opts.AppendOriginalName {
+			name += "_" + sanitizeName(strings.TrimSuffix(filepath.Base(file.path), filepath.Ext(file.path)))
+		}
+		name += filepath.Ext(file.path)
+	}
+	if edited {
+		if opts.NameFormat != "" {
+			ext := filepath.Ext(name)
+			name = strings.TrimSuffix(name, ext) + "_edited" + ext
+		} else {
+			name = editedName(name)
+		}
+	}
+	if ext := contentExt(file.fields); opts.FixExtension && ext != "" && !sameExt(filepath.Ext(name), ext) {
+		logger.Info("Fixing extension to match content", "src", file.path, "ext", ext)
+		name = strings.TrimSuffix(name, filepath.Ext(name)) + ext
+	}
+	return name
+}
+
+// sequenceInFolders numbers files from 1 within each of their folders, in
+// date order.
+func sequenceInFolders(files []mediaFile, folders []string) []int {
+	byFolder := make(map[string][]int)
+	for i, folder := range folders {
+		byFolder[folder] = append(byFolder[folder], i)
+	}
+
+	seqs := make([]int, len(files))
+	for _, indexes := range byFolder {
+		sort.SliceStable(indexes, func(a, b int) bool {
+			return files[indexes[a]].date.Before(files[indexes[b]].date)
+		})
+		for n, i := range indexes {
+			seqs[i] = n + 1
+		}
+	}
+	return seqs
+}
+
+// ErrDestinationFull is returned when the destination ran out of space.
+var ErrDestinationFull = errors.New("destination is full")
+
+// ErrLowFreeSpace is returned when sorting another file would leave less
+// than Options.MinFreeSpace free on the destination.
+var ErrLowFreeSpace = errors.New("destination is low on free space")
+
+// ErrInterrupted is returned when Options.Interrupt stopped the run.
+var ErrInterrupted = errors.New("sort interrupted")
+
+// Execute carries out the filesystem operations of a plan, with up to
+// opts.CopyWorkers files being copied or moved at once. It stops early with
+// ErrDestinationFull when the destination runs out of space, with
+// ErrLowFreeSpace before it drops below opts.MinFreeSpace, or with
+// ErrInterrupted once opts.Interrupt is closed.
+func Execute(plan []PlanEntry, opts Options, stats *Stats) error {
+	trash := newTrash(opts)
+	entries := make(chan indexedEntry)
+	var folders folderTracker
+
+	// Write the log lines of every entry in plan order, whatever the order
+	// workers finish them in
+	var ordered *orderedLog
+	if opts.OrderedLog {
+		output := opts.LogOutput
+		if output == nil {
+			output = os.Stderr
+		}
+		ordered = newOrderedLog(output)
+		defer ordered.close()
+	}
+
+	if opts.Checkpoint != nil {
+		opts.Checkpoint.expect(plan)
+	}
+
+	// Stop handing out files once the destination is full or the run is
+	// interrupted
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopErr := ErrDestinationFull
+	interrupted := func() {
+		stopOnce.Do(func() {
+			log.Warn("Interrupted, finishing the files being sorted")
+			stopErr = ErrInterrupted
+			close(stop)
+		})
+	}
+
+	workers := opts.CopyWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger, buf := log.Default(), &bytes.Buffer{}
+			if ordered != nil {
+				logger = log.With()
+				logger.SetOutput(buf)
+			}
+			for indexed := range entries {
+				entry := indexed.entry
+				if opts.interrupted() {
+					interrupted()
+				}
+				select {
+				case <-stop:
+					ordered.add(indexed.index, nil)
+					continue
+				default:
+				}
+				sorted, err := executeEntry(entry, opts, trash, stats, logger)
+				if ordered != nil {
+					ordered.add(indexed.index, buf.Bytes())
+					buf.Reset()
+				}
+				if err == ErrDestinationFull {
+					stopOnce.Do(func() {
+						log.Error("Destination is full, stopping", "dest", entry.Dest)
+						close(stop)
+					})
+				} else if err == ErrLowFreeSpace {
+					stopOnce.Do(func() {
+						log.Error("Destination is low on free space, stopping", "dest", entry.Dest, "min-free-space", opts.MinFreeSpace)
+						stopErr = err
+						close(stop)
+					})
+				}
+				if opts.Checkpoint != nil {
+					if err := opts.Checkpoint.finish(entry.Src, sorted || entry.Action == ActionSkip); err != nil {
+						log.Error("Error while saving checkpoint", "err", err)
+					}
+				}
+				if sorted {
+					folders.track(entry.Dest, entry.Date)
+					if opts.DedupeDB != nil && entry.Hash != "" {
+						opts.DedupeDB.add(entry.Hash, entry.Dest)
+					}
+				}
+			}
+		}()
+	}
+
+feed:
+	for i, entry := range plan {
+		select {
+		case entries <- indexedEntry{index: i, entry: entry}:
+		case <-opts.Interrupt:
+			interrupted()
+			break feed
+		case <-stop:
+			break feed
+		}
+	}
+	close(entries)
+	wg.Wait()
+
+	if opts.Checkpoint != nil {
+		if err := opts.Checkpoint.Save(); err != nil {
+			log.Error("Error while saving checkpoint", "err", err)
+		}
+	}
+
+	if opts.DedupeDB != nil {
+		if err := opts.DedupeDB.Save(); err != nil {
+			log.Error("Error while saving dedupe database", "err", err)
+		}
+	}
+
+	if opts.ContactSheets {
+		for _, root := range opts.destRoots() {
+			writeContactSheets(root, folders.summaries(root), opts)
+		}
+	}
+
+	// Describe the folders files were sorted into
+	if opts.FolderMetadata != nil {
+		for _, summary := range folders.summaries(opts.Dest) {
+			if err := opts.FolderMetadata.Write(summary); err != nil {
+				log.Error("Error while writing folder metadata", "dir", summary.Dir, "err", err)
+			}
+		}
+	}
+
+	select {
+	case <-stop:
+		return stopErr
+	default:
+		return nil
+	}
+}
+
+// executeEntry carries out a single entry of a plan, logging to logger, and
+// reports whether the file was sorted. It returns ErrDestinationFull when no
+// file can be placed anymore, and ErrLowFreeSpace when this one would leave
+// less than opts.MinFreeSpace free.
+func executeEntry(entry PlanEntry, opts Options, trash *trash, stats *Stats, logger *log.Logger) (bool, error) {
+	if entry.Action != ActionSkip && !opts.remote() && samePath(entry.Src, entry.Dest) {
+		logger.Debug("Skipping file already in place", "src", entry.Src)
+		entry.Action, entry.Reason = ActionSkip, ReasonInPlace
+	}
+	if entry.Action == ActionSkip {
+		switch entry.Reason {
+		case ReasonExists:
+			stats.inc(&stats.SkippedExists)
+		case ReasonInPlace:
+			stats.inc(&stats.InPlace)
+		case ReasonDuplicate:
+			stats.inc(&stats.Duplicates)
+		default:
+			stats.inc(&stats.Skipped)
+		}
+		stats.AddSkip(entry.Src, entry.Reason)
+		return false, nil
+	}
+
+	// Refuse to act on a source that changed since planning
+	if changed, err := entry.sourceChanged(); err != nil {
+		logger.Error("Error while checking source", "src", entry.Src, "err", err)
+		stats.inc(&stats.Failed)
+		return false, nil
+	} else if changed && opts.AllowChanged {
+		logger.Warn("Source changed since planning", "src", entry.Src)
+	} else if changed {
+		logger.Error("Source changed since planning, refusing to sort it", "src", entry.Src)
+		stats.inc(&stats.Failed)
+		return false, nil
+	}
+
+	// Refuse to replace a file that appeared after planning
+	if !entry.Overwrite && opts.destExists(entry.Dest) {
+		same, err := opts.storage().Same(entry.Src, entry.Dest)
+		if err != nil {
+			logger.Error("Error while checking destination", "src", entry.Src, "dest", entry.Dest, "err", err)
+			stats.inc(&stats.Failed)
+			return false, nil
+		}
+		if same {
+			logger.Info("Skipping identical file", "src", entry.Src, "dest", entry.Dest)
+			stats.inc(&stats.Skipped)
+			stats.AddSkip(entry.Src, ReasonIdentical)
+			return false, nil
+		}
+		logger.Error("Destination already exists", "src", entry.Src, "dest", entry.Dest)
+		stats.inc(&stats.Failed)
+		return false, nil
+	}
+
+	// Keep the file about to be overwritten in the trash
+	if entry.Overwrite && trash != nil && fileExists(entry.Dest) {
+		if err := trash.put(entry.Dest, logger); err != nil {
+			logger.Error("Error while moving file to trash", "dest", entry.Dest, "err", err)
+			stats.inc(&stats.Failed)
+			return false, nil
+		}
+	}
+
+	// Leave the destination the free space it must keep
+	if opts.MinFreeSpace > 0 && !opts.remote() {
+		if err := checkFreeSpace(entry, opts.MinFreeSpace); err == ErrLowFreeSpace {
+			return false, err
+		} else if err != nil {
+			logger.Warn("Error while measuring free space", "dest", entry.Dest, "err", err)
+		}
+	}
+
+	// Move or copy file
+	var err error
+	ioStart := time.Now()
+	if opts.remote() {
+		err = putFile(opts.Storage, entry, opts.CopyBuffer)
+	} else if entry.Action == ActionCopy && entry.Transcode {
+		err = transcodeHEIC(entry.Src, entry.Dest, opts)
+	} else if entry.Action == ActionCopy && opts.Resumable {
+		err = copyFileResumable(entry.Src, entry.Dest, opts.CopyBuffer, logger)
+	} else if entry.Action == ActionCopy {
+		err = copyFile(osFS, entry.Src, entry.Dest, opts.CopyBuffer)
+	} else {
+		err = renameFile(osFS, entry.Src, entry.Dest, opts.CopyBuffer, logger)
+	}
+	stats.timeIO(ioStart)
+	if errors.Is(err, syscall.ENOSPC) {
+		stats.inc(&stats.Failed)
+		return false, ErrDestinationFull
+	}
+	if err != nil {
+		logger.Error("Error while processing file", "src", entry.Src, "dest", entry.Dest, "action", entry.Action, "err", err)
+		stats.inc(&stats.Failed)
+		return false, nil
+	}
+	if entry.Quarantined {
+		stats.inc(&stats.Quarantined)
+	} else {
+		stats.inc(&stats.Sorted)
+	}
+	stats.addBytes(entry.SrcSize)
+	if entry.Source == SourceMtime {
+		stats.inc(&stats.MtimeDated)
+	}
+
+	// Keep the HEIC original next to its JPEG if requested
+	if entry.Action == ActionCopy && entry.Transcode && opts.KeepHEIC {
+		if err := keepHEIC(entry, opts.CopyBuffer); err != nil {
+			logger.Error("Error while keeping HEIC original", "src", entry.Src, "err", err)
+		}
+	}
+
+	// Update EXIF data if requested
+	if entry.UpdateExif {
+		logger.Warn("Need to update EXIF data", "dest", entry.Dest)
+		err = updateExif(entry.Dest, entry.Date, opts, logger)
+		if err != nil {
+			logger.Error("Error while updating EXIF data", "dest", entry.Dest, "err", err)
+			return true, nil
+		}
+	}
+
+	// Date the sorted file on the filesystem by its capture date
+	if opts.SetMtime && !entry.Quarantined {
+		if err := os.Chtimes(entry.Dest, entry.Date, entry.Date); err != nil {
+			logger.Error("Error while setting modification time", "dest", entry.Dest, "err", err)
+		}
+	}
+
+	// Record where the file came from
+	if opts.Provenance {
+		if err := writeProvenance(entry, stats.start); err != nil {
+			logger.Error("Error while writing provenance", "dest", entry.Dest, "err", err)
+		}
+	}
+
+	// Log file move or copy
+	if opts.Log {
+		logger.Info("Sorted file", "src", entry.Src, "dest", entry.Dest, "action", entry.Action)
+	}
+	return true, nil
+}
+
+// sourceChanged reports whether the source of an entry no longer has the
+// size and modification time recorded when planning.
+func (entry PlanEntry) sourceChanged() (bool, error) {
+	if entry.SrcModTime.IsZero() {
+		return false, nil
+	}
+	info, err := os.Stat(entry.Src)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return info.Size() != entry.SrcSize || !info.ModTime().Equal(entry.SrcModTime), nil
+}
+
+// WritePlan saves a plan as JSON so it can be reviewed and applied later.
+func WritePlan(path string, plan []PlanEntry) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(path, append(data, '\n'), 0o644))
+}
+
+// ReadPlan loads a plan written by WritePlan.
+func ReadPlan(path string) ([]PlanEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var plan []PlanEntry
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, errors.Wrapf(err, "invalid plan %q", path)
+	}
+	return plan, nil
+}
+
+// fileExists reports whether a file exists at path.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,790 @@
+package sorter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// writeFiles creates the files at paths, each holding its own path, along
+// with their directories.
+func writeFiles(t *testing.T, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		writeFile(t, path, path)
+	}
+}
+
+// writeFile creates the file at path holding content, along with its
+// directory.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecuteInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	src := []string{filepath.Join(dir, "src", "a", "IMG_0001.jpg"), filepath.Join(dir, "src", "b", "IMG_0002.jpg")}
+	writeFiles(t, src...)
+	cp, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupt := make(chan struct{})
+	close(interrupt)
+	opts := Options{Checkpoint: cp, Interrupt: interrupt, CopyWorkers: 2}
+
+	var plan []PlanEntry
+	for _, path := range src {
+		plan = append(plan, PlanEntry{Src: path, Dest: filepath.Join(dir, "dest", filepath.Base(path)), Action: ActionCopy})
+	}
+	stats := NewStats()
+	if err := Execute(plan, opts, stats); err != ErrInterrupted {
+		t.Fatalf("Execute() error = %v, want %v", err, ErrInterrupted)
+	}
+	for _, entry := range plan {
+		if fileExists(entry.Dest) {
+			t.Errorf("%s was sorted after the interrupt", entry.Src)
+		}
+	}
+	if stats.Sorted != 0 || stats.Failed != 0 {
+		t.Errorf("sorted %d and failed %d files, want none", stats.Sorted, stats.Failed)
+	}
+
+	// The checkpoint is saved, without the directories left unfinished
+	saved, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(dir, CheckpointName)) || saved.Completed() != 0 {
+		t.Errorf("checkpoint saved = %v with %d completed directories, want saved with none", fileExists(filepath.Join(dir, CheckpointName)), saved.Completed())
+	}
+}
+
+func TestPlanInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, filepath.Join(dir, "src", "IMG_0001.jpg"))
+	interrupt := make(chan struct{})
+	close(interrupt)
+	opts := Options{
+		Src:          filepath.Join(dir, "src"),
+		Dest:         filepath.Join(dir, "dest"),
+		FolderFormat: "2006/01/02",
+		OnConflict:   ConflictRename,
+		DatePrefer:   PreferExif,
+		Interrupt:    interrupt,
+	}
+	plan, err := Plan(opts, NewStats())
+	if err != ErrInterrupted || len(plan) != 0 {
+		t.Errorf("Plan() = %d entries, %v, want none and %v", len(plan), err, ErrInterrupted)
+	}
+}
+
+func TestBuildPlanVolumesSpill(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	free, err := freeSpace(dir)
+	if err != nil {
+		t.Skip("cannot measure free space:", err)
+	}
+
+	// Leave room for 250MB on either volume, which share a filesystem
+	const mb = 1 << 20
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := []mediaFile{
+		{path: filepath.Join(dir, "src", "IMG_0000.jpg"), date: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), size: 200 * mb},
+		{path: filepath.Join(dir, "src", "IMG_0001.jpg"), date: date, size: 100 * mb},
+		{path: filepath.Join(dir, "src", "copy", "IMG_0001.jpg"), date: date, size: 100 * mb},
+		{path: filepath.Join(dir, "src", "IMG_0002.jpg"), date: date, size: 100 * mb},
+		{path: filepath.Join(dir, "src", "IMG_0003.jpg"), date: date, size: 100 * mb},
+	}
+	opts := Options{
+		FolderFormat:  "2006",
+		OnConflict:    ConflictRename,
+		MinYear:       1990,
+		QuarantineDir: filepath.Join(dir, "quarantine"),
+		NoClobber:     true,
+		Volumes:       []string{first, second},
+		MinFree:       free - 250*mb,
+	}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the quarantined file nor the one skipped by -no-clobber takes
+	// room, so the first volume fits two files
+	want := []string{
+		filepath.Join(dir, "quarantine", "IMG_0000.jpg"),
+		filepath.Join(first, "2023", "IMG_0001.jpg"),
+		filepath.Join(first, "2023", "IMG_0001.jpg"),
+		filepath.Join(first, "2023", "IMG_0002.jpg"),
+		filepath.Join(second, "2023", "IMG_0003.jpg"),
+	}
+	checkDests(t, plan, want)
+	if plan[2].Action != ActionSkip || plan[2].Reason != ReasonExists {
+		t.Errorf("second IMG_0001.jpg is %s (%s), want skipped as existing", plan[2].Action, plan[2].Reason)
+	}
+}
+
+// checkDests fails the test unless the entries of plan go to want, in order.
+func checkDests(t *testing.T, plan []PlanEntry, want []string) {
+	t.Helper()
+	if len(plan) != len(want) {
+		t.Fatalf("planned %d entries, want %d", len(plan), len(want))
+	}
+	for i, entry := range plan {
+		if entry.Dest != want[i] {
+			t.Errorf("%s goes to %q, want %q", filepath.Base(entry.Src), entry.Dest, want[i])
+		}
+	}
+}
+
+func TestBuildPlanConflicts(t *testing.T) {
+	type want struct {
+		dest      string
+		reason    string
+		overwrite bool
+	}
+	tests := []struct {
+		name      string
+		policy    string
+		noClobber bool
+		want      []want
+	}{
+		{"rename", ConflictRename, false, []want{
+			{"2023/IMG_0001_1.jpg", "", false},
+			{"2023/IMG_0002.jpg", "", false},
+			{"2023/IMG_0002_1.jpg", "", false},
+			{"2023/IMG_0003.jpg", ReasonIdentical, false},
+		}},
+		{"skip", ConflictSkip, false, []want{
+			{"2023/IMG_0001.jpg", ReasonConflict, false},
+			{"2023/IMG_0002.jpg", "", false},
+			{"2023/IMG_0002.jpg", ReasonConflict, false},
+			{"2023/IMG_0003.jpg", ReasonIdentical, false},
+		}},
+		// Files of the plan are renamed rather than overwriting each other
+		{"overwrite", ConflictOverwrite, false, []want{
+			{"2023/IMG_0001.jpg", "", true},
+			{"2023/IMG_0002.jpg", "", false},
+			{"2023/IMG_0002_1.jpg", "", false},
+			{"2023/IMG_0003.jpg", ReasonIdentical, false},
+		}},
+		// Existing destinations are skipped without comparing content
+		{"no clobber", ConflictRename, true, []want{
+			{"2023/IMG_0001.jpg", ReasonExists, false},
+			{"2023/IMG_0002.jpg", "", false},
+			{"2023/IMG_0002.jpg", ReasonExists, false},
+			{"2023/IMG_0003.jpg", ReasonExists, false},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+			writeFile(t, filepath.Join(dest, "2023", "IMG_0001.jpg"), "already sorted")
+			writeFile(t, filepath.Join(dest, "2023", "IMG_0003.jpg"), "identical")
+			writeFile(t, filepath.Join(src, "IMG_0003.jpg"), "identical")
+			writeFiles(t, filepath.Join(src, "IMG_0001.jpg"), filepath.Join(src, "a", "IMG_0002.jpg"), filepath.Join(src, "b", "IMG_0002.jpg"))
+
+			date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+			var files []mediaFile
+			for _, path := range []string{"IMG_0001.jpg", "a/IMG_0002.jpg", "b/IMG_0002.jpg", "IMG_0003.jpg"} {
+				files = append(files, mediaFile{path: filepath.Join(src, filepath.FromSlash(path)), date: date})
+			}
+			opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006", OnConflict: tt.policy, NoClobber: tt.noClobber}
+			plan, err := buildPlan(files, opts, NewStats())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plan) != len(tt.want) {
+				t.Fatalf("planned %d entries, want %d", len(plan), len(tt.want))
+			}
+			for i, entry := range plan {
+				want := tt.want[i]
+				action := ActionCopy
+				if want.reason != "" {
+					action = ActionSkip
+				}
+				if entry.Dest != filepath.Join(dest, filepath.FromSlash(want.dest)) || entry.Action != action || entry.Reason != want.reason || entry.Overwrite != want.overwrite {
+					t.Errorf("%s: %s to %q (%s), overwrite %v, want %s to %q (%s), overwrite %v", entry.Src,
+						entry.Action, entry.Dest, entry.Reason, entry.Overwrite, action, want.dest, want.reason, want.overwrite)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildPlanNoClobberSkipsHashing(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	writeFiles(t, filepath.Join(src, "IMG_0001.jpg"), filepath.Join(dest, "2023", "IMG_0001.jpg"), filepath.Join(src, "IMG_0002.jpg"))
+	db, err := OpenDedupeDB(filepath.Join(dir, "dedupe.json"), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := []mediaFile{{path: filepath.Join(src, "IMG_0001.jpg"), date: date}, {path: filepath.Join(src, "IMG_0002.jpg"), date: date}}
+	opts := Options{Dest: dest, FolderFormat: "2006", OnConflict: ConflictRename, NoClobber: true, DedupeDB: db}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan[0].Reason != ReasonExists || plan[0].Hash != "" {
+		t.Errorf("existing destination: reason %q, hash %q, want skipped as existing without hashing", plan[0].Reason, plan[0].Hash)
+	}
+	if plan[1].Action != ActionMove || plan[1].Hash == "" {
+		t.Errorf("new destination: %s, hash %q, want moved and hashed", plan[1].Action, plan[1].Hash)
+	}
+}
+
+func TestBuildPlanUpdateOnly(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	destTime := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"newer.jpg", "older.jpg", "same.jpg"} {
+		path := filepath.Join(dest, "2023", name)
+		writeFiles(t, path)
+		if err := os.Chtimes(path, destTime, destTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := []mediaFile{
+		{path: filepath.Join(src, "newer.jpg"), date: date, modTime: destTime.Add(time.Hour)},
+		{path: filepath.Join(src, "older.jpg"), date: date, modTime: destTime.Add(-time.Hour)},
+		{path: filepath.Join(src, "same.jpg"), date: date, modTime: destTime},
+		{path: filepath.Join(src, "missing.jpg"), date: date, modTime: destTime},
+	}
+	writeFiles(t, files[0].path, files[1].path, files[2].path, files[3].path)
+
+	tests := []struct {
+		name       string
+		updateOnly bool
+		reasons    []string
+		overwrite  []bool
+	}{
+		{"update only", true, []string{"", ReasonNotNewer, ReasonNotNewer, ""}, []bool{true, false, false, false}},
+		// Without -update-only, the conflict policy decides by content instead
+		{"conflict policy", false, []string{ReasonConflict, ReasonConflict, ReasonConflict, ""}, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{Dest: dest, FolderFormat: "2006", OnConflict: ConflictSkip, UpdateOnly: tt.updateOnly}
+			plan, err := buildPlan(files, opts, NewStats())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, entry := range plan {
+				if entry.Reason != tt.reasons[i] || entry.Overwrite != tt.overwrite[i] {
+					t.Errorf("%s: reason %q, overwrite %v, want %q, %v", filepath.Base(entry.Src), entry.Reason, entry.Overwrite, tt.reasons[i], tt.overwrite[i])
+				}
+				if want := filepath.Join(dest, "2023", filepath.Base(entry.Src)); entry.Dest != want {
+					t.Errorf("%s goes to %q, want %q", filepath.Base(entry.Src), entry.Dest, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateOnlyDest(t *testing.T) {
+	dir := t.TempDir()
+	existing, missing := filepath.Join(dir, "existing.jpg"), filepath.Join(dir, "missing.jpg")
+	writeFiles(t, existing)
+	tests := []struct {
+		name    string
+		opts    Options
+		dest    string
+		planned map[string]string
+		want    bool
+	}{
+		{"existing", Options{UpdateOnly: true}, existing, nil, true},
+		{"missing", Options{UpdateOnly: true}, missing, nil, false},
+		{"planned", Options{UpdateOnly: true}, existing, map[string]string{existing: "src/existing.jpg"}, false},
+		{"not update only", Options{}, existing, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := tt.opts.updateOnlyDest(tt.dest, tt.planned)
+			if ok != tt.want || (info != nil) != tt.want {
+				t.Errorf("updateOnlyDest() = %v, %v, want found %v", info, ok, tt.want)
+			}
+		})
+	}
+}
+
+// fullFS is the operating system's fileSystem on a full disk: creating a
+// file fails with ENOSPC. It counts the files it was asked to create.
+type fullFS struct {
+	osFileSystem
+	created int
+}
+
+func (fsys *fullFS) Create(name string) (*os.File, error) {
+	fsys.created++
+	return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOSPC}
+}
+
+// useFS makes fsys the fileSystem files are sorted on for the rest of the
+// test.
+func useFS(t *testing.T, fsys fileSystem) {
+	previous := osFS
+	osFS = fsys
+	t.Cleanup(func() { osFS = previous })
+}
+
+func TestExecuteStops(t *testing.T) {
+	tests := []struct {
+		name         string
+		minFreeSpace int64
+		err          error
+		created      int
+	}{
+		{"destination full", 0, ErrDestinationFull, 1},
+		{"low free space", 1 << 62, ErrLowFreeSpace, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if _, err := freeSpace(dir); err != nil && tt.minFreeSpace > 0 {
+				t.Skip("cannot measure free space:", err)
+			}
+			fsys := &fullFS{}
+			useFS(t, fsys)
+			cp, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var plan []PlanEntry
+			for _, name := range []string{"a/IMG_0001.jpg", "a/IMG_0002.jpg", "b/IMG_0003.jpg"} {
+				src := filepath.Join(dir, "src", filepath.FromSlash(name))
+				writeFiles(t, src)
+				plan = append(plan, PlanEntry{Src: src, Dest: filepath.Join(dir, "dest", filepath.Base(src)), Action: ActionCopy})
+			}
+			opts := Options{Checkpoint: cp, MinFreeSpace: tt.minFreeSpace, CopyWorkers: 1}
+			stats := NewStats()
+			if err := Execute(plan, opts, stats); err != tt.err {
+				t.Fatalf("Execute() error = %v, want %v", err, tt.err)
+			}
+
+			// Only the first file was tried before the workers stopped
+			if fsys.created != tt.created {
+				t.Errorf("created %d files, want %d", fsys.created, tt.created)
+			}
+			if stats.Sorted != 0 {
+				t.Errorf("sorted %d files, want none", stats.Sorted)
+			}
+			for _, entry := range plan {
+				if fileExists(entry.Dest) || !fileExists(entry.Src) {
+					t.Errorf("%s was sorted after the stop", entry.Src)
+				}
+			}
+
+			// The checkpoint is saved with no directory completed
+			saved, err := OpenCheckpoint(filepath.Join(dir, CheckpointName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fileExists(filepath.Join(dir, CheckpointName)) || saved.Completed() != 0 {
+				t.Errorf("checkpoint saved = %v with %d completed directories, want saved with none", fileExists(filepath.Join(dir, CheckpointName)), saved.Completed())
+			}
+		})
+	}
+}
+
+func TestBuildPlanFlatMonth(t *testing.T) {
+	tests := []struct {
+		flatMonth int
+		want      []string
+	}{
+		{0, []string{"2023/05/01/IMG_0001.jpg", "2023/05/01/IMG_0002.jpg", "2023/05/02/IMG_0003.jpg", "2023/06/30/IMG_0004.jpg"}},
+		{2, []string{"2023/05/01/IMG_0001.jpg", "2023/05/01/IMG_0002.jpg", "2023/05/IMG_0003.jpg", "2023/06/IMG_0004.jpg"}},
+		{3, []string{"2023/05/IMG_0001.jpg", "2023/05/IMG_0002.jpg", "2023/05/IMG_0003.jpg", "2023/06/IMG_0004.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("flat month %d", tt.flatMonth), func(t *testing.T) {
+			dir := t.TempDir()
+			src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+			dates := []time.Time{
+				time.Date(2023, 5, 1, 9, 0, 0, 0, time.UTC),
+				time.Date(2023, 5, 1, 18, 0, 0, 0, time.UTC),
+				time.Date(2023, 5, 2, 12, 0, 0, 0, time.UTC),
+				time.Date(2023, 6, 30, 23, 0, 0, 0, time.UTC),
+			}
+			var files []mediaFile
+			for i, date := range dates {
+				path := filepath.Join(src, fmt.Sprintf("IMG_%04d.jpg", i+1))
+				writeFiles(t, path)
+				files = append(files, mediaFile{path: path, date: date})
+			}
+			opts := Options{Src: src, Dest: dest, FolderFormat: "2006/01/02", MonthFormat: "2006/01", FlatMonth: tt.flatMonth, OnConflict: ConflictRename}
+			plan, err := buildPlan(files, opts, NewStats())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, rel := range tt.want {
+				want = append(want, filepath.Join(dest, filepath.FromSlash(rel)))
+			}
+			checkDests(t, plan, want)
+		})
+	}
+}
+
+// useLog sends the log to a buffer formatted as JSON lines for the rest of
+// the test.
+func useLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFormatter(log.JSONFormatter)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(log.TextFormatter)
+	})
+	return &buf
+}
+
+func TestExecuteLogFields(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	writeFiles(t, src)
+	buf := useLog(t)
+
+	plan := []PlanEntry{{Src: src, Dest: filepath.Join(dir, "dest", "IMG_0001.jpg"), Action: ActionCopy}}
+	if err := Execute(plan, Options{Log: true}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	var sorted map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		if entry["msg"] == "Sorted file" {
+			sorted = entry
+		}
+	}
+	want := map[string]interface{}{"lvl": "info", "msg": "Sorted file", "src": src, "dest": plan[0].Dest, "action": ActionCopy}
+	for key, value := range want {
+		if sorted[key] != value {
+			t.Errorf("%s = %v, want %v in %v", key, sorted[key], value, sorted)
+		}
+	}
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	plan := []PlanEntry{
+		{Src: "src/IMG_0001.jpg", Dest: "dest/2023/05/IMG_0001.jpg", Action: ActionMove, Date: date, Source: SourceExif, UpdateExif: true, SrcSize: 42, SrcModTime: date},
+		{Src: "src/IMG_0002.jpg", Action: ActionSkip, Reason: ReasonDuplicate},
+	}
+	if err := WritePlan(path, plan); err != nil {
+		t.Fatalf("WritePlan() error = %v", err)
+	}
+	got, err := ReadPlan(path)
+	if err != nil {
+		t.Fatalf("ReadPlan() error = %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(plan) {
+		t.Errorf("ReadPlan() = %v, want %v", got, plan)
+	}
+
+	if _, err := ReadPlan(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("ReadPlan() of a missing file succeeded")
+	}
+	writeFile(t, path, "{")
+	if _, err := ReadPlan(path); err == nil || !strings.Contains(err.Error(), "invalid plan") {
+		t.Errorf("ReadPlan() of a broken file error = %v, want an invalid plan", err)
+	}
+}
+
+func TestExecuteChangedSource(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		t.Run(fmt.Sprint("allow changed ", allow), func(t *testing.T) {
+			dir := t.TempDir()
+			src, dest := filepath.Join(dir, "src", "IMG_0001.jpg"), filepath.Join(dir, "dest", "IMG_0001.jpg")
+			writeFiles(t, src)
+			info, err := os.Stat(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan := []PlanEntry{{Src: src, Dest: dest, Action: ActionCopy, SrcSize: info.Size(), SrcModTime: info.ModTime()}}
+
+			// The source is edited between planning and applying
+			writeFile(t, src, "edited since planning")
+			stats := NewStats()
+			if err := Execute(plan, Options{AllowChanged: allow, CopyWorkers: 1}, stats); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if fileExists(dest) != allow {
+				t.Errorf("sorted = %v, want %v", fileExists(dest), allow)
+			}
+			if wantFailed := map[bool]int{false: 1, true: 0}[allow]; stats.Failed != wantFailed {
+				t.Errorf("failed %d files, want %d", stats.Failed, wantFailed)
+			}
+		})
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	src, dest, quarantine := filepath.Join(dir, "src"), filepath.Join(dir, "dest"), filepath.Join(dir, "quarantine")
+	paths := []string{filepath.Join(src, "IMG_0001.jpg"), filepath.Join(src, "a", "IMG_0002.jpg"), filepath.Join(src, "IMG_0003.jpg")}
+	writeFiles(t, paths...)
+
+	// A camera clock reset to 1980 and one set a decade ahead
+	files := []mediaFile{
+		{path: paths[0], date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), source: SourceExif},
+		{path: paths[1], date: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), source: SourceExif},
+		{path: paths[2], date: time.Now().AddDate(10, 0, 0), source: SourceFilename},
+	}
+	opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01", MinYear: 1990, QuarantineDir: quarantine, UpdateExif: true, SetMtime: true, CopyWorkers: 1}
+	stats := NewStats()
+	plan, err := buildPlan(files, opts, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{
+		filepath.Join(dest, "2023", "05", "IMG_0001.jpg"),
+		filepath.Join(quarantine, "IMG_0002.jpg"),
+		filepath.Join(quarantine, "IMG_0003.jpg"),
+	})
+	for _, entry := range plan {
+		if quarantined := filepath.Dir(entry.Dest) == quarantine; entry.Quarantined != quarantined || entry.UpdateExif {
+			t.Errorf("%s: quarantined = %v, update EXIF = %v", entry.Src, entry.Quarantined, entry.UpdateExif)
+		}
+	}
+
+	if err := Execute(plan, opts, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sorted != 1 || stats.Quarantined != 2 {
+		t.Errorf("sorted %d and quarantined %d files, want 1 and 2", stats.Sorted, stats.Quarantined)
+	}
+	// Quarantined files keep their own modification time
+	info, err := os.Stat(filepath.Join(quarantine, "IMG_0002.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ModTime().Year() == 1980 {
+		t.Error("the quarantined file was dated by its implausible date")
+	}
+
+	// Without a quarantine, implausible dates are sorted as they are
+	opts.QuarantineDir = ""
+	plan, err = buildPlan(files[1:2], opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{filepath.Join(dest, "1980", "01", "IMG_0002.jpg")})
+}
+
+func TestExecuteSetMtime(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "src", "IMG_0001.jpg"), filepath.Join(dir, "src", "IMG_0002.jpg")}
+	writeFiles(t, paths...)
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	var plan []PlanEntry
+	for _, path := range paths {
+		plan = append(plan, PlanEntry{Src: path, Dest: filepath.Join(dir, "dest", filepath.Base(path)), Action: ActionCopy, Date: date})
+	}
+
+	// Only asked for are sorted files dated by their capture date
+	if err := Execute(plan[:1], Options{CopyWorkers: 1}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Execute(plan[1:], Options{SetMtime: true, CopyWorkers: 1}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range plan {
+		info, err := os.Stat(entry.Dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if set := info.ModTime().Equal(date); set != (i == 1) {
+			t.Errorf("%s modified at %v, want the capture date set %v", entry.Dest, info.ModTime(), i == 1)
+		}
+	}
+
+	if err := (Options{Storage: &S3Storage{Bucket: "photos"}, SetMtime: true}).validateRemote(); err == nil {
+		t.Error("validateRemote() accepted setting modification times on a remote destination")
+	}
+}
+
+func TestOrganized(t *testing.T) {
+	opts := Options{Src: filepath.Join("old", "photos"), Dest: "library"}
+	sorted := func(n int) []PlanEntry {
+		var plan []PlanEntry
+		for i := 0; i < n; i++ {
+			rel := filepath.Join("2023", "05", fmt.Sprintf("IMG_%04d.jpg", i))
+			plan = append(plan, PlanEntry{Src: filepath.Join(opts.Src, rel), Dest: filepath.Join(opts.Dest, rel), Action: ActionCopy})
+		}
+		return plan
+	}
+	moved := PlanEntry{Src: filepath.Join(opts.Src, "IMG_9999.jpg"), Dest: filepath.Join(opts.Dest, "2023", "05", "IMG_9999.jpg"), Action: ActionCopy}
+	inPlace := PlanEntry{Src: filepath.Join(opts.Dest, "IMG_9998.jpg"), Dest: filepath.Join(opts.Dest, "IMG_9998.jpg"), Action: ActionSkip, Reason: ReasonInPlace}
+	duplicate := PlanEntry{Src: filepath.Join(opts.Src, "copy.jpg"), Action: ActionSkip, Reason: ReasonDuplicate}
+
+	tests := []struct {
+		name      string
+		plan      []PlanEntry
+		inPlace   int
+		total     int
+		organized bool
+	}{
+		{"empty", nil, 0, 0, false},
+		{"sorted", sorted(9), 9, 9, true},
+		{"mostly sorted", append(sorted(9), moved), 9, 10, true},
+		{"half sorted", append(sorted(1), moved), 1, 2, false},
+		{"in place", []PlanEntry{inPlace}, 1, 1, true},
+		// Files skipped for another reason are left out
+		{"duplicates", []PlanEntry{duplicate, moved}, 0, 1, false},
+	}
+	for _, tt := range tests {
+		inPlace, total, organized := Organized(tt.plan, opts)
+		if inPlace != tt.inPlace || total != tt.total || organized != tt.organized {
+			t.Errorf("%s: Organized() = %d, %d, %v, want %d, %d, %v", tt.name, inPlace, total, organized, tt.inPlace, tt.total, tt.organized)
+		}
+	}
+}
+
+func TestBuildPlanOnFile(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	paths := []string{filepath.Join(src, "IMG_0001.jpg"), filepath.Join(src, "IMG_0002.jpg"), filepath.Join(src, "IMG_0003.jpg"), filepath.Join(src, "IMG_0004.jpg")}
+	writeFiles(t, paths...)
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	var files []mediaFile
+	for _, path := range paths {
+		files = append(files, mediaFile{path: path, date: date, fields: map[string]interface{}{"Model": "X100V"}})
+	}
+
+	var seen []FileContext
+	opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01", OnFile: func(ctx FileContext) Decision {
+		seen = append(seen, ctx)
+		switch filepath.Base(ctx.Path) {
+		case "IMG_0002.jpg":
+			return Decision{Dest: filepath.Join(dest, "picked", "IMG_0002.jpg"), Action: ActionMove}
+		case "IMG_0003.jpg":
+			return Decision{Action: ActionSkip, Reason: "unwanted"}
+		case "IMG_0004.jpg":
+			return Decision{Abort: true}
+		}
+		return Decision{}
+	}}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != ErrAborted {
+		t.Fatalf("buildPlan() error = %v, want %v", err, ErrAborted)
+	}
+
+	// The hook sees the proposed destination and action of every file up to
+	// the one aborting
+	if len(seen) != 4 {
+		t.Fatalf("hook called %d times, want 4", len(seen))
+	}
+	first := seen[0]
+	if first.Path != paths[0] || !first.Date.Equal(date) || first.Fields["Model"] != "X100V" || first.Dest != filepath.Join(dest, "2023", "05", "IMG_0001.jpg") || first.Action != ActionCopy {
+		t.Errorf("hook saw %+v", first)
+	}
+
+	want := []PlanEntry{
+		{Dest: filepath.Join(dest, "2023", "05", "IMG_0001.jpg"), Action: ActionCopy},
+		{Dest: filepath.Join(dest, "picked", "IMG_0002.jpg"), Action: ActionMove},
+		{Dest: filepath.Join(dest, "2023", "05", "IMG_0003.jpg"), Action: ActionSkip, Reason: "unwanted"},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("planned %d entries, want %d", len(plan), len(want))
+	}
+	for i, entry := range plan {
+		if entry.Dest != want[i].Dest || entry.Action != want[i].Action || entry.Reason != want[i].Reason {
+			t.Errorf("%s: %s to %q (%s), want %s to %q (%s)", filepath.Base(entry.Src), entry.Action, entry.Dest, entry.Reason, want[i].Action, want[i].Dest, want[i].Reason)
+		}
+	}
+}
+
+func TestSourceChanged(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	writeFiles(t, src)
+	files := []mediaFile{{path: src, date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}}
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files[0].size, files[0].modTime = info.Size(), info.ModTime()
+
+	// The plan records the size and modification time of every source
+	plan, err := buildPlan(files, Options{Src: filepath.Join(dir, "src"), Dest: filepath.Join(dir, "dest"), Copy: true, FolderFormat: "2006/01"}, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := plan[0]
+	if entry.SrcSize != info.Size() || !entry.SrcModTime.Equal(info.ModTime()) {
+		t.Fatalf("planned size %d and time %v, want %d and %v", entry.SrcSize, entry.SrcModTime, info.Size(), info.ModTime())
+	}
+	if changed, err := entry.sourceChanged(); changed || err != nil {
+		t.Errorf("sourceChanged() = %v, %v for an untouched source", changed, err)
+	}
+
+	touched := info.ModTime().Add(time.Hour)
+	if err := os.Chtimes(src, touched, touched); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := entry.sourceChanged(); !changed || err != nil {
+		t.Errorf("sourceChanged() = %v, %v for a touched source", changed, err)
+	}
+
+	// Plans written by hand, without recorded sources, are not checked
+	if changed, err := (PlanEntry{Src: src}).sourceChanged(); changed || err != nil {
+		t.Errorf("sourceChanged() = %v, %v without a recorded source", changed, err)
+	}
+	if err := os.Remove(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.sourceChanged(); err == nil {
+		t.Error("sourceChanged() of a missing source succeeded")
+	}
+}
+
+func TestExecuteMtimeDated(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	paths := []string{filepath.Join(src, "IMG_0001.jpg"), filepath.Join(src, "IMG_0002.jpg")}
+	writeFiles(t, paths...)
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := []mediaFile{{path: paths[0], date: date, source: SourceMtime}, {path: paths[1], date: date, source: SourceExif}}
+
+	// Modification times are too weak a date to be written into the file
+	opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01", UpdateExif: true, CopyWorkers: 1}
+	stats := NewStats()
+	plan, err := buildPlan(files, opts, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan[0].UpdateExif || plan[0].Source != SourceMtime {
+		t.Errorf("planned update EXIF = %v from %q, want no update from %q", plan[0].UpdateExif, plan[0].Source, SourceMtime)
+	}
+	if err := Execute(plan, opts, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Sorted != 2 || stats.MtimeDated != 1 {
+		t.Errorf("sorted %d files with %d dated by modification time, want 2 and 1", stats.Sorted, stats.MtimeDated)
+	}
+}
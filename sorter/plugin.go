@@ -0,0 +1,146 @@
+package sorter
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// ReasonPlugin skips a file a plugin asked to skip, or failed to route.
+const ReasonPlugin = "plugin"
+
+// Plugin routes files through a long-running process speaking a line
+// oriented JSON protocol, started once and asked about every file in turn.
+//
+// For each file, one PluginRequest is written to the plugin's standard input
+// as a single line of JSON, and the plugin answers with one PluginResponse on
+// a single line of its standard output before the next request is sent. The
+// plugin's standard error is passed through to the sorter's. Closing its
+// standard input ends the run, and the plugin should then exit.
+//
+// A response with skip set, or with an error, skips the file, the error being
+// logged. Otherwise a non-empty action of copy, move or skip, and a non-empty
+// dest, replace the proposed ones, and empty fields keep them. A plugin that
+// exits, or answers with anything else than a response, aborts the run.
+type Plugin struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// PluginRequest is the line sent to a plugin for every file.
+type PluginRequest struct {
+	Path   string                 `json:"path"`
+	Date   time.Time              `json:"date"`
+	Fields map[string]interface{} `json:"fields"`
+	Dest   string                 `json:"dest"`
+	Action string                 `json:"action"`
+}
+
+// PluginResponse is the line a plugin answers every request with.
+type PluginResponse struct {
+	Action string `json:"action,omitempty"`
+	Dest   string `json:"dest,omitempty"`
+	Skip   bool   `json:"skip,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StartPlugin starts the plugin command, a program followed by its space
+// separated arguments.
+func StartPlugin(command string) (*Plugin, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty plugin command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "starting plugin %q", command)
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &Plugin{cmd: cmd, stdin: stdin, stdout: scanner}, nil
+}
+
+// OnFile asks the plugin what to do with a file, for use as Options.OnFile.
+func (p *Plugin) OnFile(ctx FileContext) Decision {
+	resp, err := p.ask(PluginRequest{Path: ctx.Path, Date: ctx.Date, Fields: ctx.Fields, Dest: ctx.Dest, Action: ctx.Action})
+	if err != nil {
+		log.Error("Error while asking plugin, aborting", "src", ctx.Path, "err", err)
+		return Decision{Abort: true}
+	}
+	if resp.Error != "" {
+		log.Error("Plugin failed to route file, skipping it", "src", ctx.Path, "err", resp.Error)
+		return Decision{Action: ActionSkip, Reason: ReasonPlugin}
+	}
+	if resp.Skip {
+		return Decision{Action: ActionSkip, Reason: ReasonPlugin}
+	}
+	switch resp.Action {
+	case "", ActionCopy, ActionMove:
+	case ActionSkip:
+		return Decision{Action: ActionSkip, Reason: ReasonPlugin}
+	default:
+		log.Error("Plugin answered an unknown action, aborting", "src", ctx.Path, "action", resp.Action)
+		return Decision{Abort: true}
+	}
+	return Decision{Dest: resp.Dest, Action: resp.Action}
+}
+
+// ask sends a request to the plugin and reads back its response.
+func (p *Plugin) ask(req PluginRequest) (PluginResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var resp PluginResponse
+	line, err := json.Marshal(req)
+	if err != nil {
+		return resp, errors.WithStack(err)
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		return resp, errors.Wrap(err, "writing to plugin")
+	}
+	if !p.stdout.Scan() {
+		if err := p.stdout.Err(); err != nil {
+			return resp, errors.Wrap(err, "reading from plugin")
+		}
+		return resp, errors.New("plugin exited")
+	}
+	if err := json.Unmarshal(p.stdout.Bytes(), &resp); err != nil {
+		return resp, errors.Wrapf(err, "invalid plugin response %q", p.stdout.Text())
+	}
+	return resp, nil
+}
+
+// Close closes the plugin's standard input and waits for it to exit. Only
+// the first call closes it, later ones return the same error, and closing a
+// nil plugin does nothing.
+func (p *Plugin) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.closeOnce.Do(func() {
+		p.stdin.Close()
+		p.closeErr = errors.WithStack(p.cmd.Wait())
+	})
+	return p.closeErr
+}
@@ -0,0 +1,126 @@
+package sorter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pluginHelperEnv names the file the stub plugin writes once its standard
+// input is closed, and makes the test binary act as the plugin.
+const pluginHelperEnv = "SORTER_TEST_PLUGIN_DONE"
+
+// TestPluginHelper is not a test but the stub plugin started by the plugin
+// tests, answering every request depending on its path.
+func TestPluginHelper(t *testing.T) {
+	done := os.Getenv(pluginHelperEnv)
+	if done == "" {
+		t.Skip("only run as a plugin")
+	}
+	in := bufio.NewScanner(os.Stdin)
+	for in.Scan() {
+		var req PluginRequest
+		if err := json.Unmarshal(in.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		var resp PluginResponse
+		switch name := filepath.Base(req.Path); {
+		case strings.HasPrefix(name, "skip"):
+			resp.Skip = true
+		case strings.HasPrefix(name, "error"):
+			resp.Error = "no camera"
+		case strings.HasPrefix(name, "move"):
+			resp.Action, resp.Dest = ActionMove, "/dest/moved/"+name
+		case strings.HasPrefix(name, "unknown"):
+			resp.Action = "delete"
+		case strings.HasPrefix(name, "bad"):
+			fmt.Println("not json")
+			continue
+		case strings.HasPrefix(name, "echo"):
+			resp.Dest = fmt.Sprintf("%s|%s|%s|%v", req.Dest, req.Action, req.Date.Format(time.RFC3339), req.Fields["Model"])
+		}
+		line, _ := json.Marshal(resp)
+		fmt.Println(string(line))
+	}
+	if err := os.WriteFile(done, nil, 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	os.Exit(0)
+}
+
+func TestPlugin(t *testing.T) {
+	done := filepath.Join(t.TempDir(), "done")
+	t.Setenv(pluginHelperEnv, done)
+	plugin, err := StartPlugin(os.Args[0] + " -test.run=^TestPluginHelper$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.Close()
+
+	date := time.Date(2023, 5, 14, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		path string
+		want Decision
+	}{
+		{"/src/keep.jpg", Decision{}},
+		{"/src/move.jpg", Decision{Dest: "/dest/moved/move.jpg", Action: ActionMove}},
+		{"/src/echo.jpg", Decision{Dest: "/dest/2023/05/echo.jpg|copy|2023-05-14T10:30:00Z|iPhone 12"}},
+		{"/src/skip.jpg", Decision{Action: ActionSkip, Reason: ReasonPlugin}},
+		{"/src/error.jpg", Decision{Action: ActionSkip, Reason: ReasonPlugin}},
+		{"/src/unknown.jpg", Decision{Abort: true}},
+		{"/src/bad.jpg", Decision{Abort: true}},
+		{"/src/keep2.jpg", Decision{}},
+	}
+	for _, tt := range tests {
+		got := plugin.OnFile(FileContext{
+			Path:   tt.path,
+			Date:   date,
+			Fields: map[string]interface{}{"Model": "iPhone 12"},
+			Dest:   "/dest/2023/05/" + filepath.Base(tt.path),
+			Action: ActionCopy,
+		})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("OnFile(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+
+	if err := plugin.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if !fileExists(done) {
+		t.Error("the plugin did not see its input closed")
+	}
+	if err := plugin.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+	var nilPlugin *Plugin
+	if err := nilPlugin.Close(); err != nil {
+		t.Errorf("Close() on a nil plugin error = %v", err)
+	}
+}
+
+func TestPluginExited(t *testing.T) {
+	plugin, err := StartPlugin("true")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer plugin.Close()
+	got := plugin.OnFile(FileContext{Path: "/src/keep.jpg"})
+	if !got.Abort {
+		t.Errorf("OnFile() = %+v after the plugin exited, want an abort", got)
+	}
+}
+
+func TestStartPluginEmpty(t *testing.T) {
+	if _, err := StartPlugin("  "); err == nil {
+		t.Error("StartPlugin() error = nil for an empty command")
+	}
+}
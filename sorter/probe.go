@@ -0,0 +1,34 @@
+package sorter
+
+import "time"
+
+// ProbeResult describes the dates found for a single source file. Dates that
+// were not found are zero.
+type ProbeResult struct {
+	Path     string
+	ExifDate time.Time
+	NameDate time.Time
+	Date     time.Time
+	Source   DateSource
+	Err      error
+}
+
+// Probe walks the source directory and reports the dates found for every
+// file, and the one it would be sorted by, without touching the filesystem.
+func Probe(opts Options, stats *Stats) ([]ProbeResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	var results []ProbeResult
+	for _, file := range collectFiles(opts, stats) {
+		results = append(results, ProbeResult{
+			Path:     file.path,
+			ExifDate: file.exifDate,
+			NameDate: file.nameDate,
+			Date:     file.date,
+			Source:   file.source,
+			Err:      file.err,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,35 @@
+package sorter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ProvenanceExt is appended to the name of a sorted file to name the sidecar
+// recording where it came from.
+const ProvenanceExt = ".origin"
+
+// provenance is the content of a provenance sidecar.
+type provenance struct {
+	Src    string    `json:"src"`
+	Action string    `json:"action"`
+	Run    time.Time `json:"run"`
+}
+
+// writeProvenance writes the sidecar recording the original path of the file
+// an entry placed, and the start of the run that placed it.
+func writeProvenance(entry PlanEntry, run time.Time) error {
+	src, err := filepath.Abs(entry.Src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data, err := json.MarshalIndent(provenance{Src: src, Action: entry.Action, Run: run}, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(entry.Dest+ProvenanceExt, append(data, '\n'), 0o644))
+}
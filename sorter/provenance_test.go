@@ -0,0 +1,42 @@
+package sorter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecuteProvenance(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src", "IMG_0001.jpg"), filepath.Join(dir, "dest", "IMG_0001.jpg")
+	writeFiles(t, src)
+	stats := NewStats()
+	plan := []PlanEntry{{Src: src, Dest: dest, Action: ActionMove}}
+	if err := Execute(plan, Options{Provenance: true, CopyWorkers: 1}, stats); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(dest + ProvenanceExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got provenance
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid provenance %s: %v", data, err)
+	}
+	if got.Src != src || got.Action != ActionMove || !got.Run.Equal(stats.start) {
+		t.Errorf("provenance = %+v, want %s moved in the run of %v", got, src, stats.start)
+	}
+
+	// Without the option no sidecar is written
+	other := filepath.Join(dir, "src", "IMG_0002.jpg")
+	writeFiles(t, other)
+	plan = []PlanEntry{{Src: other, Dest: filepath.Join(dir, "dest", "IMG_0002.jpg"), Action: ActionMove}}
+	if err := Execute(plan, Options{CopyWorkers: 1}, NewStats()); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(plan[0].Dest + ProvenanceExt) {
+		t.Error("provenance written without the option")
+	}
+}
@@ -0,0 +1,61 @@
+package sorter
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// rawExtensions are the extensions of the camera RAW formats sorted as
+// photos.
+var rawExtensions = []string{".arw", ".cr2", ".cr3", ".dng", ".nef", ".orf", ".raf", ".rw2"}
+
+func init() {
+	for _, ext := range rawExtensions {
+		mediaExtensions[ext] = kindImage
+	}
+}
+
+// isRaw reports whether path is a camera RAW file.
+func isRaw(path string) bool {
+	ext := fileExt(path)
+	for _, raw := range rawExtensions {
+		if ext == raw {
+			return true
+		}
+	}
+	return false
+}
+
+// pairRawFiles returns the index of the RAW file of every companion among
+// files, such as the JPEG a camera shot along with it, keyed by the index of
+// the companion. Companions share the base name of the RAW file in the same
+// directory.
+func pairRawFiles(files []mediaFile) map[int]int {
+	raws := make(map[string]int)
+	for i, file := range files {
+		if isRaw(file.path) {
+			raws[strings.ToLower(strings.TrimSuffix(file.path, filepath.Ext(file.path)))] = i
+		}
+	}
+
+	pairs := make(map[int]int)
+	if len(raws) == 0 {
+		return pairs
+	}
+	for i, file := range files {
+		if isRaw(file.path) {
+			continue
+		}
+		if j, ok := raws[strings.ToLower(strings.TrimSuffix(file.path, filepath.Ext(file.path)))]; ok {
+			pairs[i] = j
+		}
+	}
+	return pairs
+}
+
+// extensionFolder returns the subfolder that files with the extension of
+// path are routed to within their date folder, or an empty string. Folders
+// are keyed by lowercase extension without the dot.
+func extensionFolder(path string, folders map[string]string) string {
+	return folders[strings.TrimPrefix(fileExt(path), ".")]
+}
@@ -0,0 +1,46 @@
+package sorter
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPairRawFiles(t *testing.T) {
+	files := []mediaFile{
+		{path: "src/DSC_0001.NEF"},
+		{path: "src/DSC_0001.JPG"},
+		{path: "src/dsc_0002.jpg"},
+		{path: "src/DSC_0002.CR3"},
+		// Companions pair with a RAW file of the same directory only
+		{path: "src/other/DSC_0001.JPG"},
+		{path: "src/DSC_0003.JPG"},
+	}
+	want := map[int]int{1: 0, 2: 3}
+	if got := pairRawFiles(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("pairRawFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildPlanExtensionFolders(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	paths := []string{filepath.Join(src, "DSC_0001.NEF"), filepath.Join(src, "DSC_0001.JPG"), filepath.Join(src, "DSC_0002.MP4")}
+	writeFiles(t, paths...)
+
+	// The JPEG was written a moment after midnight, yet stays by its RAW file
+	shot := time.Date(2023, 5, 1, 23, 59, 59, 0, time.UTC)
+	files := []mediaFile{{path: paths[0], date: shot}, {path: paths[1], date: shot.Add(2 * time.Second)}, {path: paths[2], date: shot}}
+	opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01/02", ExtensionFolders: map[string]string{"nef": "RAW", "jpg": "JPG"}}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := filepath.Join(dest, "2023", "05", "01")
+	checkDests(t, plan, []string{
+		filepath.Join(day, "RAW", "DSC_0001.NEF"),
+		filepath.Join(day, "JPG", "DSC_0001.JPG"),
+		filepath.Join(day, "DSC_0002.MP4"),
+	})
+}
@@ -0,0 +1,54 @@
+package sorter
+
+import (
+	"github.com/charmbracelet/log"
+)
+
+// ReasonResolution skips an image smaller than Options.MinWidth by
+// Options.MinHeight.
+const ReasonResolution = "resolution"
+
+// dimensions returns the width and height exiftool reported for a file.
+func dimensions(fields map[string]interface{}) (float64, float64, bool) {
+	width, widthOK := fields["ImageWidth"].(float64)
+	height, heightOK := fields["ImageHeight"].(float64)
+	return width, height, widthOK && heightOK && width > 0 && height > 0
+}
+
+// belowResolution reports whether an image is smaller than width by height,
+// in either orientation, so portrait photos meet a landscape minimum. Images
+// of unknown dimensions are below it only when unknown is set.
+func belowResolution(fields map[string]interface{}, width, height int, unknown bool) bool {
+	w, h, ok := dimensions(fields)
+	if !ok {
+		return unknown
+	}
+	if w < h {
+		w, h = h, w
+	}
+	long, short := float64(width), float64(height)
+	if long < short {
+		long, short = short, long
+	}
+	return w < long || h < short
+}
+
+// filterResolution drops the images below the minimum resolution of the
+// options, from the metadata read while dating them. Videos and other files
+// are kept whatever their size.
+func filterResolution(files []mediaFile, opts Options, stats *Stats) []mediaFile {
+	if opts.MinWidth <= 0 && opts.MinHeight <= 0 {
+		return files
+	}
+	var kept []mediaFile
+	for _, file := range files {
+		if mediaKind(file.path) == kindImage && belowResolution(file.fields, opts.MinWidth, opts.MinHeight, opts.SkipUnknownResolution) {
+			log.Debug("Skipping low resolution image", "src", file.path)
+			stats.inc(&stats.SkippedResolution)
+			stats.AddSkip(file.path, ReasonResolution)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
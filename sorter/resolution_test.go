@@ -0,0 +1,45 @@
+package sorter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterResolution(t *testing.T) {
+	size := func(width, height float64) map[string]interface{} {
+		return map[string]interface{}{"ImageWidth": width, "ImageHeight": height}
+	}
+	files := []mediaFile{
+		{path: "src/large.jpg", fields: size(4032, 3024)},
+		{path: "src/portrait.jpg", fields: size(3024, 4032)},
+		{path: "src/thumbnail.jpg", fields: size(320, 240)},
+		{path: "src/narrow.jpg", fields: size(4032, 100)},
+		{path: "src/unknown.jpg"},
+		{path: "src/clip.mp4", fields: size(320, 240)},
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"no minimum", Options{}, []string{"src/large.jpg", "src/portrait.jpg", "src/thumbnail.jpg", "src/narrow.jpg", "src/unknown.jpg", "src/clip.mp4"}},
+		{"minimum", Options{MinWidth: 1920, MinHeight: 1080}, []string{"src/large.jpg", "src/portrait.jpg", "src/unknown.jpg", "src/clip.mp4"}},
+		{"portrait minimum", Options{MinWidth: 1080, MinHeight: 1920}, []string{"src/large.jpg", "src/portrait.jpg", "src/unknown.jpg", "src/clip.mp4"}},
+		{"skip unknown", Options{MinWidth: 1920, MinHeight: 1080, SkipUnknownResolution: true}, []string{"src/large.jpg", "src/portrait.jpg", "src/clip.mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := NewStats()
+			var got []string
+			for _, file := range filterResolution(files, tt.opts, stats) {
+				got = append(got, file.path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterResolution() kept %v, want %v", got, tt.want)
+			}
+			if skipped := len(files) - len(tt.want); stats.SkippedResolution != skipped {
+				t.Errorf("SkippedResolution = %d, want %d", stats.SkippedResolution, skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// Extractor reads the metadata of files. *exiftool.Exiftool is an Extractor,
+// and embedders can provide their own, such as canned metadata in tests.
+type Extractor interface {
+	ExtractMetadata(files ...string) []exiftool.FileMetadata
+}
+
+// Result is the date extracted for a file, along with where it came from and
+// the metadata it was read from.
+type Result struct {
+	Path    string
+	Date    time.Time
+	Source  DateSource
+	Fields  map[string]interface{}
+	ModTime time.Time
+}
+
+// NewExtractor starts the exiftool process configured by opts, for use with
+// ExtractDate. It serves one extraction at a time and must be closed once
+// done.
+func NewExtractor(opts Options) (*exiftool.Exiftool, error) {
+	return newExiftool(opts)
+}
+
+// ExtractDate dates the file at path from the metadata extractor reports for
+// it, falling back to its name and modification time as Sort does. The file
+// is only stat'ed for its modification time, which is left zero when it
+// cannot be read. It returns ErrNoDate when no date is found.
+func ExtractDate(extractor Extractor, path string, opts Options) (Result, error) {
+	file := mediaFile{path: path, srcFolder: sourceFolder(opts.Src, path)}
+	if info, err := os.Stat(path); err == nil {
+		file.size, file.modTime = info.Size(), info.ModTime()
+	}
+	err := extractDate(extractor, &file, opts)
+	file.date = opts.truncateDate(file.date)
+	return Result{Path: path, Date: file.date, Source: file.source, Fields: file.fields, ModTime: file.modTime}, err
+}
+
+// ResolveDestination returns the path a dated file is sorted to below
+// opts.Dest, or to the quarantine when its date is implausible. It sees the
+// file alone, so the folder never flattens to MonthFormat, {seq} and {index}
+// are 1, and no other file or the destination is checked for conflicts. It
+// returns ErrNoDate for a result without a date.
+func ResolveDestination(result Result, opts Options) (string, error) {
+	if result.Date.IsZero() {
+		return "", errors.WithStack(ErrNoDate)
+	}
+	file := mediaFile{
+		path:      result.Path,
+		srcFolder: sourceFolder(opts.Src, result.Path),
+		date:      result.Date,
+		source:    result.Source,
+		fields:    result.Fields,
+		modTime:   result.ModTime,
+	}
+	if !isPlausibleDate(file.date, opts.MinYear) && opts.QuarantineDir != "" {
+		return filepath.Join(opts.QuarantineDir, filepath.Base(file.path)), nil
+	}
+	ctx := fileTokens(file, opts)
+	ctx.seq, ctx.index = 1, 1
+	return filepath.Join(opts.Dest, fileFolder(file, false, opts), fileName(file, ctx, false, opts, log.Default())), nil
+}
@@ -0,0 +1,107 @@
+package sorter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestExtractDate(t *testing.T) {
+	opts := Options{Tags: DefaultDateTags, FilenamePatterns: DefaultFilenamePatterns, DatePrefer: PreferExif}
+	extractor := fakeExtractor{
+		"src/IMG_0001.jpg":             {"DateTimeOriginal": "2021:07:04 23:10:00"},
+		"src/PANO_20230501_120000.jpg": {},
+		"src/IMG_0002.jpg":             {},
+	}
+	tests := []struct {
+		path   string
+		want   time.Time
+		source DateSource
+		err    error
+	}{
+		{"src/IMG_0001.jpg", time.Date(2021, 7, 4, 23, 10, 0, 0, time.UTC), SourceExif, nil},
+		{"src/PANO_20230501_120000.jpg", time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), SourceFilename, nil},
+		{"src/IMG_0002.jpg", time.Time{}, "", ErrNoDate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			result, err := ExtractDate(extractor, tt.path, opts)
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("ExtractDate() error = %v, want %v", err, tt.err)
+			}
+			if result.Path != tt.path {
+				t.Errorf("path = %q, want %q", result.Path, tt.path)
+			}
+			if !result.Date.Equal(tt.want) || result.Source != tt.source {
+				t.Errorf("date = %v from %q, want %v from %q", result.Date, result.Source, tt.want, tt.source)
+			}
+		})
+	}
+}
+
+func TestExtractDateGranularity(t *testing.T) {
+	opts := Options{Tags: DefaultDateTags, DateGranularity: GranularityDay}
+	extractor := fakeExtractor{"src/IMG_0001.jpg": {"DateTimeOriginal": "2021:07:04 23:10:00"}}
+	result, err := ExtractDate(extractor, "src/IMG_0001.jpg", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2021, 7, 4, 0, 0, 0, 0, time.UTC); !result.Date.Equal(want) {
+		t.Errorf("date = %v, want %v", result.Date, want)
+	}
+}
+
+func TestResolveDestination(t *testing.T) {
+	date := time.Date(2023, 5, 1, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		name   string
+		result Result
+		opts   Options
+		want   string
+		err    error
+	}{
+		{
+			name:   "date folders",
+			result: Result{Path: "src/IMG_0001.jpg", Date: date, Source: SourceExif},
+			opts:   Options{Dest: "dest", FolderFormat: "2006/01/02"},
+			want:   "dest/2023/05/01/IMG_0001.jpg",
+		},
+		{
+			name:   "renamed",
+			result: Result{Path: "src/IMG_0001.JPG", Date: date, Source: SourceExif},
+			opts:   Options{Dest: "dest", FolderFormat: "2006/01", NameFormat: "20060102_150405_{seq:3}"},
+			want:   "dest/2023/05/20230501_123456_001.JPG",
+		},
+		{
+			name:   "source folder",
+			result: Result{Path: "src/wedding/IMG_0001.jpg", Date: date, Source: SourceExif},
+			opts:   Options{Src: "src", Dest: "dest", FolderFormat: "2006/{srcfolder}"},
+			want:   "dest/2023/wedding/IMG_0001.jpg",
+		},
+		{
+			name:   "quarantined",
+			result: Result{Path: "src/IMG_0001.jpg", Date: time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC), Source: SourceExif},
+			opts:   Options{Dest: "dest", FolderFormat: "2006/01/02", MinYear: 1990, QuarantineDir: "quarantine"},
+			want:   "quarantine/IMG_0001.jpg",
+		},
+		{
+			name:   "undated",
+			result: Result{Path: "src/IMG_0001.jpg"},
+			opts:   Options{Dest: "dest", FolderFormat: "2006/01/02"},
+			err:    ErrNoDate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveDestination(tt.result, tt.opts)
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("ResolveDestination() error = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && got != filepath.FromSlash(tt.want) {
+				t.Errorf("ResolveDestination() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package sorter
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FolderRule routes the files matching a predicate to their own folder
+// template. Predicates are hasGPS, image, video, raw, document, screenshot,
+// has:Tag for files carrying the metadata tag Tag, and default, which matches
+// every file. A leading ! negates a predicate.
+type FolderRule struct {
+	Predicate string
+	Format    string
+}
+
+// rulePredicates are the predicates a FolderRule can test, by name.
+var rulePredicates = map[string]func(file mediaFile) bool{
+	"default": func(mediaFile) bool { return true },
+	"hasGPS": func(file mediaFile) bool {
+		return file.fields["GPSLatitude"] != nil && file.fields["GPSLongitude"] != nil
+	},
+	"image": func(file mediaFile) bool { return mediaKind(file.path) == kindImage },
+	"video": func(file mediaFile) bool { return mediaKind(file.path) == kindVideo },
+	"raw":   func(file mediaFile) bool { return isRaw(file.path) },
+	"document": func(file mediaFile) bool {
+		return mediaKind(file.path) == kindDocument
+	},
+	"screenshot": func(file mediaFile) bool { return file.screenshot },
+}
+
+// ParseFolderRules parses rules written as predicate -> template, separated
+// by semicolons, as in "hasGPS -> {country}/2006; default -> 2006/01/02".
+func ParseFolderRules(value string) ([]FolderRule, error) {
+	var rules []FolderRule
+	for _, text := range strings.Split(value, ";") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		predicate, format, ok := strings.Cut(text, "->")
+		if !ok {
+			return nil, errors.Errorf("invalid folder rule %q, expected predicate -> template", text)
+		}
+		rule := FolderRule{Predicate: strings.TrimSpace(predicate), Format: strings.TrimSpace(format)}
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// validate checks the predicate and template of a rule.
+func (rule FolderRule) validate() error {
+	name := strings.TrimPrefix(rule.Predicate, "!")
+	if _, ok := rulePredicates[name]; !ok && !strings.HasPrefix(name, "has:") {
+		return errors.Errorf("unknown folder rule predicate %q", rule.Predicate)
+	}
+	if rule.Format == "" {
+		return errors.Errorf("folder rule %q has no template", rule.Predicate)
+	}
+	return ValidateTemplate(rule.Format)
+}
+
+// matches reports whether file satisfies the predicate of the rule.
+func (rule FolderRule) matches(file mediaFile) bool {
+	name := strings.TrimPrefix(rule.Predicate, "!")
+	negate := name != rule.Predicate
+	var match bool
+	if tag := strings.TrimPrefix(name, "has:"); tag != name {
+		match = file.fields[tag] != nil
+	} else if predicate, ok := rulePredicates[name]; ok {
+		match = predicate(file)
+	}
+	return match != negate
+}
+
+// ruleFormat returns the template of the first rule file matches, and whether
+// one did.
+func ruleFormat(rules []FolderRule, file mediaFile) (string, bool) {
+	for _, rule := range rules {
+		if rule.matches(file) {
+			return rule.Format, true
+		}
+	}
+	return "", false
+}
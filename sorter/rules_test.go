@@ -0,0 +1,63 @@
+package sorter
+
+import "testing"
+
+func TestParseFolderRules(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []FolderRule
+		ok    bool
+	}{
+		{"hasGPS -> {country}/2006; default -> 2006/01/02", []FolderRule{{"hasGPS", "{country}/2006"}, {"default", "2006/01/02"}}, true},
+		{"screenshot -> Screenshots", []FolderRule{{"screenshot", "Screenshots"}}, true},
+		{"!image -> Other; ", []FolderRule{{"!image", "Other"}}, true},
+		{"has:Rating -> Rated/2006", []FolderRule{{"has:Rating", "Rated/2006"}}, true},
+		{"hasGPS", nil, false},
+		{"panorama -> Panoramas", nil, false},
+		{"video -> ", nil, false},
+		{"video -> {modl}/2006", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			rules, err := ParseFolderRules(tt.value)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ParseFolderRules() error = %v, want ok %v", err, tt.ok)
+			}
+			if len(rules) != len(tt.want) {
+				t.Fatalf("ParseFolderRules() = %v, want %v", rules, tt.want)
+			}
+			for i := range rules {
+				if rules[i] != tt.want[i] {
+					t.Errorf("rule %d = %v, want %v", i, rules[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRuleFormat(t *testing.T) {
+	rules, err := ParseFolderRules("hasGPS -> Places/2006; raw -> Raw/2006; has:Rating -> Rated; !image -> Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gps := map[string]interface{}{"GPSLatitude": 48.85, "GPSLongitude": 2.35}
+	tests := []struct {
+		file  mediaFile
+		want  string
+		ruled bool
+	}{
+		{mediaFile{path: "IMG_0001.jpg", fields: gps}, "Places/2006", true},
+		{mediaFile{path: "DSC_0001.NEF", fields: gps}, "Places/2006", true},
+		{mediaFile{path: "DSC_0001.NEF"}, "Raw/2006", true},
+		{mediaFile{path: "IMG_0001.jpg", fields: map[string]interface{}{"Rating": 5.0}}, "Rated", true},
+		{mediaFile{path: "VID_0001.mp4"}, "Other", true},
+		// Files matching no rule keep the folder format of the options
+		{mediaFile{path: "IMG_0001.jpg", fields: map[string]interface{}{"GPSLatitude": 48.85}}, "", false},
+	}
+	for _, tt := range tests {
+		got, ruled := ruleFormat(rules, tt.file)
+		if got != tt.want || ruled != tt.ruled {
+			t.Errorf("ruleFormat(%s, %v) = %q, %v, want %q, %v", tt.file.path, tt.file.fields, got, ruled, tt.want, tt.ruled)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package sorter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3Storage stores files as objects of an S3 bucket, keyed by their slash
+// separated destination path. Credentials and region are read from the
+// standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and
+// AWS_REGION variables, and AWS_ENDPOINT_URL points it at S3 compatible
+// services such as MinIO, which are addressed path style. Files larger than
+// PartSize are uploaded in parts.
+type S3Storage struct {
+	Bucket       string
+	Region       string
+	Endpoint     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+
+	// PartSize is the size of the parts of multipart uploads, and the size
+	// above which files are uploaded in parts. It is DefaultS3PartSize when
+	// zero.
+	PartSize int64
+}
+
+// DefaultS3PartSize is the part size of multipart uploads, unless configured
+// otherwise. Single uploads are limited to 5GB, and uploads to 10000 parts.
+const DefaultS3PartSize = 64 << 20
+
+// s3MaxParts is the most parts an object can be uploaded in.
+const s3MaxParts = 10000
+
+// S3Timeout bounds every request to S3, which carries at most a part of a
+// file, so a stalled connection fails its copy rather than hanging it.
+const S3Timeout = 10 * time.Minute
+
+// s3Client sends the requests of storages without a client of their own.
+var s3Client = &http.Client{Timeout: S3Timeout}
+
+// NewS3Storage returns the storage for bucket configured from the
+// environment.
+func NewS3Storage(bucket string) (*S3Storage, error) {
+	if bucket == "" {
+		return nil, errors.New("missing S3 bucket")
+	}
+	s := &S3Storage{
+		Bucket:       bucket,
+		Region:       os.Getenv("AWS_REGION"),
+		Endpoint:     strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       s3Client,
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, errors.New("missing S3 credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+// Exists implements Storage.
+func (s *S3Storage) Exists(path string) (bool, error) {
+	resp, err := s.head(path)
+	if err != nil {
+		return false, err
+	}
+	return resp != nil, nil
+}
+
+// Same implements Storage. Objects uploaded in a single part carry the MD5 of
+// their content as ETag, and objects uploaded in parts the MD5 of the MD5s of
+// their parts followed by the number of parts, which are compared with the
+// local file split in parts as Put would. Objects uploaded with another part
+// size never compare the same.
+func (s *S3Storage) Same(src, path string) (bool, error) {
+	resp, err := s.head(path)
+	if err != nil || resp == nil {
+		return false, err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if resp.ContentLength != info.Size() {
+		return false, nil
+	}
+	etag := strings.Trim(resp.Header.Get("ETag"), `"`)
+	if strings.Contains(etag, "-") {
+		multipartETag, err := s.multipartETag(src, info.Size())
+		return etag == multipartETag, err
+	}
+	sum, err := md5File(src)
+	if err != nil {
+		return false, err
+	}
+	return etag == hex.EncodeToString(sum), nil
+}
+
+// partSize returns the size of the parts of multipart uploads.
+func (s *S3Storage) partSize() int64 {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return DefaultS3PartSize
+}
+
+// Put implements Storage. Every part is uploaded with its MD5, which S3
+// checks before storing it. Files larger than the part size are uploaded in
+// parts, and those too large for s3MaxParts parts are refused.
+func (s *S3Storage) Put(src, path string, bufSize int) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	partSize := s.partSize()
+	if info.Size() <= partSize {
+		_, err := s.putPart(path, nil, io.NewSectionReader(f, 0, info.Size()))
+		return errors.Wrapf(err, "uploading %q to %q", src, path)
+	}
+	if parts := (info.Size() + partSize - 1) / partSize; parts > s3MaxParts {
+		return errors.Errorf("uploading %q to %q: %d bytes need %d parts of %d bytes, more than the %d S3 allows", src, path, info.Size(), parts, partSize, s3MaxParts)
+	}
+	return errors.Wrapf(s.putMultipart(f, path, info.Size()), "uploading %q to %q", src, path)
+}
+
+// putPart uploads the content of part to the object at path, or as one of
+// its parts with the query of a multipart upload, and returns its ETag.
+func (s *S3Storage) putPart(path string, query map[string]string, part *io.SectionReader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, part); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(path)+s3Query(query), part)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	req.ContentLength = part.Size()
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(s3Error(resp))
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// s3CompletedPart is an uploaded part, as listed to complete a multipart
+// upload.
+type s3CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// putMultipart uploads the size bytes of f to the object at path in parts of
+// the part size. The upload is aborted when a part fails, so no parts are
+// left stored.
+func (s *S3Storage) putMultipart(f *os.File, path string, size int64) error {
+	uploadID, err := s.createMultipart(path)
+	if err != nil {
+		return err
+	}
+	var parts []s3CompletedPart
+	partSize := s.partSize()
+	for offset := int64(0); offset < size; offset += partSize {
+		number := len(parts) + 1
+		query := map[string]string{"partNumber": strconv.Itoa(number), "uploadId": uploadID}
+		length := partSize
+		if size-offset < length {
+			length = size - offset
+		}
+		etag, err := s.putPart(path, query, io.NewSectionReader(f, offset, length))
+		if err != nil {
+			s.abortMultipart(path, uploadID)
+			return errors.Wrapf(err, "part %d", number)
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: number, ETag: etag})
+	}
+	if err := s.completeMultipart(path, uploadID, parts); err != nil {
+		s.abortMultipart(path, uploadID)
+		return err
+	}
+	return nil
+}
+
+// createMultipart starts a multipart upload to the object at path and
+// returns its ID.
+func (s *S3Storage) createMultipart(path string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, s.objectURL(path)+s3Query(map[string]string{"uploads": ""}), nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("starting multipart upload: %s", s3Error(resp))
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", errors.Errorf("starting multipart upload: no upload ID in response")
+	}
+	return result.UploadID, nil
+}
+
+// completeMultipart assembles the uploaded parts into the object at path.
+// S3 may report a failure to complete in the body of a 200 response.
+func (s *S3Storage) completeMultipart(path, uploadID string, parts []s3CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.objectURL(path)+s3Query(map[string]string{"uploadId": uploadID}), bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("completing multipart upload: %s", s3Error(resp))
+	}
+	var result struct {
+		XMLName xml.Name
+		Code    string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return errors.Wrap(err, "completing multipart upload")
+	}
+	if result.XMLName.Local == "Error" {
+		return errors.Errorf("completing multipart upload: %s", result.Code)
+	}
+	return nil
+}
+
+// abortMultipart drops the parts of a failed multipart upload, which S3
+// would otherwise keep and bill for.
+func (s *S3Storage) abortMultipart(path, uploadID string) {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(path)+s3Query(map[string]string{"uploadId": uploadID}), nil)
+	if err != nil {
+		return
+	}
+	if resp, err := s.do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// multipartETag returns the ETag S3 gives the file at src once uploaded in
+// parts of the part size: the MD5 of the MD5s of its parts, followed by the
+// number of parts.
+func (s *S3Storage) multipartETag(src string, size int64) (string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+	partSize := s.partSize()
+	sums := md5.New()
+	parts := 0
+	for offset := int64(0); offset < size; offset += partSize {
+		h := md5.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, offset, partSize)); err != nil {
+			return "", errors.WithStack(err)
+		}
+		sums.Write(h.Sum(nil))
+		parts++
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), nil
+}
+
+// head returns the response to a HEAD request for the object at path, or nil
+// when there is no such object.
+func (s *S3Storage) head(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(path), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "checking %q", path)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	return nil, errors.Errorf("checking %q: %s", path, resp.Status)
+}
+
+// objectURL returns the URL of the object at path.
+func (s *S3Storage) objectURL(path string) string {
+	key := s3URIEncode(strings.TrimPrefix(filepath.ToSlash(path), "/"), false)
+	if s.Endpoint != "" {
+		return s.Endpoint + "/" + s3URIEncode(s.Bucket, true) + "/" + key
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, key)
+}
+
+// s3Query returns the query string of a request with params, starting with
+// its question mark. Parameters are sorted and encoded as signatures encode
+// them, so the query sent is its own canonical form.
+func s3Query(params map[string]string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(params))
+	for name, value := range params {
+		pairs = append(pairs, s3URIEncode(name, true)+"="+s3URIEncode(value, true))
+	}
+	sort.Strings(pairs)
+	return "?" + strings.Join(pairs, "&")
+}
+
+// do signs a request with AWS Signature Version 4 and sends it. Payloads are
+// left unsigned, since uploads are checked against their Content-MD5.
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signer := sigV4{AccessKey: s.AccessKey, SecretKey: s.SecretKey, SessionToken: s.SessionToken, Region: s.Region, Service: "s3"}
+	signer.sign(req, "UNSIGNED-PAYLOAD", time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = s3Client
+	}
+	return client.Do(req)
+}
+
+// sigV4 signs requests to an AWS service with Signature Version 4. Only what
+// S3Storage sends is covered: signatures in the Authorization header, with
+// payloads hashed up front or left unsigned. Presigned URLs and chunked
+// payload signing are not supported.
+type sigV4 struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Region       string
+	Service      string
+}
+
+// sign sets the Authorization of req, signing its method, path, query and
+// every header along with payloadHash, the hex encoded SHA-256 of its body or
+// UNSIGNED-PAYLOAD, at the time now.
+func (sig sigV4) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + sig.Region + "/" + sig.Service + "/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sig.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sig.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		if strings.EqualFold(name, "Authorization") {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.RawQuery),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + sig.SecretKey)
+	for _, part := range []string{now.Format("20060102"), sig.Region, sig.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sig.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns a raw query as signatures expect it: every name and
+// value encoded alike, sorted by name and then value.
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	var pairs []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		name, value, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		pairs = append(pairs, s3URIEncode(name, true)+"="+s3URIEncode(value, true))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3URIEncode percent-encodes every byte but the unreserved characters of RFC
+// 3986, as AWS signatures require, keeping slashes unless encodeSlash is set.
+func s3URIEncode(value string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', strings.IndexByte("-_.~", b) >= 0:
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// s3Error describes a failed S3 response with the error code in its body.
+func s3Error(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if start, end := strings.Index(string(body), "<Code>"), strings.Index(string(body), "</Code>"); start >= 0 && end > start {
+		return resp.Status + " " + string(body)[start+len("<Code>"):end]
+	}
+	return resp.Status
+}
+
+// md5File returns the MD5 checksum of the file at path.
+func md5File(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,321 @@
+package sorter
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// emptyPayloadHash is the hex encoded SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// The vectors below are from the AWS Signature Version 4 test suite and the
+// IAM example of the signing documentation.
+func TestSigV4Sign(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		url           string
+		service       string
+		headers       map[string]string
+		signedHeaders string
+		signature     string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "post-vanilla",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			service:       "service",
+			signedHeaders: "host;x-amz-date",
+			signature:     "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:          "iam-list-users",
+			method:        http.MethodGet,
+			url:           "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			service:       "iam",
+			headers:       map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			signedHeaders: "content-type;host;x-amz-date",
+			signature:     "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	signer := sigV4{
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:    "us-east-1",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			signer := signer
+			signer.Service = tt.service
+			signer.sign(req, emptyPayloadHash, now)
+
+			want := fmt.Sprintf("AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/%s/aws4_request, SignedHeaders=%s, Signature=%s",
+				tt.service, tt.signedHeaders, tt.signature)
+			if got := req.Header.Get("Authorization"); got != want {
+				t.Errorf("Authorization = %q, want %q", got, want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want %q", got, "20150830T123600Z")
+			}
+		})
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"uploads", "uploads="},
+		{"uploadId=a%2Fb&partNumber=2", "partNumber=2&uploadId=a%2Fb"},
+		{"Param=value2&Param=value1", "Param=value1&Param=value2"},
+		{"key=a b~", "key=a%20b~"},
+	}
+	for _, tt := range tests {
+		if got := canonicalQuery(tt.raw); got != tt.want {
+			t.Errorf("canonicalQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+// fakeS3 is an S3 endpoint storing objects and the parts of multipart
+// uploads in memory.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	etags    map[string]string
+	parts    map[string]map[string][]byte
+	requests []string
+	aborted  int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}, parts: map[string]map[string][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	query := r.URL.Query()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RawQuery)
+	key := r.URL.Path
+	switch {
+	case r.Method == http.MethodHead:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.Header().Set("ETag", `"`+f.etags[key]+`"`)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(f.parts)+1)
+		f.parts[id] = map[string][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		sum := md5.Sum(body)
+		etag := hex.EncodeToString(sum[:])
+		if id := query.Get("uploadId"); id != "" {
+			f.parts[id][query.Get("partNumber")] = body
+		} else {
+			f.objects[key], f.etags[key] = body, etag
+		}
+		w.Header().Set("ETag", `"`+etag+`"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		var complete struct {
+			Parts []s3CompletedPart `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body []byte
+		sums := md5.New()
+		for _, part := range complete.Parts {
+			content := f.parts[query.Get("uploadId")][fmt.Sprint(part.PartNumber)]
+			sum := md5.Sum(content)
+			if part.ETag != `"`+hex.EncodeToString(sum[:])+`"` {
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+				return
+			}
+			body = append(body, content...)
+			sums.Write(sum[:])
+		}
+		f.objects[key] = body
+		f.etags[key] = fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), len(complete.Parts))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete:
+		delete(f.parts, query.Get("uploadId"))
+		f.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeTestFile writes size bytes of varying content to a file and returns
+// its path.
+func writeTestFile(t testing.TB, size int) string {
+	t.Helper()
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	path := filepath.Join(t.TempDir(), "VID_0001.mp4")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestS3PutMultipart(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := &S3Storage{Bucket: "photos", Region: "us-east-1", Endpoint: server.URL, AccessKey: "key", SecretKey: "secret", PartSize: 1000}
+
+	src := writeTestFile(t, 2500)
+	if err := s.Put(src, "2023/05/VID_0001.mp4", 0); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	want, _ := os.ReadFile(src)
+	if got := fake.objects["/photos/2023/05/VID_0001.mp4"]; !bytes.Equal(got, want) {
+		t.Errorf("stored %d bytes, want the %d bytes of the file", len(got), len(want))
+	}
+	if parts := strings.Count(strings.Join(fake.requests, "\n"), "partNumber="); parts != 3 {
+		t.Errorf("uploaded %d parts, want 3: %v", parts, fake.requests)
+	}
+	if fake.aborted != 0 {
+		t.Errorf("aborted %d uploads", fake.aborted)
+	}
+
+	same, err := s.Same(src, "2023/05/VID_0001.mp4")
+	if err != nil || !same {
+		t.Errorf("Same() = %v, %v after a multipart upload, want true", same, err)
+	}
+	s.PartSize = 500
+	if same, err := s.Same(src, "2023/05/VID_0001.mp4"); err != nil || same {
+		t.Errorf("Same() = %v, %v with another part size, want false", same, err)
+	}
+}
+
+func TestS3PutSingle(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := &S3Storage{Bucket: "photos", Region: "us-east-1", Endpoint: server.URL, AccessKey: "key", SecretKey: "secret", PartSize: 1000}
+
+	src := writeTestFile(t, 1000)
+	if err := s.Put(src, "2023/05/VID_0001.mp4", 0); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if len(fake.requests) != 1 || fake.requests[0] != "PUT " {
+		t.Errorf("requests = %v, want a single PUT", fake.requests)
+	}
+	if same, err := s.Same(src, "2023/05/VID_0001.mp4"); err != nil || !same {
+		t.Errorf("Same() = %v, %v, want true", same, err)
+	}
+}
+
+func TestS3PutTooLarge(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s := &S3Storage{Bucket: "photos", Region: "us-east-1", Endpoint: server.URL, AccessKey: "key", SecretKey: "secret", PartSize: 1}
+
+	src := writeTestFile(t, s3MaxParts+1)
+	err := s.Put(src, "2023/05/VID_0001.mp4", 0)
+	if err == nil || !strings.Contains(err.Error(), "parts") {
+		t.Errorf("Put() error = %v, want an error for too many parts", err)
+	}
+	if len(fake.requests) != 0 {
+		t.Errorf("requests = %v, want none", fake.requests)
+	}
+}
+
+func TestS3URIEncode(t *testing.T) {
+	tests := []struct {
+		value       string
+		encodeSlash bool
+		want        string
+	}{
+		{"2023/05/IMG_0001.jpg", false, "2023/05/IMG_0001.jpg"},
+		{"2023/05/IMG_0001.jpg", true, "2023%2F05%2FIMG_0001.jpg"},
+		{"Trip to Paris/a+b=c&d~e", false, "Trip%20to%20Paris/a%2Bb%3Dc%26d~e"},
+		{"été/photo.jpg", false, "%C3%A9t%C3%A9/photo.jpg"},
+	}
+	for _, tt := range tests {
+		if got := s3URIEncode(tt.value, tt.encodeSlash); got != tt.want {
+			t.Errorf("s3URIEncode(%q, %v) = %q, want %q", tt.value, tt.encodeSlash, got, tt.want)
+		}
+	}
+}
+
+// The path of a request is signed as sent, so keys must keep the encoding
+// objectURL gives them once parsed into a request.
+func TestS3ObjectURL(t *testing.T) {
+	tests := []struct {
+		storage S3Storage
+		path    string
+		want    string
+	}{
+		{S3Storage{Bucket: "photos", Region: "eu-west-3"}, "2023/05/IMG_0001.jpg", "https://photos.s3.eu-west-3.amazonaws.com/2023/05/IMG_0001.jpg"},
+		{S3Storage{Bucket: "photos", Endpoint: "http://127.0.0.1:9000"}, "/2023/05/IMG_0001.jpg", "http://127.0.0.1:9000/photos/2023/05/IMG_0001.jpg"},
+		{S3Storage{Bucket: "photos", Region: "us-east-1"}, "Trip to Paris/été+1.jpg", "https://photos.s3.us-east-1.amazonaws.com/Trip%20to%20Paris/%C3%A9t%C3%A9%2B1.jpg"},
+	}
+	for _, tt := range tests {
+		got := tt.storage.objectURL(tt.path)
+		if got != tt.want {
+			t.Errorf("objectURL(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+		req, err := http.NewRequest(http.MethodPut, got, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := req.URL.Scheme + "://" + req.URL.Host + req.URL.EscapedPath(); path != tt.want {
+			t.Errorf("request to %q is sent to %q", tt.want, path)
+		}
+	}
+}
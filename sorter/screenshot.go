@@ -0,0 +1,29 @@
+package sorter
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultScreenshotPattern matches the names phones and desktops give to
+// screenshots, such as Screenshot_20230501-120000.png, Screen Shot
+// 2023-05-01 at 12.00.00.png or Capture d'écran 2023-05-01.png.
+var DefaultScreenshotPattern = regexp.MustCompile(`(?i)^(screenshot|screen shot|scrnshot|capture d.(é|e)cran|bildschirmfoto|schermafbeelding)`)
+
+// screenshotComment is the UserComment iPhones write into screenshots.
+const screenshotComment = "Screenshot"
+
+// isScreenshot reports whether the image at path is a screenshot, from its
+// name matching pattern or the UserComment iPhones mark screenshots with.
+// Dimensions and software names are not considered, since real photos share
+// them too often.
+func isScreenshot(path string, fields map[string]interface{}, pattern *regexp.Regexp) bool {
+	if mediaKind(path) != kindImage {
+		return false
+	}
+	if comment, ok := fields["UserComment"].(string); ok && strings.EqualFold(strings.TrimSpace(comment), screenshotComment) {
+		return true
+	}
+	return pattern != nil && pattern.MatchString(filepath.Base(path))
+}
@@ -0,0 +1,53 @@
+package sorter
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsScreenshot(t *testing.T) {
+	tests := []struct {
+		path   string
+		fields map[string]interface{}
+		want   bool
+	}{
+		{"Screenshot_20230501-120000.png", nil, true},
+		{"Screen Shot 2023-05-01 at 12.00.00.png", nil, true},
+		{"Capture d'écran 2023-05-01.png", nil, true},
+		{"IMG_0001.png", map[string]interface{}{"UserComment": "Screenshot"}, true},
+		{"IMG_0001.jpg", nil, false},
+		{"my screenshot.png", nil, false},
+		{"Screenshot_20230501-120000.mp4", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := isScreenshot(tt.path, tt.fields, DefaultScreenshotPattern); got != tt.want {
+				t.Errorf("isScreenshot(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScreenshotsLiteralFolder(t *testing.T) {
+	if err := ValidateTemplate("Screenshots"); err != nil {
+		t.Fatalf("ValidateTemplate() error = %v, want a literal folder accepted", err)
+	}
+	opts := Options{
+		FolderFormat: "2006/01/02",
+		FolderRules:  []FolderRule{{Predicate: "screenshot", Format: "Screenshots"}},
+	}
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		file mediaFile
+		want string
+	}{
+		{mediaFile{path: "Screenshot_20230501-120000.png", date: date, screenshot: true}, "Screenshots"},
+		{mediaFile{path: "IMG_0001.jpg", date: date}, "2023/05/01"},
+	}
+	for _, tt := range tests {
+		if got := fileFolder(tt.file, false, opts); got != filepath.FromSlash(tt.want) {
+			t.Errorf("fileFolder(%q) = %q, want %q", tt.file.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package sorter
+
+import (
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// sessionDate returns the date matched by pattern in the name of the folder
+// holding the file at path, as for a timelapse folder named 2023-05-01_Sunset.
+// See pathDate for the groups of pattern.
+func sessionDate(path string, pattern *regexp.Regexp, loc *time.Location) (time.Time, bool) {
+	return pathDate(filepath.Base(filepath.Dir(path)), pattern, loc)
+}
+
+// dateSessions dates every file of a session folder by the session date as
+// soon as one of its frames had to be, so the frames of a timelapse or burst
+// sort together. Frames then keep their walk order within the folder, which
+// {seq} numbers them by.
+func dateSessions(files []mediaFile) {
+	sessions := make(map[string]time.Time)
+	for _, file := range files {
+		if file.source == SourceSession {
+			sessions[filepath.Dir(file.path)] = file.date
+		}
+	}
+	for i, file := range files {
+		if date, ok := sessions[filepath.Dir(file.path)]; ok {
+			files[i].date, files[i].source = date, SourceSession
+		}
+	}
+}
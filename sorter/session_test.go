@@ -0,0 +1,63 @@
+package sorter
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestBuildPlanSessions(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	session := filepath.Join(src, "2023-06-10_timelapse")
+	paths := []string{filepath.Join(session, "frame_0001.jpg"), filepath.Join(session, "frame_0002.jpg"), filepath.Join(src, "IMG_0001.jpg")}
+	writeFiles(t, paths...)
+	day := time.Date(2023, 6, 10, 0, 0, 0, 0, time.UTC)
+	// The second frame has a date of its own, past midnight
+	exif := time.Date(2023, 6, 11, 0, 30, 0, 0, time.UTC)
+	files := func() []mediaFile {
+		return []mediaFile{
+			{path: paths[0], date: day, source: SourceSession},
+			{path: paths[1], date: exif, source: SourceExif},
+			{path: paths[2], date: exif, source: SourceExif},
+		}
+	}
+
+	opts := Options{Src: src, Dest: dest, FolderFormat: "2006/01/02", OnConflict: ConflictRename}
+	plan, err := buildPlan(files(), opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{
+		filepath.Join(dest, "2023", "06", "10", "frame_0001.jpg"),
+		filepath.Join(dest, "2023", "06", "11", "frame_0002.jpg"),
+		filepath.Join(dest, "2023", "06", "11", "IMG_0001.jpg"),
+	})
+
+	// Once a frame is dated by its session, every frame of it is
+	opts.SessionPattern = regexp.MustCompile(`(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2})_timelapse`)
+	plan, err = buildPlan(files(), opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{
+		filepath.Join(dest, "2023", "06", "10", "frame_0001.jpg"),
+		filepath.Join(dest, "2023", "06", "10", "frame_0002.jpg"),
+		filepath.Join(dest, "2023", "06", "11", "IMG_0001.jpg"),
+	})
+	if plan[1].Source != SourceSession || plan[2].Source != SourceExif {
+		t.Errorf("sources = %q, %q, want %q, %q", plan[1].Source, plan[2].Source, SourceSession, SourceExif)
+	}
+}
+
+func TestSessionDate(t *testing.T) {
+	pattern := regexp.MustCompile(`(?P<year>\d{4})-(?P<month>\d{2})-(?P<day>\d{2})_`)
+	if date, ok := sessionDate(filepath.Join("src", "2023-06-10_Sunset", "frame_0001.jpg"), pattern, nil); !ok || !date.Equal(time.Date(2023, 6, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("sessionDate() = %v, %v, want 2023-06-10", date, ok)
+	}
+	// Only the folder holding the file counts
+	if date, ok := sessionDate(filepath.Join("src", "2023-06-10_Sunset", "raw", "frame_0001.jpg"), pattern, nil); ok {
+		t.Errorf("sessionDate() of a nested file = %v, want none", date)
+	}
+}
@@ -0,0 +1,643 @@
+// Package sorter organizes photos and videos into date based folders, using
+// the date found in their EXIF data or, failing that, in their file name.
+package sorter
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// Options holds the settings for sorting a source directory.
+type Options struct {
+	Src           string
+	Dest          string
+	Copy          bool
+	FolderFormat  string
+	NameFormat    string
+	MonthFormat   string
+	FlatMonth     int
+	UpdateExif    bool
+	OnConflict    string
+	NoClobber     bool
+	MinYear       int
+	QuarantineDir string
+	Tags          DateTags
+	Log           bool
+
+	// AppendOriginalName appends the original base name to the names
+	// generated from NameFormat, as in 20230501_120000_beach.jpg.
+	AppendOriginalName bool
+
+	// KeywordFallback is what the {keyword} token expands to for files
+	// without keywords.
+	KeywordFallback string
+
+	// FixExtension replaces the extension of files whose content exiftool
+	// detects as another type, as for a PNG named .jpg.
+	FixExtension bool
+
+	// IphoneEdits, when set, pairs iPhone IMG_E edited copies with their
+	// originals and handles them according to one of the Edits policies.
+	IphoneEdits string
+
+	// ExtensionFolders routes files into subfolders of their date folder by
+	// lowercase extension without the dot, as in {"cr2": "RAW", "jpg":
+	// "JPG"}. RAW files and their companions are then dated alike, so pairs
+	// stay under the same date.
+	ExtensionFolders map[string]string
+
+	// AutoDateTags reads dates from the first of AutoDateTags present in a
+	// file, whatever its kind, instead of from Tags.
+	AutoDateTags bool
+
+	// FilenamePatterns are tried in order when reading a date from a file
+	// name.
+	FilenamePatterns []FilenamePattern
+
+	// PathPattern, when set, reads dates from the directory path of files
+	// relative to Src before EXIF data and file names. See pathDate.
+	PathPattern *regexp.Regexp
+
+	// SessionPattern, when set, dates the files without a date of their own
+	// from the name of their folder, and then every file of that folder by
+	// the same date, for timelapse and burst sessions. See sessionDate.
+	SessionPattern *regexp.Regexp
+
+	// ParentDateFallback dates files with neither an EXIF nor a file name
+	// date by the name of the directory holding them, as read with
+	// FilenamePatterns, before any other fallback.
+	ParentDateFallback bool
+
+	// DateSidecars are the extensions, without a dot, of the sidecars read
+	// for the date of videos without one. See DefaultDateSidecars.
+	DateSidecars []string
+
+	// ScreenshotPattern matches the names of screenshots, which the
+	// screenshot folder rule predicate tests for. See isScreenshot.
+	ScreenshotPattern *regexp.Regexp
+
+	// DatePrefer picks the date to use when EXIF data and the file name both
+	// yield one, and a warning is logged when they differ by more than
+	// DateDisagreement.
+	DatePrefer       string
+	DateDisagreement time.Duration
+
+	// ImageDatePrefer and VideoDatePrefer, when set, replace DatePrefer for
+	// photos and videos respectively.
+	ImageDatePrefer string
+	VideoDatePrefer string
+
+	// GPSDisagreement, when positive, warns about files whose EXIF date and
+	// GPS time differ by more than it, and PreferGPSTime sorts them by the
+	// GPS time instead, which corrects a camera clock set wrong.
+	GPSDisagreement time.Duration
+	PreferGPSTime   bool
+
+	// DisplayZone, when set, is the time zone every date is converted to
+	// before sorting. Dates without an offset are taken as local to it.
+	DisplayZone *time.Location
+
+	// DateGranularity, when GranularityDay, truncates every date to the
+	// midnight of its day, in DisplayZone when set, once extracted. Names
+	// and sequence numbers then no longer tell files of a day apart by time.
+	DateGranularity string
+
+	// SerialNames maps camera serial numbers to the friendly names the
+	// {serial} token expands to. Unmapped serials are used as is.
+	SerialNames map[string]string
+
+	// MtimeFallback dates photos and videos without an EXIF or file name
+	// date by their modification time, instead of failing them.
+	MtimeFallback bool
+
+	// IncludeNonMedia sorts files that are not photos or videos by their
+	// modification time instead of ignoring them.
+	IncludeNonMedia bool
+
+	// IncludeDocuments sorts PDF documents by their metadata dates, which
+	// are otherwise ignored as files that are not photos or videos.
+	IncludeDocuments bool
+
+	// IncludeHidden processes dotfiles, hidden directories and known junk
+	// files such as Thumbs.db, which are skipped by default.
+	IncludeHidden bool
+
+	// MinSize and MaxSize, when positive, skip files smaller or larger than
+	// them in bytes, before any date is extracted.
+	MinSize int64
+	MaxSize int64
+
+	// Limit, when positive, stops the walk after that many files to sort
+	// were found, leaving the rest of the source for another run.
+	Limit int
+
+	// MinWidth and MinHeight, when positive, skip images smaller than them
+	// in either orientation, once their metadata is read. Images of unknown
+	// dimensions are kept unless SkipUnknownResolution is set.
+	MinWidth              int
+	MinHeight             int
+	SkipUnknownResolution bool
+
+	// TranscodeHEIC copies HEIC images as JPEGs, with their tags, using an
+	// installed converter, and KeepHEIC copies the original next to them.
+	// Moved files are never transcoded.
+	TranscodeHEIC bool
+	KeepHEIC      bool
+
+	// TrashDir, when set, receives the files that would otherwise be
+	// overwritten, instead of them being lost.
+	TrashDir string
+
+	// FolderMetadata, when set, writes a metadata file into every folder the
+	// run sorted files into.
+	FolderMetadata FolderMetadata
+
+	// DedupeDB, when set, skips files whose content is already in the
+	// library and records the files placed by the run.
+	DedupeDB *DedupeDB
+
+	// UpdateOnly replaces a file already at the destination when the source
+	// was modified after it, and skips the source otherwise, instead of
+	// resolving the conflict by OnConflict.
+	UpdateOnly bool
+
+	// MergeExisting skips files whose content is already in their
+	// destination folder under another name, as in folders sorted by hand,
+	// on top of the checks of files with the same name.
+	MergeExisting bool
+
+	// ConflictSuffix is the format of the number appended to renamed files
+	// before their extension, DefaultConflictSuffix when empty.
+	ConflictSuffix string
+
+	// OrderedLog holds back the log lines of files sorted by CopyWorkers in
+	// parallel, so they are written in plan order as a sequential run would.
+	// They are written to LogOutput, which should be where the default
+	// logger writes, or to stderr when unset.
+	OrderedLog bool
+	LogOutput  io.Writer
+
+	// KeepTags, when set, are the only tags written back from the original
+	// when EXIF data is rewritten, along with the dates. Every tag read from
+	// the original is written back otherwise, or when KeepAllTags is set.
+	KeepTags    []string
+	KeepAllTags bool
+
+	// ExifBackups keeps the file_original backup exiftool makes of a file
+	// before rewriting it, which is otherwise overwritten in place. When
+	// ExifBackupDir is set, backups are moved there, at the path of their
+	// file below Dest. Rewrites only ever apply to the destination, so
+	// sources are never modified, whether copied or moved.
+	ExifBackups   bool
+	ExifBackupDir string
+
+	// SetMtime sets the access and modification times of sorted files to
+	// the date they were sorted by, once placed and rewritten. Quarantined
+	// files keep theirs.
+	SetMtime bool
+
+	// Provenance writes a sidecar next to every sorted file recording its
+	// original path and when the run started. See ProvenanceExt.
+	Provenance bool
+
+	// FolderRules pick the folder template of the files matching their
+	// predicate, in order, over FolderFormat and MonthFormat.
+	FolderRules []FolderRule
+
+	// Volumes, when set, are destination roots files are spread across in
+	// place of Dest, filling each until it would have less than MinFree
+	// bytes free before spilling to the next. Files keep the same path below
+	// whichever root they land in.
+	Volumes []string
+	MinFree int64
+
+	// MinFreeSpace, when set, stops the run before a copy or move would
+	// leave less than this many bytes free on the destination filesystem.
+	MinFreeSpace int64
+
+	// Storage is where files are sorted into, the local filesystem when
+	// unset. Dest is then the root within the storage.
+	Storage Storage
+
+	// Checkpoint, when set, skips the files of the source directories an
+	// earlier run completed and records those completed by this run.
+	Checkpoint *Checkpoint
+
+	// ContactSheets writes an HTML page listing the photos and videos of
+	// every top level folder of Dest the run sorted files into.
+	ContactSheets bool
+
+	// AllowChanged executes plan entries whose source changed since planning,
+	// with a warning, instead of refusing them.
+	AllowChanged bool
+
+	// CopyBuffer is the size in bytes of the buffer files are copied through,
+	// DefaultCopyBuffer when zero.
+	CopyBuffer int
+
+	// Resumable copies files through a .part file kept on failure, from which
+	// a later run resumes the copy.
+	Resumable bool
+
+	// ExiftoolPath, when set, is the exiftool binary to run instead of the
+	// one found on the PATH.
+	ExiftoolPath string
+
+	// MinExiftoolVersion, when set, is the oldest exiftool version accepted,
+	// such as 12.40, so that runs on different machines read tags alike.
+	MinExiftoolVersion string
+
+	// Charsets are passed to exiftool as -charset options, such as
+	// exif=cp1252 or filename=utf8, to decode legacy encoded metadata.
+	Charsets []string
+
+	// ExiftoolBuffer, when positive, is the largest output in bytes exiftool
+	// may report for a single file, for files with huge metadata such as
+	// embedded previews.
+	ExiftoolBuffer int
+
+	// ExtractWorkers is the number of exiftool processes extracting dates in
+	// parallel, and CopyWorkers the number of files copied or moved at once.
+	ExtractWorkers int
+	CopyWorkers    int
+
+	// OnFile, when set, is called with every file before it is acted upon and
+	// decides what finally happens to it.
+	OnFile func(FileContext) Decision
+
+	// Interrupt, when closed, stops the run: the walk ends, no further file
+	// is handed out, and the files being copied or moved are finished before
+	// Execute saves the checkpoint and returns ErrInterrupted.
+	Interrupt <-chan struct{}
+}
+
+// FileContext describes a file about to be sorted.
+type FileContext struct {
+	Path   string
+	Date   time.Time
+	Fields map[string]interface{}
+	Dest   string
+	Action string
+}
+
+// Decision is what an OnFile hook wants done with a file. An empty Dest or
+// Action keeps the proposed one, and Abort stops the whole run. Reason tells
+// why a file the hook skips is skipped.
+type Decision struct {
+	Dest   string
+	Action string
+	Reason string
+	Abort  bool
+}
+
+// ErrAborted is returned when an OnFile hook aborts the run.
+var ErrAborted = errors.New("sort aborted")
+
+// Validate checks the options for values the sorter cannot act upon.
+func (opts Options) Validate() error {
+	switch opts.OnConflict {
+	case ConflictRename, ConflictSkip, ConflictOverwrite:
+	default:
+		return errors.Errorf("unknown conflict policy %q", opts.OnConflict)
+	}
+	switch opts.DatePrefer {
+	case PreferExif, PreferFilename, PreferOldest:
+	default:
+		return errors.Errorf("unknown date preference %q", opts.DatePrefer)
+	}
+	for _, prefer := range []string{opts.ImageDatePrefer, opts.VideoDatePrefer} {
+		switch prefer {
+		case "", PreferExif, PreferFilename, PreferOldest:
+		default:
+			return errors.Errorf("unknown date preference %q", prefer)
+		}
+	}
+	if opts.FolderFormat != "" {
+		if err := ValidateFolderTemplate(opts.FolderFormat); err != nil {
+			return err
+		}
+	}
+	for _, tmpl := range []string{opts.MonthFormat, opts.NameFormat} {
+		if err := ValidateTemplate(tmpl); err != nil {
+			return err
+		}
+	}
+	if err := validateConflictSuffix(opts.conflictSuffix()); err != nil {
+		return err
+	}
+	if err := validateEdits(opts.IphoneEdits); err != nil {
+		return err
+	}
+	if err := opts.validateRemote(); err != nil {
+		return err
+	}
+	if opts.ExiftoolPath != "" {
+		if err := checkExecutable(opts.ExiftoolPath); err != nil {
+			return err
+		}
+	}
+	if opts.MinExiftoolVersion != "" {
+		if err := checkExiftoolVersion(opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sort plans and executes the sort of the source directory. Files are dated
+// as by ExtractDate and placed as by ResolveDestination, along with the
+// numbering and conflict handling that need the whole run.
+func Sort(opts Options, stats *Stats) error {
+	plan, err := Plan(opts, stats)
+	if err != nil {
+		return err
+	}
+	return Execute(plan, opts, stats)
+}
+
+// Plan walks the source directory and resolves what to do with each file,
+// without touching the filesystem.
+func Plan(opts Options, stats *Stats) ([]PlanEntry, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	files := collectFiles(opts, stats)
+	if opts.interrupted() {
+		return nil, ErrInterrupted
+	}
+
+	// Retry the directories of files that failed for another reason than
+	// lacking a date, which a retry would not fix
+	for _, file := range files {
+		if file.err != nil && !errors.Is(file.err, ErrNoDate) {
+			opts.failCheckpoint(file.path)
+		}
+	}
+	return buildPlan(filterResolution(datedFiles(files, stats), opts, stats), opts, stats)
+}
+
+// collectFiles walks the source directory and extracts the date of each
+// supported file. Dates are extracted by a pool of workers, each with its own
+// exiftool process, and files are returned in the order they were walked,
+// including those that could not be dated.
+func collectFiles(opts Options, stats *Stats) []mediaFile {
+	found := make(chan walkedFile)
+	results := make(chan walkedFile)
+
+	workers := opts.ExtractWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			extractWorker(found, results, opts, stats)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(found)
+		index := 0
+		filepath.Walk(opts.Src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Error("Error while accessing file", "src", path, "err", err)
+				return nil
+			}
+
+			// Skip hidden and junk files, and everything in hidden directories
+			if !opts.IncludeHidden && path != opts.Src && isHidden(info.Name()) {
+				log.Debug("Skipping hidden file", "src", path)
+				stats.AddSkip(path, ReasonHidden)
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			// Skip files an interrupted run already took care of
+			if opts.Checkpoint != nil && opts.Checkpoint.completed(path) {
+				stats.AddSkip(path, ReasonCheckpoint)
+				return nil
+			}
+
+			// Only process photos and videos, unless other files are sorted by
+			// their modification time
+			kind := opts.mediaKind(path)
+			isMedia := kind != ""
+			if !isMedia && !opts.IncludeNonMedia {
+				stats.AddSkip(path, ReasonExtension)
+				return nil
+			}
+
+			// Skip files outside of the configured size range
+			if opts.MinSize > 0 && info.Size() < opts.MinSize || opts.MaxSize > 0 && info.Size() > opts.MaxSize {
+				log.Debug("Skipping file by size", "src", path, "size", info.Size())
+				stats.inc(&stats.SkippedSize)
+				stats.AddSkip(path, ReasonSize)
+				return nil
+			}
+
+			// Leave the rest of the source once interrupted
+			if opts.interrupted() {
+				return errInterrupted
+			}
+
+			// Leave the rest of the source once enough files were found
+			if opts.Limit > 0 && index >= opts.Limit {
+				stats.limit()
+				opts.stopCheckpoint(path)
+				return errLimit
+			}
+
+			found <- walkedFile{index: index, info: info, isMedia: isMedia, mediaFile: mediaFile{path: path, srcFolder: sourceFolder(opts.Src, path), size: info.Size(), modTime: info.ModTime()}}
+			index++
+			return nil
+		})
+	}()
+
+	var walked []walkedFile
+	for file := range results {
+		walked = append(walked, file)
+	}
+	sort.Slice(walked, func(i, j int) bool { return walked[i].index < walked[j].index })
+
+	files := make([]mediaFile, len(walked))
+	for i, file := range walked {
+		files[i] = file.mediaFile
+	}
+	return files
+}
+
+// datedFiles returns the files that could be dated, logging the others.
+func datedFiles(files []mediaFile, stats *Stats) []mediaFile {
+	var dated []mediaFile
+	for _, file := range files {
+		if file.err != nil {
+			log.Error("Error while extracting date", "src", file.path, "err", file.err)
+			stats.inc(&stats.Failed)
+			if errors.Is(file.err, ErrNoDate) {
+				stats.addUndated(file.path)
+				stats.AddSkip(file.path, ReasonNoDate)
+			}
+			continue
+		}
+		dated = append(dated, file)
+	}
+	return dated
+}
+
+// walkedFile is a file found while walking the source directory.
+type walkedFile struct {
+	mediaFile
+	index   int
+	info    os.FileInfo
+	isMedia bool
+}
+
+// extractWorker dates the files it receives using its own exiftool process,
+// and sends them on along with any error dating them.
+func extractWorker(found <-chan walkedFile, results chan<- walkedFile, opts Options, stats *Stats) {
+	et, etErr := newExiftool(opts)
+	if etErr != nil {
+		etErr = errors.Errorf("Error when intializing: %v", etErr)
+	} else {
+		defer et.Close()
+	}
+
+	for file := range found {
+		if !file.isMedia {
+			file.date, file.source = file.info.ModTime(), SourceMtime
+			if opts.DisplayZone != nil {
+				file.date = file.date.In(opts.DisplayZone)
+			}
+			file.date = opts.truncateDate(file.date)
+			results <- file
+			continue
+		}
+		if etErr != nil {
+			file.err = etErr
+			results <- file
+			continue
+		}
+
+		// Extract date from EXIF data or filename
+		extractStart := time.Now()
+		file.err = extractDate(et, &file.mediaFile, opts)
+		file.date = opts.truncateDate(file.date)
+		stats.timeExtract(extractStart)
+		results <- file
+	}
+}
+
+// errLimit stops the walk once Options.Limit files were found.
+var errLimit = errors.New("file limit reached")
+
+// errInterrupted stops the walk once Options.Interrupt is closed.
+var errInterrupted = errors.New("walk interrupted")
+
+// interrupted reports whether Options.Interrupt was closed.
+func (opts Options) interrupted() bool {
+	select {
+	case <-opts.Interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// junkNames are system files that are never worth sorting.
+var junkNames = map[string]bool{
+	"thumbs.db":   true,
+	"ehthumbs.db": true,
+	"desktop.ini": true,
+	"icon\r":      true,
+}
+
+// isHidden reports whether a file is a dotfile, such as .DS_Store or an
+// AppleDouble ._IMG_0001.jpg resource fork, or a known junk file.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") || junkNames[strings.ToLower(name)]
+}
+
+// mediaFile is a source file along with the date it will be sorted by.
+type mediaFile struct {
+	path      string
+	srcFolder string
+	date      time.Time
+	source    DateSource
+	fields    map[string]interface{}
+	size      int64
+	modTime   time.Time
+	exifDate  time.Time
+	nameDate  time.Time
+	err       error
+
+	// screenshot is set for images detected as screenshots
+	screenshot bool
+}
+
+// DateSource tells where the date a file is sorted by came from.
+type DateSource string
+
+// Sources a file's date can come from.
+const (
+	SourceExif     DateSource = "exif"
+	SourceFilename DateSource = "filename"
+	SourceMtime    DateSource = "mtime"
+	SourcePath     DateSource = "path"
+	SourceGPS      DateSource = "gps"
+	SourceSession  DateSource = "session"
+)
+
+// DateSources lists every source a file's date can come from.
+var DateSources = []DateSource{SourceExif, SourceFilename, SourceMtime, SourcePath, SourceGPS, SourceSession}
+
+// isPlausibleDate reports whether date lies between the start of minYear
+// and now, outside of which a camera clock was most likely wrong.
+func isPlausibleDate(date time.Time, minYear int) bool {
+	return date.Year() >= minYear && !date.After(time.Now())
+}
+
+// GranularityDay is the Options.DateGranularity truncating dates to their day.
+const GranularityDay = "day"
+
+// truncateDate truncates date to the granularity of the options.
+func (opts Options) truncateDate(date time.Time) time.Time {
+	if opts.DateGranularity != GranularityDay || date.IsZero() {
+		return date
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+}
+
+// dayKey returns the bucket key of the day a date falls on.
+func dayKey(date time.Time) string {
+	return date.Format("2006-01-02")
+}
+
+// countByDay returns the number of files falling on each day.
+func countByDay(files []mediaFile) map[string]int {
+	counts := make(map[string]int)
+	for _, file := range files {
+		counts[dayKey(file.date)]++
+	}
+	return counts
+}
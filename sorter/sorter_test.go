@@ -0,0 +1,208 @@
+package sorter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectFilesNonMedia(t *testing.T) {
+	src := t.TempDir()
+	memo := filepath.Join(src, "memos", "memo.m4a")
+	writeFiles(t, memo)
+	modTime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local)
+	if err := os.Chtimes(memo, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only opted in are other files sorted by their modification time
+	stats := NewStats()
+	stats.ExplainSkips = true
+	if files := collectFiles(Options{Src: src}, stats); len(files) != 0 {
+		t.Errorf("collectFiles() found %v without -include-nonmedia", files)
+	}
+	want := []SkippedFile{{Path: memo, Reason: ReasonExtension}}
+	if got := stats.Skips(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Skips() = %v, want %v", got, want)
+	}
+
+	files := collectFiles(Options{Src: src, IncludeNonMedia: true}, NewStats())
+	if len(files) != 1 {
+		t.Fatalf("collectFiles() found %d files, want 1", len(files))
+	}
+	file := files[0]
+	if file.err != nil || file.path != memo || file.source != SourceMtime || !file.date.Equal(modTime) {
+		t.Errorf("collectFiles() found %s dated %v from %s (err %v), want %s dated %v from %s", file.path, file.date, file.source, file.err, memo, modTime, SourceMtime)
+	}
+}
+
+func TestIsHidden(t *testing.T) {
+	tests := map[string]bool{
+		".DS_Store":      true,
+		"._IMG_0001.jpg": true,
+		"Thumbs.db":      true,
+		"desktop.ini":    true,
+		"IMG_0001.jpg":   false,
+		"thumbs.jpg":     false,
+	}
+	for name, want := range tests {
+		if got := isHidden(name); got != want {
+			t.Errorf("isHidden(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCollectFilesHidden(t *testing.T) {
+	src := t.TempDir()
+	visible := filepath.Join(src, "a", "notes.txt")
+	fork := filepath.Join(src, "a", "._IMG_0001.jpg")
+	thumbs := filepath.Join(src, "a", "Thumbs.db")
+	inHidden := filepath.Join(src, ".trash", "notes.txt")
+	writeFiles(t, visible, fork, thumbs, inHidden)
+
+	paths := func(files []mediaFile) []string {
+		var paths []string
+		for _, file := range files {
+			paths = append(paths, file.path)
+		}
+		return paths
+	}
+	stats := NewStats()
+	stats.ExplainSkips = true
+	files := collectFiles(Options{Src: src, IncludeNonMedia: true}, stats)
+	if got, want := paths(files), []string{visible}; !reflect.DeepEqual(got, want) {
+		t.Errorf("collectFiles() found %v, want %v", got, want)
+	}
+	// A hidden directory is skipped as a whole
+	wantSkips := []SkippedFile{{Path: filepath.Dir(inHidden), Reason: ReasonHidden}, {Path: fork, Reason: ReasonHidden}, {Path: thumbs, Reason: ReasonHidden}}
+	if got := stats.Skips(); !reflect.DeepEqual(got, wantSkips) {
+		t.Errorf("Skips() = %v, want %v", got, wantSkips)
+	}
+
+	files = collectFiles(Options{Src: src, IncludeNonMedia: true, IncludeHidden: true}, NewStats())
+	if got, want := paths(files), []string{inHidden, fork, thumbs, visible}; !reflect.DeepEqual(got, want) {
+		t.Errorf("collectFiles() with hidden files found %v, want %v", got, want)
+	}
+}
+
+func TestCollectFilesWorkers(t *testing.T) {
+	src := t.TempDir()
+	var want []string
+	for i := 0; i < 50; i++ {
+		path := filepath.Join(src, fmt.Sprintf("notes%02d.txt", i))
+		writeFiles(t, path)
+		want = append(want, path)
+	}
+
+	// Files come back in walk order however the extraction workers finish
+	files := collectFiles(Options{Src: src, IncludeNonMedia: true, ExtractWorkers: 4}, NewStats())
+	var got []string
+	for _, file := range files {
+		got = append(got, file.path)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectFiles() found %v, want %v", got, want)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	src := t.TempDir()
+	notes := filepath.Join(src, "notes.txt")
+	writeFiles(t, notes)
+	opts := Options{Src: src, IncludeNonMedia: true, OnConflict: ConflictRename, DatePrefer: PreferExif}
+	results, err := Probe(opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Path != notes || results[0].Source != SourceMtime || results[0].Date.IsZero() {
+		t.Errorf("Probe() = %+v, want %s dated by its modification time", results, notes)
+	}
+
+	opts.DatePrefer = "newest"
+	if _, err := Probe(opts, NewStats()); err == nil {
+		t.Error("Probe() with invalid options succeeded")
+	}
+}
+
+func TestCollectFilesSize(t *testing.T) {
+	src := t.TempDir()
+	small := filepath.Join(src, "small.txt")
+	medium := filepath.Join(src, "medium.txt")
+	large := filepath.Join(src, "large.txt")
+	writeFile(t, small, "1")
+	writeFile(t, medium, "12345")
+	writeFile(t, large, "1234567890")
+
+	stats := NewStats()
+	stats.ExplainSkips = true
+	files := collectFiles(Options{Src: src, IncludeNonMedia: true, MinSize: 2, MaxSize: 9}, stats)
+	if len(files) != 1 || files[0].path != medium {
+		t.Errorf("collectFiles() found %v, want only %s", files, medium)
+	}
+	if stats.SkippedSize != 2 {
+		t.Errorf("skipped %d files by size, want 2", stats.SkippedSize)
+	}
+	want := []SkippedFile{{Path: large, Reason: ReasonSize}, {Path: small, Reason: ReasonSize}}
+	if got := stats.Skips(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Skips() = %v, want %v", got, want)
+	}
+}
+
+func TestPlanCheck(t *testing.T) {
+	useLog(t)
+	src := t.TempDir()
+	notes := filepath.Join(src, "notes.txt")
+	photo := filepath.Join(src, "IMG_0001.jpg")
+	writeFiles(t, notes, photo)
+
+	// Checking plans without a destination and counts the files that could
+	// not be dated, here for want of an exiftool to read them
+	opts := Options{Src: src, IncludeNonMedia: true, OnConflict: ConflictRename, DatePrefer: PreferExif, FolderFormat: "2006/01"}
+	t.Setenv("PATH", t.TempDir())
+	stats := NewStats()
+	plan, err := Plan(opts, stats)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan) != 1 || plan[0].Src != notes {
+		t.Errorf("planned %v, want only %s", plan, notes)
+	}
+	if stats.Failed != 1 {
+		t.Errorf("failed %d files, want 1", stats.Failed)
+	}
+	if !fileExists(notes) || !fileExists(photo) {
+		t.Error("checking moved files")
+	}
+}
+
+func TestTruncateDate(t *testing.T) {
+	zone := time.FixedZone("", 2*60*60)
+	date := time.Date(2023, 5, 1, 23, 30, 0, 0, zone)
+	day := Options{DateGranularity: GranularityDay}
+	if got := (Options{}).truncateDate(date); !got.Equal(date) {
+		t.Errorf("truncateDate() = %v without a granularity, want %v", got, date)
+	}
+	// The day is the one of the date's own zone, not UTC's
+	if got, want := day.truncateDate(date), time.Date(2023, 5, 1, 0, 0, 0, 0, zone); !got.Equal(want) || got.Location() != zone {
+		t.Errorf("truncateDate() = %v, want %v", got, want)
+	}
+	if got := day.truncateDate(time.Time{}); !got.IsZero() {
+		t.Errorf("truncateDate() = %v, want the zero date", got)
+	}
+
+	// Files dated by their modification time are truncated too
+	src := t.TempDir()
+	memo := filepath.Join(src, "memo.m4a")
+	writeFiles(t, memo)
+	modTime := time.Date(2023, 5, 1, 12, 34, 56, 0, time.Local)
+	if err := os.Chtimes(memo, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	files := collectFiles(Options{Src: src, IncludeNonMedia: true, DateGranularity: GranularityDay}, NewStats())
+	if want := time.Date(2023, 5, 1, 0, 0, 0, 0, time.Local); len(files) != 1 || !files[0].date.Equal(want) {
+		t.Errorf("collectFiles() found %v, want %s dated %v", files, memo, want)
+	}
+}
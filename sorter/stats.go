@@ -0,0 +1,242 @@
+package sorter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Stats accumulates timings and per-file outcomes across a run.
+type Stats struct {
+	mu        sync.Mutex
+	start     time.Time
+	extract   time.Duration
+	io        time.Duration
+	files     int
+	extracted int
+	undated   []string
+	skips     []SkippedFile
+	reasons   map[string]int
+
+	Sorted            int
+	Skipped           int
+	SkippedExists     int
+	InPlace           int
+	SkippedSize       int
+	SkippedResolution int
+	Duplicates        int
+	Quarantined       int
+	Failed            int
+
+	// ExplainSkips lists every skipped file along with the reason why, which
+	// Skips returns. Skips are always counted by reason.
+	ExplainSkips bool
+
+	// MtimeDated counts the sorted files that were dated by their
+	// modification time only.
+	MtimeDated int
+
+	// Limited is set when the walk stopped early at Options.Limit.
+	Limited bool
+
+	// SortedBytes is the size of the files sorted.
+	SortedBytes int64
+}
+
+// Summary is the outcome of a run, as Summarize logs it, for scripts.
+type Summary struct {
+	Sorted            int            `json:"sorted"`
+	Skipped           int            `json:"skipped"`
+	SkippedExists     int            `json:"skipped_exists"`
+	InPlace           int            `json:"in_place"`
+	SkippedSize       int            `json:"skipped_size"`
+	SkippedResolution int            `json:"skipped_resolution"`
+	Duplicates        int            `json:"duplicates"`
+	Quarantined       int            `json:"quarantined"`
+	Failed            int            `json:"failed"`
+	MtimeDated        int            `json:"mtime_dated"`
+	Bytes             int64          `json:"bytes"`
+	Duration          float64        `json:"duration_seconds"`
+	Limited           bool           `json:"limited,omitempty"`
+	Reasons           map[string]int `json:"skipped_by_reason,omitempty"`
+}
+
+// NewStats starts collecting the statistics of a run.
+func NewStats() *Stats {
+	return &Stats{start: time.Now()}
+}
+
+// timeExtract records the time spent extracting a date since start.
+func (s *Stats) timeExtract(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.extract += time.Since(start)
+	s.extracted++
+}
+
+// limit records that the walk stopped early at the file limit.
+func (s *Stats) limit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Limited = true
+}
+
+// timeIO records the time spent copying or moving a file since start.
+func (s *Stats) timeIO(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.io += time.Since(start)
+	s.files++
+}
+
+// addBytes records the size of a sorted file.
+func (s *Stats) addBytes(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SortedBytes += size
+}
+
+// Summary returns the outcome of the run so far.
+func (s *Stats) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := Summary{
+		Sorted:            s.Sorted,
+		Skipped:           s.Skipped,
+		SkippedExists:     s.SkippedExists,
+		InPlace:           s.InPlace,
+		SkippedSize:       s.SkippedSize,
+		SkippedResolution: s.SkippedResolution,
+		Duplicates:        s.Duplicates,
+		Quarantined:       s.Quarantined,
+		Failed:            s.Failed,
+		MtimeDated:        s.MtimeDated,
+		Bytes:             s.SortedBytes,
+		Duration:          time.Since(s.start).Seconds(),
+		Limited:           s.Limited,
+	}
+	if len(s.reasons) > 0 {
+		summary.Reasons = make(map[string]int, len(s.reasons))
+		for reason, n := range s.reasons {
+			summary.Reasons[reason] = n
+		}
+	}
+	return summary
+}
+
+// inc increments one of the outcome counters, from any goroutine.
+func (s *Stats) inc(counter *int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*counter++
+}
+
+// addUndated records a file for which no date could be found.
+func (s *Stats) addUndated(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.undated = append(s.undated, path)
+}
+
+// SkippedFile is a file that was skipped, and the reason why.
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+// AddSkip records a skipped file, counting it by reason and listing it when
+// explaining skips. Files skipped for no specific reason count as "skipped".
+// Execute records the files it skips, and callers only record those of plans
+// they do not execute.
+func (s *Stats) AddSkip(path, reason string) {
+	if reason == "" {
+		reason = "skipped"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reasons == nil {
+		s.reasons = make(map[string]int)
+	}
+	s.reasons[reason]++
+	if s.ExplainSkips {
+		s.skips = append(s.skips, SkippedFile{Path: path, Reason: reason})
+	}
+}
+
+// Skips returns the skipped files, sorted by reason and path, when
+// ExplainSkips is set.
+func (s *Stats) Skips() []SkippedFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	skips := append([]SkippedFile(nil), s.skips...)
+	sort.Slice(skips, func(i, j int) bool {
+		if skips[i].Reason != skips[j].Reason {
+			return skips[i].Reason < skips[j].Reason
+		}
+		return skips[i].Path < skips[j].Path
+	})
+	return skips
+}
+
+// Undated returns the files for which no date could be found, sorted.
+func (s *Stats) Undated() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	undated := append([]string(nil), s.undated...)
+	sort.Strings(undated)
+	return undated
+}
+
+// Report logs the aggregate timings of the run.
+func (s *Stats) Report() {
+	total := time.Since(s.start)
+	rate := 0.0
+	if total > 0 {
+		rate = float64(s.files) / total.Seconds()
+	}
+	var perExtract time.Duration
+	if s.extracted > 0 {
+		perExtract = s.extract / time.Duration(s.extracted)
+	}
+	log.Info("Run statistics",
+		"total", total.Round(time.Millisecond),
+		"extract", s.extract.Round(time.Millisecond),
+		"extract_per_file", perExtract.Round(time.Microsecond),
+		"io", s.io.Round(time.Millisecond),
+		"files", s.files,
+		"files_per_sec", rate)
+}
+
+// Summarize logs how many files ended up in each outcome, and how many files
+// were skipped for each reason.
+func (s *Stats) Summarize() {
+	log.Info("Summary",
+		"sorted", s.Sorted,
+		"skipped", s.Skipped,
+		"skipped_exists", s.SkippedExists,
+		"in_place", s.InPlace,
+		"skipped_size", s.SkippedSize,
+		"skipped_resolution", s.SkippedResolution,
+		"duplicates", s.Duplicates,
+		"quarantined", s.Quarantined,
+		"failed", s.Failed,
+		"mtime_dated", s.MtimeDated)
+	if s.Limited {
+		log.Info("Stopped early at the file limit")
+	}
+
+	if len(s.reasons) > 0 {
+		reasons := make([]string, 0, len(s.reasons))
+		for reason := range s.reasons {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		var keyvals []interface{}
+		for _, reason := range reasons {
+			keyvals = append(keyvals, reason, s.reasons[reason])
+		}
+		log.Info("Skipped by reason", keyvals...)
+	}
+}
@@ -0,0 +1,105 @@
+package sorter
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestStatsReport(t *testing.T) {
+	buf := useLog(t)
+	stats := NewStats()
+	stats.timeExtract(time.Now().Add(-4 * time.Millisecond))
+	stats.timeExtract(time.Now().Add(-2 * time.Millisecond))
+	stats.timeIO(time.Now().Add(-10 * time.Millisecond))
+	if stats.extracted != 2 || stats.extract < 6*time.Millisecond {
+		t.Errorf("extracted %d files in %v, want 2 in at least 6ms", stats.extracted, stats.extract)
+	}
+	if stats.files != 1 || stats.io < 10*time.Millisecond {
+		t.Errorf("sorted %d files in %v, want 1 in at least 10ms", stats.files, stats.io)
+	}
+
+	stats.Report()
+	var report map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("report %q is not a single JSON line: %v", buf, err)
+	}
+	if report["msg"] != "Run statistics" || report["files"] != 1.0 {
+		t.Errorf("report = %v, want the run statistics of 1 file", report)
+	}
+	for _, key := range []string{"total", "extract", "extract_per_file", "io", "files_per_sec"} {
+		if _, ok := report[key]; !ok {
+			t.Errorf("report lacks %s: %v", key, report)
+		}
+	}
+}
+
+func TestExecuteTimesIO(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	writeFiles(t, src)
+	plan := []PlanEntry{
+		{Src: src, Dest: filepath.Join(dir, "dest", "IMG_0001.jpg"), Action: ActionCopy},
+		{Src: filepath.Join(dir, "src", "IMG_0002.jpg"), Dest: filepath.Join(dir, "dest", "IMG_0002.jpg"), Action: ActionSkip},
+	}
+	stats := NewStats()
+	if err := Execute(plan, Options{}, stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.files != 1 {
+		t.Errorf("timed %d files, want the one copied", stats.files)
+	}
+}
+
+func TestStatsUndated(t *testing.T) {
+	useLog(t)
+	stats := NewStats()
+	files := []mediaFile{
+		{path: "src/b/IMG_0002.jpg", err: ErrNoDate},
+		{path: "src/IMG_0003.jpg", date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{path: "src/a/IMG_0001.jpg", err: errors.Wrap(ErrNoDate, "no tags")},
+		{path: "src/IMG_0004.jpg", err: errors.New("exiftool failed")},
+	}
+	if dated := datedFiles(files, stats); len(dated) != 1 || dated[0].path != "src/IMG_0003.jpg" {
+		t.Errorf("datedFiles() = %v, want only src/IMG_0003.jpg", dated)
+	}
+
+	// Only the files without a date are listed, in a stable order
+	want := []string{"src/a/IMG_0001.jpg", "src/b/IMG_0002.jpg"}
+	if got := stats.Undated(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Undated() = %v, want %v", got, want)
+	}
+}
+
+func TestStatsSkips(t *testing.T) {
+	for _, explain := range []bool{false, true} {
+		stats := NewStats()
+		stats.ExplainSkips = explain
+		stats.AddSkip("src/b.jpg", ReasonHidden)
+		stats.AddSkip("src/c.jpg", "")
+		stats.AddSkip("src/a.jpg", ReasonHidden)
+		stats.AddSkip("src/d.txt", ReasonExtension)
+
+		// Reasons are always counted, files only listed when explaining
+		wantReasons := map[string]int{ReasonHidden: 2, ReasonExtension: 1, "skipped": 1}
+		if got := stats.Summary().Reasons; !reflect.DeepEqual(got, wantReasons) {
+			t.Errorf("explain %v: reasons = %v, want %v", explain, got, wantReasons)
+		}
+		var want []SkippedFile
+		if explain {
+			want = []SkippedFile{
+				{Path: "src/d.txt", Reason: ReasonExtension},
+				{Path: "src/a.jpg", Reason: ReasonHidden},
+				{Path: "src/b.jpg", Reason: ReasonHidden},
+				{Path: "src/c.jpg", Reason: "skipped"},
+			}
+		}
+		if got := stats.Skips(); !reflect.DeepEqual(got, want) {
+			t.Errorf("explain %v: Skips() = %v, want %v", explain, got, want)
+		}
+	}
+}
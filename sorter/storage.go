@@ -0,0 +1,134 @@
+package sorter
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Storage is where files are sorted into. Paths are the destinations of plan
+// entries, below Options.Dest.
+type Storage interface {
+	// Exists reports whether a file exists at path.
+	Exists(path string) (bool, error)
+	// Same reports whether the file at path has the content of the local
+	// file src.
+	Same(src, path string) (bool, error)
+	// Put copies the local file src to path, through a buffer of up to
+	// bufSize bytes where the storage buffers copies.
+	Put(src, path string, bufSize int) error
+}
+
+// LocalStorage stores files on the local filesystem. It is the storage of
+// options without one.
+type LocalStorage struct{}
+
+// Exists implements Storage.
+func (LocalStorage) Exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, errors.WithStack(err)
+}
+
+// Same implements Storage.
+func (LocalStorage) Same(src, path string) (bool, error) {
+	return sameContent(osFS, src, path)
+}
+
+// Put implements Storage.
+func (LocalStorage) Put(src, path string, bufSize int) error {
+	return copyFile(osFS, src, path, bufSize)
+}
+
+// ParseDest returns the storage for a destination given as a URL, such as
+// s3://bucket/prefix, along with the root to sort into within it. Plain paths
+// return a nil storage, for the local filesystem, and the path itself.
+func ParseDest(dest string) (Storage, string, error) {
+	if !strings.Contains(dest, "://") {
+		return nil, dest, nil
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "invalid destination %q", dest)
+	}
+	root := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return nil, u.Path, nil
+	case "s3":
+		storage, err := NewS3Storage(u.Host)
+		if err != nil {
+			return nil, "", err
+		}
+		return storage, root, nil
+	}
+	return nil, "", errors.Errorf("unsupported destination scheme %q", u.Scheme)
+}
+
+// storage returns the storage files are sorted into.
+func (opts Options) storage() Storage {
+	if opts.Storage == nil {
+		return LocalStorage{}
+	}
+	return opts.Storage
+}
+
+// remote reports whether files are sorted into another storage than the
+// local filesystem.
+func (opts Options) remote() bool {
+	_, local := opts.storage().(LocalStorage)
+	return !local
+}
+
+// destExists reports whether a file exists at the destination path.
+func (opts Options) destExists(path string) bool {
+	exists, err := opts.storage().Exists(path)
+	return err == nil && exists
+}
+
+// putFile copies the source of an entry into store, and removes it once
+// stored when the entry moves it.
+func putFile(store Storage, entry PlanEntry, bufSize int) error {
+	if err := store.Put(entry.Src, entry.Dest, bufSize); err != nil {
+		return err
+	}
+	if entry.Action != ActionMove {
+		return nil
+	}
+	return errors.Wrapf(os.Remove(entry.Src), "removing source %q", entry.Src)
+}
+
+// validateRemote checks that no option needing a local destination is used
+// along with a remote storage.
+func (opts Options) validateRemote() error {
+	if !opts.remote() {
+		return nil
+	}
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"trash", opts.TrashDir != ""},
+		{"contact sheets", opts.ContactSheets},
+		{"folder metadata", opts.FolderMetadata != nil},
+		{"a dedupe database", opts.DedupeDB != nil},
+		{"resumable copies", opts.Resumable},
+		{"EXIF updates", opts.UpdateExif},
+		{"provenance sidecars", opts.Provenance},
+		{"update only", opts.UpdateOnly},
+		{"merging into existing folders", opts.MergeExisting},
+		{"several volumes", len(opts.Volumes) > 0},
+		{"a minimum free space", opts.MinFreeSpace > 0},
+		{"HEIC transcoding", opts.TranscodeHEIC},
+		{"setting modification times", opts.SetMtime},
+	} {
+		if option.set {
+			return errors.Errorf("%s cannot be used with a remote destination", option.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package sorter
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tokenRegex matches `{name}` tokens in a path template, optionally with a
+// numeric argument as in `{seq:4}`.
+var tokenRegex = regexp.MustCompile(`\{([a-z-]+)(?::(\d+))?\}`)
+
+// braceRegex matches anything written in braces, to catch misspelled tokens.
+var braceRegex = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ValidateTemplate checks that every braced name in tmpl is a supported
+// token, so that a mistyped token fails up front instead of creating folders
+// named after it. Templates without any token or date layout are valid, as
+// for a fixed folder such as Screenshots.
+func ValidateTemplate(tmpl string) error {
+	_, err := checkTokens(tmpl)
+	return err
+}
+
+// ValidateFolderTemplate checks tmpl as ValidateTemplate does, and that it
+// renders differently for different files, through its date layout or its
+// tokens. It is meant for the template every file is sorted by, which would
+// otherwise pile them all into the same folder.
+func ValidateFolderTemplate(tmpl string) error {
+	hasToken, err := checkTokens(tmpl)
+	if err != nil {
+		return err
+	}
+	layout := tokenRegex.ReplaceAllString(tmpl, "")
+	first := time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+	second := time.Date(2012, time.November, 13, 14, 15, 16, 0, time.UTC)
+	if !hasToken && first.Format(layout) == second.Format(layout) {
+		return errors.Errorf("template %q has no date layout or token, so every file would get the same path; layouts are written with the reference date, as in 2006/01/02, and tokens are %s", tmpl, supportedTokens())
+	}
+	return nil
+}
+
+// checkTokens returns an error for the first braced name in tmpl that is not
+// a supported token, and otherwise whether tmpl has any token.
+func checkTokens(tmpl string) (bool, error) {
+	hasToken := false
+	for _, braced := range braceRegex.FindAllString(tmpl, -1) {
+		match := tokenRegex.FindStringSubmatch(braced)
+		if match == nil || match[0] != braced || templateTokens[match[1]] == nil {
+			return false, errors.Errorf("unknown token %s in template %q, supported tokens are %s", braced, tmpl, supportedTokens())
+		}
+		hasToken = true
+	}
+	return hasToken, nil
+}
+
+// supportedTokens lists the supported template tokens.
+func supportedTokens() string {
+	names := make([]string, 0, len(templateTokens))
+	for name := range templateTokens {
+		names = append(names, "{"+name+"}")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// tokenContext carries the per-file values that template tokens expand from.
+type tokenContext struct {
+	date        time.Time
+	srcFolder   string
+	seq         int
+	index       int
+	serial      string
+	orientation string
+	keyword     string
+	country     string
+	model       string
+}
+
+// fileTokens returns the token values of a file, other than its sequence
+// numbers.
+func fileTokens(file mediaFile, opts Options) tokenContext {
+	return tokenContext{
+		date:        file.date,
+		srcFolder:   file.srcFolder,
+		serial:      cameraSerial(file.fields, opts.SerialNames),
+		orientation: orientation(file.fields),
+		keyword:     primaryKeyword(file.fields, opts.KeywordFallback),
+		country:     country(file.fields),
+		model:       cameraModel(file.fields),
+	}
+}
+
+// templateTokens maps each supported token name to the function expanding
+// it, given the token's argument or an empty string.
+var templateTokens = map[string]func(ctx tokenContext, arg string) string{
+	"srcfolder":   func(ctx tokenContext, _ string) string { return ctx.srcFolder },
+	"dayofyear":   func(ctx tokenContext, _ string) string { return fmt.Sprintf("%03d", ctx.date.YearDay()) },
+	"epoch":       func(ctx tokenContext, _ string) string { return strconv.FormatInt(ctx.date.Unix(), 10) },
+	"seq":         func(ctx tokenContext, arg string) string { return zeroPad(ctx.seq, arg, 3) },
+	"decade":      func(ctx tokenContext, _ string) string { return fmt.Sprintf("%ds", ctx.date.Year()/10*10) },
+	"period":      func(ctx tokenContext, arg string) string { return period(ctx.date.Year(), arg) },
+	"index":       func(ctx tokenContext, arg string) string { return zeroPad(ctx.index, arg, 6) },
+	"serial":      func(ctx tokenContext, _ string) string { return ctx.serial },
+	"orientation": func(ctx tokenContext, _ string) string { return ctx.orientation },
+	"keyword":     func(ctx tokenContext, _ string) string { return ctx.keyword },
+	"country":     func(ctx tokenContext, _ string) string { return ctx.country },
+	"model":       func(ctx tokenContext, _ string) string { return ctx.model },
+	"model-slug":  func(ctx tokenContext, _ string) string { return slugify(ctx.model) },
+	// make-model-slug is the same as model-slug, which includes the make
+	"make-model-slug": func(ctx tokenContext, _ string) string { return slugify(ctx.model) },
+}
+
+// period returns the span of years, as in 1985-1989, that year falls into
+// when years are bucketed by the number of years in arg, or by 10 when arg
+// is empty.
+func period(year int, arg string) string {
+	span, err := strconv.Atoi(arg)
+	if err != nil || span < 1 {
+		span = 10
+	}
+	start := year - (year%span+span)%span
+	return fmt.Sprintf("%d-%d", start, start+span-1)
+}
+
+// zeroPad formats n padded with zeros to the width given in arg, or to
+// width when arg is empty.
+func zeroPad(n int, arg string, width int) string {
+	if w, err := strconv.Atoi(arg); err == nil {
+		width = w
+	}
+	return fmt.Sprintf("%0*d", width, n)
+}
+
+// formatPath renders a path template for a file. Text outside of `{name}`
+// tokens is treated as a Go time layout and formatted with the file's date.
+func formatPath(tmpl string, ctx tokenContext) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range tokenRegex.FindAllStringSubmatchIndex(tmpl, -1) {
+		sb.WriteString(ctx.date.Format(tmpl[last:loc[0]]))
+		name, arg := tmpl[loc[2]:loc[3]], ""
+		if loc[4] >= 0 {
+			arg = tmpl[loc[4]:loc[5]]
+		}
+		if expand, ok := templateTokens[name]; ok {
+			sb.WriteString(expand(ctx, arg))
+		} else {
+			sb.WriteString(tmpl[loc[0]:loc[1]])
+		}
+		last = loc[1]
+	}
+	sb.WriteString(ctx.date.Format(tmpl[last:]))
+	return filepath.Clean(sb.String())
+}
+
+// sourceFolder returns the directory a file was found in, relative to the
+// source root. Files directly in the source root have an empty folder.
+func sourceFolder(srcRoot, path string) string {
+	rel, err := filepath.Rel(srcRoot, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return ""
+	}
+	return rel
+}
+
+// asciiFolds maps the accented letters of Latin alphabets to the ASCII
+// letters they fold to in slugs.
+var asciiFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i",
+	'î': "i", 'ï': "i", 'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o",
+	'õ': "o", 'ö': "o", 'ø': "o", 'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'ý': "y", 'ÿ': "y", 'þ': "th", 'ß': "ss", 'œ': "oe", 'ł': "l", 'š': "s",
+	'ž': "z", 'č': "c", 'ř': "r", 'ě': "e", 'ő': "o", 'ű': "u",
+}
+
+// slugify turns value into a lowercase, ASCII, hyphen separated slug, as in
+// canon-eos-5d-mark-iv. Letters without an ASCII folding are dropped.
+func slugify(value string) string {
+	var sb strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(value) {
+		folded := ""
+		switch {
+		case 'a' <= r && r <= 'z', '0' <= r && r <= '9':
+			folded = string(r)
+		case asciiFolds[r] != "":
+			folded = asciiFolds[r]
+		default:
+			hyphen = sb.Len() > 0
+			continue
+		}
+		if hyphen {
+			sb.WriteByte('-')
+			hyphen = false
+		}
+		sb.WriteString(folded)
+	}
+	return sb.String()
+}
+
+// sanitizeName replaces the characters of a file name that are unsafe on
+// common filesystems with underscores.
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.Trim(name, " .")
+}
@@ -0,0 +1,359 @@
+package sorter
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		err  string
+	}{
+		{"", ""},
+		{"2006/01/02", ""},
+		{"{model}/2006", ""},
+		{"{seq:4}", ""},
+		{"{make-model-slug}", ""},
+		{"Screenshots", ""},
+		{"{modl}/2006", "unknown token {modl}"},
+		{"2006/{seq:}", "unknown token {seq:}"},
+		{"2006/{Model}", "unknown token {Model}"},
+		{"2006/{}", "unknown token {}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			err := ValidateTemplate(tt.tmpl)
+			checkTemplateError(t, err, tt.err)
+			if err != nil && !strings.Contains(err.Error(), "{srcfolder}") {
+				t.Errorf("error %q does not list the supported tokens", err)
+			}
+		})
+	}
+}
+
+func TestValidateFolderTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		err  string
+	}{
+		{"2006/01/02", ""},
+		{"2006", ""},
+		{"Photos/Jan", ""},
+		{"{srcfolder}", ""},
+		{"{decade}/{model-slug}", ""},
+		{"Photos", "every file would get the same path"},
+		{"Photos/Sorted", "every file would get the same path"},
+		{"{modl}", "unknown token {modl}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			checkTemplateError(t, ValidateFolderTemplate(tt.tmpl), tt.err)
+		})
+	}
+}
+
+func TestValidateConstantTemplates(t *testing.T) {
+	opts := Options{OnConflict: ConflictRename, DatePrefer: PreferExif}
+	tests := []struct {
+		name   string
+		modify func(*Options)
+		err    string
+	}{
+		{"constant name", func(opts *Options) { opts.FolderFormat, opts.NameFormat = "2006", "photo" }, ""},
+		{"constant month folder", func(opts *Options) { opts.FolderFormat, opts.MonthFormat = "2006/01/02", "Sparse" }, ""},
+		{"constant folder", func(opts *Options) { opts.FolderFormat = "Sorted" }, "every file would get the same path"},
+		{"unknown name token", func(opts *Options) { opts.FolderFormat, opts.NameFormat = "2006", "{sqe}" }, "unknown token {sqe}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := opts
+			tt.modify(&opts)
+			checkTemplateError(t, opts.Validate(), tt.err)
+		})
+	}
+}
+
+// checkTemplateError fails the test unless err contains want, or is nil when
+// want is empty.
+func checkTemplateError(t *testing.T, err error, want string) {
+	t.Helper()
+	if want == "" && err != nil {
+		t.Errorf("error = %v, want none", err)
+	} else if want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+		t.Errorf("error = %v, want one containing %q", err, want)
+	}
+}
+
+func TestSourceFolder(t *testing.T) {
+	tests := []struct {
+		root string
+		path string
+		want string
+	}{
+		{"src", filepath.Join("src", "IMG_0001.jpg"), ""},
+		{"src", filepath.Join("src", "Holidays", "IMG_0001.jpg"), "Holidays"},
+		{"src", filepath.Join("src", "Holidays", "Paris", "IMG_0001.jpg"), filepath.Join("Holidays", "Paris")},
+		{"src" + string(filepath.Separator), filepath.Join("src", "Holidays", "IMG_0001.jpg"), "Holidays"},
+	}
+	for _, tt := range tests {
+		if got := sourceFolder(tt.root, tt.path); got != tt.want {
+			t.Errorf("sourceFolder(%q, %q) = %q, want %q", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFormatPathSrcFolder(t *testing.T) {
+	date := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tmpl      string
+		srcFolder string
+		want      string
+	}{
+		{"2006/{srcfolder}", "Holidays", "2023/Holidays"},
+		{"{srcfolder}/2006/01", filepath.Join("Holidays", "Paris"), filepath.Join("Holidays", "Paris", "2023", "05")},
+		// Files in the source root land in the date folders alone
+		{"2006/{srcfolder}/01", "", filepath.Join("2023", "05")},
+		// Folder names are not read as date layouts
+		{"{srcfolder}", "Jan 2006", "Jan 2006"},
+	}
+	for _, tt := range tests {
+		got := formatPath(tt.tmpl, tokenContext{date: date, srcFolder: tt.srcFolder})
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("formatPath(%q) with folder %q = %q, want %q", tt.tmpl, tt.srcFolder, got, tt.want)
+		}
+	}
+}
+
+func TestFormatPathDayOfYearEpoch(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		tmpl string
+		date time.Time
+		want string
+	}{
+		{"2006/{dayofyear}", time.Date(2023, 1, 5, 12, 0, 0, 0, time.UTC), "2023/005"},
+		{"2006/{dayofyear}", time.Date(2023, 12, 31, 12, 0, 0, 0, time.UTC), "2023/365"},
+		{"2006/{dayofyear}", time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC), "2024/366"},
+		{"{epoch}", time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), "1682942400"},
+		// The epoch is the same instant whatever the zone, while the day of
+		// the year follows the wall clock
+		{"{epoch}", time.Date(2023, 5, 1, 14, 0, 0, 0, paris), "1682942400"},
+		{"{dayofyear}", time.Date(2023, 1, 1, 0, 30, 0, 0, paris), "001"},
+	}
+	for _, tt := range tests {
+		if got := formatPath(tt.tmpl, tokenContext{date: tt.date}); got != filepath.FromSlash(tt.want) {
+			t.Errorf("formatPath(%q) at %v = %q, want %q", tt.tmpl, tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestBuildPlanSeq(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	paths := []string{filepath.Join(src, "b.jpg"), filepath.Join(src, "a.mp4"), filepath.Join(src, "c.jpg"), filepath.Join(src, "d.jpg")}
+	writeFiles(t, paths...)
+
+	// Files are numbered in date order within their day, whatever the walk order
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	files := []mediaFile{
+		{path: paths[0], date: day.Add(14 * time.Hour)},
+		{path: paths[1], date: day.Add(9 * time.Hour)},
+		{path: paths[2], date: day.Add(24*time.Hour + 8*time.Hour)},
+		{path: paths[3], date: day.Add(12 * time.Hour)},
+	}
+	for _, tt := range []struct {
+		format string
+		want   []string
+	}{
+		{"{seq}", []string{"2023/05/01/003.jpg", "2023/05/01/001.mp4", "2023/05/02/001.jpg", "2023/05/01/002.jpg"}},
+		{"{seq:4}", []string{"2023/05/01/0003.jpg", "2023/05/01/0001.mp4", "2023/05/02/0001.jpg", "2023/05/01/0002.jpg"}},
+	} {
+		opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01/02", NameFormat: tt.format}
+		plan, err := buildPlan(files, opts, NewStats())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want []string
+		for _, name := range tt.want {
+			want = append(want, filepath.Join(dest, filepath.FromSlash(name)))
+		}
+		checkDests(t, plan, want)
+	}
+}
+
+func TestFormatPathSerial(t *testing.T) {
+	file := mediaFile{date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), fields: map[string]interface{}{"SerialNumber": "12345"}}
+	opts := Options{SerialNames: map[string]string{"12345": "CameraA"}}
+	if got, want := formatPath("2006/01/{serial}", fileTokens(file, opts)), filepath.Join("2023", "05", "CameraA"); got != want {
+		t.Errorf("formatPath() = %q, want %q", got, want)
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"beach":          "beach",
+		"cake: day 2":    "cake_ day 2",
+		`a/b\c|d?e*f"g`:  "a_b_c_d_e_f_g",
+		" trailing dot.": "trailing dot",
+		"tab\there":      "tab_here",
+	}
+	for name, want := range tests {
+		if got := sanitizeName(name); got != want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFileNameAppendOriginal(t *testing.T) {
+	file := mediaFile{path: filepath.Join("src", "beach: day 2.jpg"), date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}
+	ctx := tokenContext{date: file.date}
+	tests := []struct {
+		format string
+		append bool
+		want   string
+	}{
+		{"20060102_150405", false, "20230501_120000.jpg"},
+		{"20060102_150405", true, "20230501_120000_beach_ day 2.jpg"},
+		// Without a name format the original name is kept as is
+		{"", true, "beach: day 2.jpg"},
+	}
+	for _, tt := range tests {
+		opts := Options{NameFormat: tt.format, AppendOriginalName: tt.append}
+		if got := fileName(file, ctx, false, opts, nil); got != tt.want {
+			t.Errorf("fileName() with format %q, append %v = %q, want %q", tt.format, tt.append, got, tt.want)
+		}
+	}
+}
+
+func TestBuildPlanIndex(t *testing.T) {
+	dir := t.TempDir()
+	src, dest := filepath.Join(dir, "src"), filepath.Join(dir, "dest")
+	paths := []string{filepath.Join(src, "b.jpg"), filepath.Join(src, "a.jpg"), filepath.Join(src, "c.jpg")}
+	writeFiles(t, paths...)
+
+	// One index runs across every folder, in date order
+	files := []mediaFile{
+		{path: paths[0], date: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)},
+		{path: paths[1], date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)},
+		{path: paths[2], date: time.Date(2023, 5, 2, 12, 0, 0, 0, time.UTC)},
+	}
+	opts := Options{Src: src, Dest: dest, Copy: true, FolderFormat: "2006/01", NameFormat: "{index}_{index:2}"}
+	plan, err := buildPlan(files, opts, NewStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDests(t, plan, []string{
+		filepath.Join(dest, "2023", "06", "000003_03.jpg"),
+		filepath.Join(dest, "2023", "05", "000001_01.jpg"),
+		filepath.Join(dest, "2023", "05", "000002_02.jpg"),
+	})
+}
+
+func TestFormatPathKeyword(t *testing.T) {
+	file := mediaFile{date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), fields: map[string]interface{}{"Subject": []interface{}{"Vacation"}}}
+	if got, want := formatPath("{keyword}/2006", fileTokens(file, Options{})), filepath.Join("Vacation", "2023"); got != want {
+		t.Errorf("formatPath() = %q, want %q", got, want)
+	}
+	file.fields = nil
+	if got, want := formatPath("{keyword}/2006", fileTokens(file, Options{KeywordFallback: "Untagged"})), filepath.Join("Untagged", "2023"); got != want {
+		t.Errorf("formatPath() of an untagged file = %q, want %q", got, want)
+	}
+}
+
+func TestFormatPathModel(t *testing.T) {
+	file := mediaFile{date: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC), fields: map[string]interface{}{"Make": "Canon", "Model": "Canon EOS 5D Mark IV"}}
+	ctx := fileTokens(file, Options{})
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{model}/2006", "Canon EOS 5D Mark IV/2023"},
+		{"{model-slug}/2006", "canon-eos-5d-mark-iv/2023"},
+		{"{make-model-slug}", "canon-eos-5d-mark-iv"},
+	}
+	for _, tt := range tests {
+		if got := formatPath(tt.tmpl, ctx); got != filepath.FromSlash(tt.want) {
+			t.Errorf("formatPath(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"Canon EOS 5D Mark IV", "canon-eos-5d-mark-iv"},
+		{"  DJI -- FC3170  ", "dji-fc3170"},
+		{"Caméra Ærø Straße", "camera-aero-strasse"},
+		{"相机 X100", "x100"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := slugify(tt.value); got != tt.want {
+			t.Errorf("slugify(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormatPathDecadePeriod(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		year int
+		want string
+	}{
+		{"{decade}/2006", 1985, "1980s/1985"},
+		{"{decade}/2006", 2000, "2000s/2000"},
+		{"{period}/2006", 1985, "1980-1989/1985"},
+		{"{period:5}/2006", 1985, "1985-1989/1985"},
+		{"{period:5}/2006", 1984, "1980-1984/1984"},
+		{"{period:25}", 1999, "1975-1999"},
+		// Invalid spans fall back to decades
+		{"{period:0}", 1985, "1980-1989"},
+	}
+	for _, tt := range tests {
+		date := time.Date(tt.year, 5, 1, 12, 0, 0, 0, time.UTC)
+		if got := formatPath(tt.tmpl, tokenContext{date: date}); got != filepath.FromSlash(tt.want) {
+			t.Errorf("formatPath(%q) in %d = %q, want %q", tt.tmpl, tt.year, got, tt.want)
+		}
+	}
+}
+
+func TestFileNameFixExtension(t *testing.T) {
+	logged := useLog(t)
+	tests := []struct {
+		path string
+		ext  string
+		want string
+	}{
+		{"IMG_0001.jpg", "PNG", "IMG_0001.png"},
+		{"clip.mov", "MP4", "clip.mp4"},
+		// Names whose extension already matches are left alone
+		{"IMG_0001.JPEG", "JPG", "IMG_0001.JPEG"},
+		{"IMG_0001.JPG", "JPG", "IMG_0001.JPG"},
+		{"IMG_0001.jpg", "", "IMG_0001.jpg"},
+	}
+	for _, tt := range tests {
+		file := mediaFile{path: filepath.Join("src", tt.path), fields: map[string]interface{}{"FileTypeExtension": tt.ext}}
+		if got := fileName(file, tokenContext{}, false, Options{FixExtension: true}, log.Default()); got != tt.want {
+			t.Errorf("fileName(%q) of a %s = %q, want %q", tt.path, tt.ext, got, tt.want)
+		}
+	}
+	if n := strings.Count(logged.String(), "Fixing extension"); n != 2 {
+		t.Errorf("logged %d corrections, want 2", n)
+	}
+
+	// Without the option misnamed files keep their extension
+	file := mediaFile{path: "IMG_0001.jpg", fields: map[string]interface{}{"FileTypeExtension": "PNG"}}
+	if got := fileName(file, tokenContext{}, false, Options{}, log.Default()); got != "IMG_0001.jpg" {
+		t.Errorf("fileName() = %q without fixing extensions", got)
+	}
+}
@@ -0,0 +1,108 @@
+package sorter
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/pkg/errors"
+)
+
+// volumeSet spreads the files of a plan across destination roots, filling
+// each until it would have less than minFree bytes free.
+type volumeSet struct {
+	roots   []string
+	free    []int64
+	minFree int64
+}
+
+// newVolumeSet measures the free space of every root. Roots whose free space
+// cannot be measured are only used as the last resort.
+func newVolumeSet(roots []string, minFree int64) *volumeSet {
+	v := &volumeSet{roots: roots, free: make([]int64, len(roots)), minFree: minFree}
+	for i, root := range roots {
+		free, err := freeSpace(existingAncestor(root))
+		if err != nil {
+			log.Error("Error while measuring free space", "dest", root, "err", err)
+			free = -1
+		}
+		v.free[i] = free
+	}
+	return v
+}
+
+// pick returns the root a file of the given size is sorted into at the path
+// rel below it, and whether a file is already there. A root already holding a
+// file at rel keeps it, so re-runs find their earlier files and the same name
+// never lands on two volumes. Otherwise the first root with room is picked,
+// and the last when none has any. The file's size is only taken off the
+// root's free space by reserve, once it is known to be written there.
+func (v *volumeSet) pick(rel string, size int64) (string, bool) {
+	for _, root := range v.roots {
+		if fileExists(filepath.Join(root, rel)) {
+			return root, true
+		}
+	}
+	for i, root := range v.roots {
+		if v.free[i] >= 0 && v.free[i]-size >= v.minFree {
+			return root, false
+		}
+	}
+	return v.roots[len(v.roots)-1], false
+}
+
+// reserve takes the size of a file about to be written into root off its
+// free space.
+func (v *volumeSet) reserve(root string, size int64) {
+	for i := range v.roots {
+		if v.roots[i] != root {
+			continue
+		}
+		if v.free[i] < 0 || v.free[i]-size < v.minFree {
+			log.Warn("No destination volume has room left, using the last one", "dest", root, "size", size)
+		}
+		v.free[i] -= size
+		return
+	}
+}
+
+// destRoots returns the roots files are sorted into: the volumes when set,
+// or the destination.
+func (opts Options) destRoots() []string {
+	if len(opts.Volumes) > 0 {
+		return opts.Volumes
+	}
+	return []string{opts.Dest}
+}
+
+// existingAncestor returns path or its closest existing parent, so the free
+// space of a destination can be measured before it is created.
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// checkFreeSpace returns ErrLowFreeSpace when sorting entry would leave less
+// than minFree bytes free on the filesystem of its destination.
+func checkFreeSpace(entry PlanEntry, minFree int64) error {
+	info, err := os.Stat(entry.Src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	free, err := freeSpace(existingAncestor(filepath.Dir(entry.Dest)))
+	if err != nil {
+		return err
+	}
+	if free-info.Size() < minFree {
+		return ErrLowFreeSpace
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package sorter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := freeSpace(dir); err != nil {
+		t.Skip("cannot measure free space:", err)
+	}
+	src := filepath.Join(dir, "src", "IMG_0001.jpg")
+	writeFiles(t, src)
+
+	// The destination is measured on its closest existing parent
+	entry := PlanEntry{Src: src, Dest: filepath.Join(dir, "dest", "2023", "05", "IMG_0001.jpg")}
+	if got := existingAncestor(filepath.Dir(entry.Dest)); got != dir {
+		t.Errorf("existingAncestor() = %q, want %q", got, dir)
+	}
+	if err := checkFreeSpace(entry, 1); err != nil {
+		t.Errorf("checkFreeSpace() error = %v, want none", err)
+	}
+	if err := checkFreeSpace(entry, 1<<62); err != ErrLowFreeSpace {
+		t.Errorf("checkFreeSpace() error = %v, want %v", err, ErrLowFreeSpace)
+	}
+	entry.Src = filepath.Join(dir, "src", "missing.jpg")
+	if err := checkFreeSpace(entry, 1); err == nil || err == ErrLowFreeSpace {
+		t.Errorf("checkFreeSpace() error = %v for a missing file, want a stat error", err)
+	}
+}